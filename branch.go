@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checkBranchName rejects names that cannot live under refs/heads/
+func checkBranchName(name string) error {
+	if name == "" || name == "HEAD" || strings.HasPrefix(name, "-") || checkRefName("refs/heads/"+name) != nil {
+		return fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	return nil
+}
+
+// branch is `branch [--list] [-v] [<pattern>...]`, `branch [-f] <name> [<start>]`,
+// `branch (-m|-M) [<old>] <new>` and `branch (-d|-D) <name>...`. listing marks the
+// current branch with `*` and -v adds each tip's short hash and subject. new branches
+// start at HEAD unless a start point is given, renames carry the reflog along and -d
+// only deletes branches HEAD has merged, -D deletes them anyway.
+func branch(runEnv string, args []string) (string, error) {
+	mode, force, verbose := "", false, false
+	var rest []string
+	for _, arg := range args {
+		switch arg {
+		case "-l", "--list":
+			mode = "list"
+		case "-v", "-vv", "--verbose":
+			verbose = true
+		case "-d", "--delete":
+			mode = "delete"
+		case "-D":
+			mode, force = "delete", true
+		case "-m", "--move":
+			mode = "move"
+		case "-M":
+			mode, force = "move", true
+		case "-f", "--force":
+			force = true
+		case "--show-current":
+			mode = "current"
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return "", fmt.Errorf("branch err: unknown option %s", arg)
+			}
+			rest = append(rest, arg)
+		}
+	}
+	if mode == "" {
+		mode = "create"
+		if len(rest) == 0 || verbose {
+			mode = "list"
+		}
+	}
+
+	current, head, err := headCommit(runEnv)
+	if err != nil {
+		return "", fmt.Errorf("branch err: %w", err)
+	}
+	switch mode {
+	case "list":
+		out, err := listBranches(runEnv, current, head, rest, verbose)
+		if err != nil {
+			return "", fmt.Errorf("branch err: %w", err)
+		}
+		return out, nil
+	case "current":
+		if current == "" {
+			return "", nil
+		}
+		return current + "\n", nil
+	case "delete":
+		if len(rest) == 0 {
+			return "", errors.New("branch err: branch name required")
+		}
+		var sb strings.Builder
+		for _, name := range rest {
+			out, err := deleteBranch(runEnv, name, current, head, force)
+			if err != nil {
+				return sb.String(), fmt.Errorf("branch err: %w", err)
+			}
+			sb.WriteString(out)
+		}
+		return sb.String(), nil
+	case "move":
+		if len(rest) == 0 || len(rest) > 2 {
+			return "", errors.New("branch err: usage branch -m [<old>] <new>")
+		}
+		if len(rest) == 1 {
+			if current == "" {
+				return "", errors.New("branch err: cannot rename the current branch while not on any")
+			}
+			rest = []string{current, rest[0]}
+		}
+		if err := renameBranch(runEnv, rest[0], rest[1], force); err != nil {
+			return "", fmt.Errorf("branch err: %w", err)
+		}
+		return "", nil
+	}
+
+	if len(rest) > 2 {
+		return "", errors.New("branch err: usage branch [-f] <name> [<start>]")
+	}
+	start := "HEAD"
+	if len(rest) == 2 {
+		start = rest[1]
+	}
+	if err := createBranch(runEnv, rest[0], start, current, force); err != nil {
+		return "", fmt.Errorf("branch err: %w", err)
+	}
+	return "", nil
+}
+
+// listBranches prints the branches matching the glob patterns (all without any), with a
+// `* (HEAD detached at <hash>)` line first when HEAD is not on a branch and nothing is filtered
+func listBranches(runEnv, current, head string, patterns []string, verbose bool) (string, error) {
+	refs, err := listRefs(runEnv, "refs/heads/")
+	if err != nil {
+		return "", err
+	}
+	store := newObjectStore(runEnv)
+	type line struct {
+		mark byte
+		name string
+		hash string
+	}
+	var lines []line
+	if current == "" && len(patterns) == 0 {
+		lines = append(lines, line{'*', fmt.Sprintf("(HEAD detached at %s)", abbrev(store, head, 7)), head})
+	}
+	for _, ref := range refs {
+		name := strings.TrimPrefix(ref.name, "refs/heads/")
+		matched := len(patterns) == 0
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = true
+			}
+		}
+		if !matched {
+			continue
+		}
+		mark := byte(' ')
+		if name == current {
+			mark = '*'
+		}
+		lines = append(lines, line{mark, name, ref.hash})
+	}
+
+	width := 0
+	for _, l := range lines {
+		width = max(width, len(l.name))
+	}
+	var sb strings.Builder
+	for _, l := range lines {
+		if !verbose {
+			fmt.Fprintf(&sb, "%c %s\n", l.mark, l.name)
+			continue
+		}
+		subject := ""
+		if c, err := readCommit(store, l.hash); err == nil {
+			subject = messageSubject(c.Message)
+		}
+		fmt.Fprintf(&sb, "%c %-*s %s %s\n", l.mark, width, l.name, abbrev(store, l.hash, 7), subject)
+	}
+	return sb.String(), nil
+}
+
+// createBranch points refs/heads/<name> at the commit start names, -f moves an existing branch
+func createBranch(runEnv, name, start, current string, force bool) error {
+	if err := checkBranchName(name); err != nil {
+		return err
+	}
+	ref := "refs/heads/" + name
+	_, existing, err := resolveRef(runEnv, ref)
+	if err != nil {
+		return err
+	}
+	if existing != "" && !force {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	if existing != "" && name == current {
+		return fmt.Errorf("cannot force update the current branch '%s'", name)
+	}
+	hash, err := resolveCommit(runEnv, start)
+	if err != nil {
+		return fmt.Errorf("not a valid object name: '%s'", start)
+	}
+	old, msg := zeroHash, "branch: Created from "+start
+	if existing != "" {
+		old, msg = existing, "branch: Reset to "+start
+	}
+	return updateRef(runEnv, ref, hash, old, msg)
+}
+
+// renameBranch moves a branch and its reflog, -M replaces a branch that has the new name
+func renameBranch(runEnv, oldName, newName string, force bool) error {
+	if err := checkBranchName(newName); err != nil {
+		return err
+	}
+	oldRef, newRef := "refs/heads/"+oldName, "refs/heads/"+newName
+	_, hash, err := resolveRef(runEnv, oldRef)
+	if err != nil {
+		return err
+	}
+	if raw, _ := readRawRef(runEnv, "HEAD"); hash == "" && raw != symrefPrefix+oldRef {
+		return fmt.Errorf("no branch named '%s'", oldName)
+	}
+	_, existing, err := resolveRef(runEnv, newRef)
+	if err != nil {
+		return err
+	}
+	if existing != "" && oldName != newName {
+		if !force {
+			return fmt.Errorf("a branch named '%s' already exists", newName)
+		}
+		if err := deleteRef(runEnv, newRef, existing); err != nil {
+			return err
+		}
+	}
+	if oldName == newName {
+		return nil
+	}
+	return renameRef(runEnv, oldRef, newRef, fmt.Sprintf("Branch: renamed %s to %s", oldRef, newRef))
+}
+
+// deleteBranch removes a branch, without force only when HEAD already contains its tip
+func deleteBranch(runEnv, name, current, head string, force bool) (string, error) {
+	ref := "refs/heads/" + name
+	_, hash, err := resolveRef(runEnv, ref)
+	if err != nil {
+		return "", err
+	}
+	if hash == "" {
+		return "", fmt.Errorf("branch '%s' not found", name)
+	}
+	if name == current {
+		root, _ := filepath.Abs(workTree(runEnv))
+		return "", fmt.Errorf("cannot delete branch '%s' checked out at '%s'", name, root)
+	}
+	if !force {
+		merged := false
+		if head != "" {
+			reachable, err := newRevWalk(runEnv).hidden([]string{head})
+			if err != nil {
+				return "", err
+			}
+			merged = reachable[hash]
+		}
+		if !merged {
+			return "", fmt.Errorf("the branch '%s' is not fully merged, if you are sure you want to delete it run 'branch -D %s'", name, name)
+		}
+	}
+	if err := deleteRef(runEnv, ref, hash); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Deleted branch %s (was %s).\n", name, abbrev(newObjectStore(runEnv), hash, 7)), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBranch(t *testing.T) {
+	setupWorktree(t, nil)
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	for _, msg := range []string{"one", "two"} {
+		if _, err := commit("test", []string{"--allow-empty", "-m", msg}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, two, _ := headCommit("test")
+	one, _ := resolveRevision("test", "HEAD~1")
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := branch("test", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("should create branches and list them", func(t *testing.T) {
+		run("old", "HEAD~1")
+		run("topic")
+		if got := run(); got != "* main\n  old\n  topic\n" {
+			t.Fatalf("got %q", got)
+		}
+		want := "* main  " + two[:7] + " two\n" + "  old   " + one[:7] + " one\n" + "  topic " + two[:7] + " two\n"
+		if got := run("-v"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if _, err := branch("test", []string{"topic"}); err == nil {
+			t.Fatal("expected already exists error")
+		}
+		if _, err := branch("test", []string{"bad..name"}); err == nil {
+			t.Fatal("expected invalid name error")
+		}
+		run("-f", "topic", "old")
+		if _, hash, _ := resolveRef("test", "refs/heads/topic"); hash != one {
+			t.Fatalf("topic was not reset, it is at %s", hash)
+		}
+	})
+
+	t.Run("should rename with the reflog and follow HEAD", func(t *testing.T) {
+		run("-m", "trunk")
+		raw, _ := readRawRef("test", "HEAD")
+		if raw != "ref: refs/heads/trunk" {
+			t.Fatalf("HEAD is %q", raw)
+		}
+		entries, err := readReflog("test", "refs/heads/trunk")
+		if err != nil || len(entries) != 3 || entries[2].msg != "Branch: renamed refs/heads/main to refs/heads/trunk" {
+			t.Fatalf("unexpected reflog %+v %v", entries, err)
+		}
+		if _, err := os.Stat("tmp/.git/logs/refs/heads/main"); !os.IsNotExist(err) {
+			t.Fatal("the old reflog is still there")
+		}
+		if _, err := branch("test", []string{"-m", "old", "topic"}); err == nil {
+			t.Fatal("expected already exists error")
+		}
+		run("-M", "old", "topic")
+		if got := run("--list", "t*"); got != "  topic\n* trunk\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should only delete merged branches without -D", func(t *testing.T) {
+		if _, err := branch("test", []string{"-d", "trunk"}); err == nil {
+			t.Fatal("expected checked out branch error")
+		}
+		if got := run("-d", "topic"); got != "Deleted branch topic (was "+one[:7]+").\n" {
+			t.Fatalf("got %q", got)
+		}
+		run("ahead")
+		if err := updateRef("test", "HEAD", one, "", "reset"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := branch("test", []string{"-d", "ahead"}); err == nil || !strings.Contains(err.Error(), "not fully merged") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		run("-D", "ahead")
+		if got := run(); got != "* trunk\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should delete a nested branch that is only packed", func(t *testing.T) {
+		if err := os.WriteFile("tmp/.git/packed-refs", []byte("# pack-refs with: peeled fully-peeled sorted \n"+one+" refs/heads/feature/x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got := run("-d", "feature/x"); got != "Deleted branch feature/x (was "+one[:7]+").\n" {
+			t.Fatalf("got %q", got)
+		}
+		if _, err := os.Stat("tmp/.git/refs/heads/feature"); !os.IsNotExist(err) {
+			t.Fatal("lock directory was left behind")
+		}
+		if got := run(); got != "* trunk\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+}
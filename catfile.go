@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errMissingObject is what `cat-file -e` reports for an object that is not in the store,
+// main turns it into a non zero exit without a message
+var errMissingObject = errors.New("object does not exist")
+
+// prettyTree prints a tree like `cat-file -p`, one `mode type sha\tname` line per entry
+func prettyTree(payload []byte) (string, error) {
+	entries, err := parseTree(payload)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&sb, "%06o %s %s\t%s\n", e.mode, e.objectType(), e.hash, e.name)
+	}
+	return sb.String(), nil
+}
+
+// catFile is `cat-file (-t|-s|-e|-p) <object>` or `cat-file <type> <object>`. -t and -s print
+// the type and size, -e only checks the object exists, -p pretty prints it (trees as
+// `mode type sha\tname` lines) and <type> prints the raw content after peeling the object
+// to that type, so `cat-file commit v1` shows the commit a tag points at.
+func catFile(runEnv string, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", errors.New("cat-file err: usage cat-file (-t|-s|-e|-p|<type>) <object>")
+	}
+	mode, rev := args[0], args[1]
+	store := newObjectStore(runEnv)
+	hash, err := resolveRevision(runEnv, rev)
+	if err != nil {
+		if mode == "-e" {
+			return "", errMissingObject
+		}
+		return "", fmt.Errorf("cat-file err: not a valid object name %s: %w", rev, err)
+	}
+
+	switch mode {
+	case "-e":
+		if !store.Has(hash) {
+			return "", errMissingObject
+		}
+		return "", nil
+	case "-t", "-s":
+		kind, size, err := store.Stat(hash)
+		if err != nil {
+			return "", fmt.Errorf("cat-file err: %s: bad file", rev)
+		}
+		if mode == "-t" {
+			return kind + "\n", nil
+		}
+		return fmt.Sprintf("%d\n", size), nil
+	case "-p":
+		kind, payload, err := store.Get(hash)
+		if err != nil {
+			return "", fmt.Errorf("cat-file err: %s: %w", rev, err)
+		}
+		if kind != "tree" {
+			return string(payload), nil
+		}
+		out, err := prettyTree(payload)
+		if err != nil {
+			return "", fmt.Errorf("cat-file err: %s: %w", rev, err)
+		}
+		return out, nil
+	case "blob", "tree", "commit", "tag":
+		peeled, err := peelObject(store, hash, mode)
+		if err != nil {
+			return "", fmt.Errorf("cat-file err: %s: bad file", rev)
+		}
+		_, payload, err := store.Get(peeled)
+		if err != nil {
+			return "", fmt.Errorf("cat-file err: %s: %w", rev, err)
+		}
+		return string(payload), nil
+	}
+	return "", fmt.Errorf("cat-file err: unknown option or object type %s", mode)
+}
+
+// defaultBatchFormat is the header `cat-file --batch` prints for every object
+const defaultBatchFormat = "%(objectname) %(objecttype) %(objectsize)"
+
+// isBatch reports whether cat-file was asked to stream objects instead of showing one
+func isBatch(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--batch") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandBatchFormat fills the %(objectname), %(objecttype), %(objectsize) and %(rest)
+// atoms of a --batch format, anything else is copied as is
+func expandBatchFormat(format, hash, kind string, size int, rest string) string {
+	var sb strings.Builder
+	for {
+		i := strings.Index(format, "%(")
+		if i < 0 {
+			sb.WriteString(format)
+			return sb.String()
+		}
+		end := strings.IndexByte(format[i:], ')')
+		if end < 0 {
+			sb.WriteString(format)
+			return sb.String()
+		}
+		sb.WriteString(format[:i])
+		switch format[i+2 : i+end] {
+		case "objectname":
+			sb.WriteString(hash)
+		case "objecttype":
+			sb.WriteString(kind)
+		case "objectsize":
+			fmt.Fprintf(&sb, "%d", size)
+		case "rest":
+			sb.WriteString(rest)
+		}
+		format = format[i+end+1:]
+	}
+}
+
+// checkBatchFormat rejects atoms expandBatchFormat does not know
+func checkBatchFormat(format string) error {
+	for rest := format; ; {
+		i := strings.Index(rest, "%(")
+		if i < 0 {
+			return nil
+		}
+		end := strings.IndexByte(rest[i:], ')')
+		if end < 0 {
+			return nil
+		}
+		switch atom := rest[i+2 : i+end]; atom {
+		case "objectname", "objecttype", "objectsize", "rest":
+		default:
+			return fmt.Errorf("unknown format element: %%(%s)", atom)
+		}
+		rest = rest[i+end+1:]
+	}
+}
+
+// catFileBatch is `cat-file (--batch[=<format>]|--batch-check[=<format>]) [--batch-all-objects]
+// [--buffer]`. every line read from stdin names an object and gets a `<sha> <type> <size>`
+// header (or the format given) back, --batch follows it with the content and a newline.
+// names that do not resolve print `<name> missing`. --batch-all-objects lists every object
+// in the store instead of reading stdin and --buffer only flushes the output at the end.
+func catFileBatch(runEnv string, args []string, stdin io.Reader, stdout io.Writer) error {
+	format, contents, all, buffer := "", false, false, false
+	for _, arg := range args {
+		switch {
+		case arg == "--batch" || arg == "--batch-check":
+			format, contents = defaultBatchFormat, arg == "--batch"
+		case strings.HasPrefix(arg, "--batch="):
+			format, contents = strings.TrimPrefix(arg, "--batch="), true
+		case strings.HasPrefix(arg, "--batch-check="):
+			format, contents = strings.TrimPrefix(arg, "--batch-check="), false
+		case arg == "--batch-all-objects":
+			all = true
+		case arg == "--buffer":
+			buffer = true
+		default:
+			return fmt.Errorf("cat-file err: unexpected argument %s in batch mode", arg)
+		}
+	}
+	if format == "" {
+		return errors.New("cat-file err: --batch-all-objects and --buffer need --batch or --batch-check")
+	}
+	if err := checkBatchFormat(format); err != nil {
+		return fmt.Errorf("cat-file err: %w", err)
+	}
+	// without %(rest) the whole line is the object name, spaces included
+	splitRest := strings.Contains(format, "%(rest)")
+
+	store := newObjectStore(runEnv)
+	out := bufio.NewWriter(stdout)
+	show := func(name, rest string) error {
+		hash := name
+		if len(name) != 40 || !isHex(name) {
+			resolved, err := resolveRevision(runEnv, name)
+			if err != nil {
+				if errors.Is(err, errAmbiguousObject) {
+					_, err = fmt.Fprintf(out, "%s ambiguous\n", name)
+					return err
+				}
+				_, err = fmt.Fprintf(out, "%s missing\n", name)
+				return err
+			}
+			hash = resolved
+		}
+		var kind string
+		var size int
+		var payload []byte
+		var err error
+		if contents {
+			kind, payload, err = store.Get(hash)
+			size = len(payload)
+		} else {
+			kind, size, err = store.Stat(hash)
+		}
+		if errors.Is(err, errObjectNotFound) {
+			_, err = fmt.Fprintf(out, "%s missing\n", name)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "%s\n", expandBatchFormat(format, hash, kind, size, rest)); err != nil {
+			return err
+		}
+		if contents {
+			out.Write(payload)
+			out.WriteByte('\n')
+		}
+		if !buffer {
+			return out.Flush()
+		}
+		return nil
+	}
+
+	if all {
+		hashes, err := store.List()
+		if err != nil {
+			return fmt.Errorf("cat-file err: %w", err)
+		}
+		for _, hash := range hashes {
+			if err := show(hash, ""); err != nil {
+				return fmt.Errorf("cat-file err: %w", err)
+			}
+		}
+		return out.Flush()
+	}
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		name, rest := scanner.Text(), ""
+		if splitRest {
+			name, rest, _ = strings.Cut(strings.TrimLeft(name, " \t"), " ")
+		}
+		if err := show(name, rest); err != nil {
+			return fmt.Errorf("cat-file err: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cat-file err: failed to read stdin: %w", err)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCatFileModes(t *testing.T) {
+	setupWorktree(t, map[string]string{"d/f.txt": "f\n", "g.txt": "hello\n"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	if err := add("test", []string{"-A"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit("test", []string{"-m", "root"}, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	store := newObjectStore("test")
+	_, head, _ := headCommit("test")
+	tree, _ := writeTree("test", "")
+	files, _ := flattenTree(store, tree)
+	sub, _ := resolveRevision("test", "HEAD:d")
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := catFile("test", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("should print type and size", func(t *testing.T) {
+		if got := run("-t", "HEAD"); got != "commit\n" {
+			t.Fatalf("got %q", got)
+		}
+		if got := run("-t", "HEAD:d"); got != "tree\n" {
+			t.Fatalf("got %q", got)
+		}
+		if got := run("-s", "HEAD:g.txt"); got != "6\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should pretty print trees", func(t *testing.T) {
+		want := "040000 tree " + sub + "\td\n" +
+			"100644 blob " + files["g.txt"].hash + "\tg.txt\n"
+		if got := run("-p", "HEAD^{tree}"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got := run("-p", head); !strings.HasPrefix(got, "tree "+tree+"\n") {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should peel to the asked type", func(t *testing.T) {
+		_, payload, _ := store.Get(tree)
+		if got := run("tree", "HEAD"); got != string(payload) {
+			t.Fatalf("got %q, want %q", got, payload)
+		}
+		if _, err := catFile("test", []string{"commit", "HEAD^{tree}"}); err == nil {
+			t.Fatal("a tree should not peel to a commit")
+		}
+	})
+
+	t.Run("should only report existence with -e", func(t *testing.T) {
+		if got := run("-e", "HEAD"); got != "" {
+			t.Fatalf("got %q", got)
+		}
+		if _, err := catFile("test", []string{"-e", strings.Repeat("1", 40)}); !errors.Is(err, errMissingObject) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestCatFileBatch(t *testing.T) {
+	setupWorktree(t, nil)
+	store := newObjectStore("test")
+	hello, _ := store.Put("blob", []byte("hello\n"))
+	other, _ := store.Put("blob", []byte("other"))
+	run := func(stdin string, args ...string) string {
+		t.Helper()
+		var out strings.Builder
+		if err := catFileBatch("test", args, strings.NewReader(stdin), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	t.Run("should print headers and contents for each line", func(t *testing.T) {
+		want := hello + " blob 6\nhello\n\n" + "nope missing\n" + other + " blob 5\nother\n"
+		if got := run(hello+"\nnope\n"+other[:8]+"\n", "--batch"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got := run(hello+"\n", "--batch-check"); got != hello+" blob 6\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should expand custom formats", func(t *testing.T) {
+		if got := run(hello+" some rest\n", "--batch-check=%(objecttype)|%(objectsize)|%(rest)"); got != "blob|6|some rest\n" {
+			t.Fatalf("got %q", got)
+		}
+		if err := catFileBatch("test", []string{"--batch-check=%(deltabase)"}, strings.NewReader(""), &strings.Builder{}); err == nil {
+			t.Fatal("expected unknown format element error")
+		}
+	})
+
+	t.Run("should list every object without reading stdin", func(t *testing.T) {
+		want := []string{hello + " blob 6", other + " blob 5"}
+		if hello > other {
+			want[0], want[1] = want[1], want[0]
+		}
+		if got := run("ignored\n", "--batch-check", "--batch-all-objects", "--buffer"); got != strings.Join(want, "\n")+"\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkoutEntry writes the blob of a flattened tree entry into the work tree and returns
+// the stat data for its index entry. submodules only get their directory.
+func checkoutEntry(runEnv string, store *ObjectStore, e treeEntry) (os.FileInfo, error) {
+	full := worktreePath(runEnv, e.name)
+	if e.mode == modeGitlink {
+		if err := os.MkdirAll(full, 0o755); err != nil {
+			return nil, err
+		}
+		return os.Lstat(full)
+	}
+	_, data, err := store.Get(e.hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return nil, err
+	}
+	// a fresh file so the mode is the one from the tree, an empty directory in the way goes too
+	if err := os.Remove(full); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	switch e.mode {
+	case modeSymlink:
+		err = os.Symlink(string(data), full)
+	case modeExecutable:
+		err = os.WriteFile(full, data, 0o755)
+	default:
+		err = os.WriteFile(full, data, 0o644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(full)
+}
+
+// checkoutTree moves the index and the work tree from oldTree to newTree like
+// `read-tree -m -u`. paths both trees agree on keep their local changes, the others are
+// rewritten but only while the index and the file on disk still match oldTree, untracked
+// files are never overwritten. force rewrites every tracked path and drops local changes.
+func checkoutTree(runEnv, oldTree, newTree string, force bool) error {
+	store := newObjectStore(runEnv)
+	oldFiles, err := flattenTree(store, oldTree)
+	if err != nil {
+		return err
+	}
+	newFiles, err := flattenTree(store, newTree)
+	if err != nil {
+		return err
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return err
+	}
+
+	paths := map[string]bool{}
+	for p := range oldFiles {
+		paths[p] = true
+	}
+	for p := range newFiles {
+		paths[p] = true
+	}
+	for _, e := range idx.Entries {
+		if e.stage() != 0 && !force {
+			return errors.New("you need to resolve your current index first")
+		}
+		if force {
+			paths[e.Path] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	// replacedByDir is true when a tracked file at a parent of p goes away to make room
+	replacedByDir := func(p string) bool {
+		for dir := p; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndex(dir, "/")]
+			if _, ok := oldFiles[dir]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	// inTheWay is true when something on disk at p is not a tracked file that goes away,
+	// a directory only counts when it holds files oldTree does not know
+	inTheWay := func(p string) (bool, error) {
+		full := worktreePath(runEnv, p)
+		info, err := os.Lstat(full)
+		if errors.Is(err, os.ErrNotExist) || replacedByDir(p) {
+			return false, nil
+		}
+		if err != nil || !info.IsDir() {
+			return true, nil
+		}
+		blocked := false
+		err = filepath.WalkDir(full, func(sub string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(workTree(runEnv), sub)
+			if err != nil {
+				return err
+			}
+			if _, ok := oldFiles[filepath.ToSlash(rel)]; !ok {
+				blocked = true
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		return blocked, err
+	}
+	var removals, writes, dirty, untracked []string
+	for _, p := range sorted {
+		o, inOld := oldFiles[p]
+		n, inNew := newFiles[p]
+		ie := idx.find(p)
+		if force {
+			if inNew {
+				writes = append(writes, p)
+			} else {
+				removals = append(removals, p)
+			}
+			continue
+		}
+		if inOld == inNew && (!inOld || (o.hash == n.hash && o.mode == n.mode)) {
+			continue
+		}
+		indexHolds := func(e treeEntry, ok bool) bool {
+			if !ok {
+				return ie == nil
+			}
+			return ie != nil && ie.Hash == e.hash && ie.Mode == e.mode
+		}
+		if indexHolds(n, inNew) {
+			// already staged the way the target has it
+			continue
+		}
+		switch {
+		case !indexHolds(o, inOld):
+			dirty = append(dirty, p)
+			continue
+		case ie != nil:
+			changed, err := worktreeChanged(runEnv, ie)
+			if err != nil {
+				return err
+			}
+			if changed {
+				dirty = append(dirty, p)
+				continue
+			}
+		default:
+			blocked, err := inTheWay(p)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				untracked = append(untracked, p)
+				continue
+			}
+		}
+		if inNew {
+			writes = append(writes, p)
+		} else {
+			removals = append(removals, p)
+		}
+	}
+
+	var problems []string
+	if len(dirty) > 0 {
+		problems = append(problems, fmt.Sprintf("your local changes to the following files would be overwritten by checkout:\n\t%s\nplease commit your changes or stash them before you switch branches", strings.Join(dirty, "\n\t")))
+	}
+	if len(untracked) > 0 {
+		problems = append(problems, fmt.Sprintf("the following untracked working tree files would be overwritten by checkout:\n\t%s\nplease move or remove them before you switch branches", strings.Join(untracked, "\n\t")))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	for i := len(removals) - 1; i >= 0; i-- {
+		p := removals[i]
+		if err := os.Remove(worktreePath(runEnv, p)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+		idx.remove(p)
+		removeEmptyParents(runEnv, p)
+	}
+	for _, p := range writes {
+		info, err := checkoutEntry(runEnv, store, newFiles[p])
+		if err != nil {
+			return fmt.Errorf("failed to check out %s: %w", p, err)
+		}
+		idx.set(newIndexEntry(p, info, newFiles[p].hash))
+	}
+	return idx.write(runEnv)
+}
+
+// localChanges lists what still differs from the new HEAD after a switch, `M\tpath` style
+func localChanges(runEnv string) (string, error) {
+	st, err := collectStatus(runEnv, "no")
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, e := range st.entries {
+		letter := e.staged
+		if letter == ' ' || e.unstaged == 'D' {
+			letter = e.unstaged
+		}
+		fmt.Fprintf(&sb, "%c\t%s\n", letter, e.path)
+	}
+	return sb.String(), nil
+}
+
+// switchRequest is what checkout and switch were asked to do. create names a branch to
+// make at target (HEAD when empty), reset lets it replace an existing one and detach
+// moves HEAD off any branch.
+type switchRequest struct {
+	cmd    string
+	target string
+	create string
+	reset  bool
+	detach bool
+	force  bool
+	quiet  bool
+}
+
+// switchTo moves HEAD to a branch or a detached commit, updating the index and the work
+// tree on the way, and logs `checkout: moving from <old> to <new>` in HEAD's reflog
+func switchTo(runEnv string, req switchRequest) (string, error) {
+	current, head, err := headCommit(runEnv)
+	if err != nil {
+		return "", err
+	}
+	store := newObjectStore(runEnv)
+	target := req.target
+	if target == "-" {
+		target = "@{-1}"
+	}
+	if strings.HasPrefix(target, "@{-") && strings.HasSuffix(target, "}") && req.create == "" {
+		// @{-n} switches back to the branch itself, not just its commit
+		var n int
+		if _, err := fmt.Sscanf(target, "@{-%d}", &n); err == nil && n > 0 {
+			prev, err := previousBranch(runEnv, n)
+			if err != nil {
+				return "", err
+			}
+			target = prev
+		}
+	}
+
+	branch, start := "", target
+	switch {
+	case req.create != "":
+		if err := checkBranchName(req.create); err != nil {
+			return "", err
+		}
+		if _, existing, err := resolveRef(runEnv, "refs/heads/"+req.create); err != nil || (existing != "" && !req.reset) {
+			if err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("a branch named '%s' already exists", req.create)
+		}
+		branch = req.create
+		if start == "" {
+			start = "HEAD"
+		}
+	case req.detach:
+		if start == "" {
+			start = "HEAD"
+		}
+	case target == "":
+		return "", fmt.Errorf("%s needs a branch or a commit", req.cmd)
+	default:
+		if _, hash, err := resolveRef(runEnv, "refs/heads/"+target); err == nil && hash != "" {
+			branch = target
+		} else if req.cmd == "switch" {
+			if _, err := resolveCommit(runEnv, target); err == nil {
+				return "", fmt.Errorf("a branch is expected, got commit '%s', use --detach to detach HEAD at it", target)
+			}
+			return "", fmt.Errorf("invalid reference: %s", target)
+		}
+	}
+
+	var hash string
+	if branch != "" && req.create == "" {
+		_, hash, err = resolveRef(runEnv, "refs/heads/"+branch)
+	} else {
+		hash, err = resolveCommit(runEnv, start)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	oldTree := ""
+	if head != "" {
+		if oldTree, err = commitTreeHash(store, head); err != nil {
+			return "", err
+		}
+	}
+	newTree, err := commitTreeHash(store, hash)
+	if err != nil {
+		return "", err
+	}
+	if err := checkoutTree(runEnv, oldTree, newTree, req.force); err != nil {
+		return "", err
+	}
+
+	from := current
+	if from == "" {
+		from = head
+	}
+	to := target
+	if branch != "" {
+		to = branch
+	} else if to == "" {
+		to = "HEAD"
+	}
+	msg := fmt.Sprintf("checkout: moving from %s to %s", from, to)
+	var sb strings.Builder
+	if req.create != "" {
+		if err := createBranch(runEnv, req.create, start, "", req.reset); err != nil {
+			return "", err
+		}
+	}
+	if branch != "" {
+		if err := setSymbolicRef(runEnv, "HEAD", "refs/heads/"+branch, msg); err != nil {
+			return "", err
+		}
+	} else {
+		if err := detachHead(runEnv, hash, msg); err != nil {
+			return "", err
+		}
+	}
+	if req.quiet {
+		return "", nil
+	}
+
+	changes, err := localChanges(runEnv)
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(changes)
+	describe := func(h string) string {
+		subject := ""
+		if c, err := readCommit(store, h); err == nil {
+			subject = messageSubject(c.Message)
+		}
+		return abbrev(store, h, 7) + " " + subject
+	}
+	if current == "" && head != "" && head != hash {
+		fmt.Fprintf(&sb, "Previous HEAD position was %s\n", describe(head))
+	}
+	switch {
+	case branch == "":
+		fmt.Fprintf(&sb, "HEAD is now at %s\n", describe(hash))
+	case req.create != "":
+		fmt.Fprintf(&sb, "Switched to a new branch '%s'\n", branch)
+	case branch == current:
+		fmt.Fprintf(&sb, "Already on '%s'\n", branch)
+	default:
+		fmt.Fprintf(&sb, "Switched to branch '%s'\n", branch)
+	}
+	return sb.String(), nil
+}
+
+// checkout is `checkout [-f] [-q] <branch>`, `checkout [-f] [-q] [--detach] <commit>` and
+// `checkout (-b|-B) <new> [<start>]`. a name that is not a branch detaches HEAD at that
+// commit and `-` goes back to the previous branch.
+func checkout(runEnv string, args []string) (string, error) {
+	req := switchRequest{cmd: "checkout"}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-b", "-B":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("checkout err: %s needs a branch name", arg)
+			}
+			i++
+			req.create, req.reset = args[i], arg == "-B"
+		case "--detach":
+			req.detach = true
+		case "-f", "--force":
+			req.force = true
+		case "-q", "--quiet":
+			req.quiet = true
+		case "--":
+			return "", errors.New("checkout err: checking out paths is done by restore")
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return "", fmt.Errorf("checkout err: unknown option %s", arg)
+			}
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) > 1 {
+		return "", errors.New("checkout err: checking out paths is done by restore")
+	}
+	if len(rest) == 1 {
+		req.target = rest[0]
+	}
+	out, err := switchTo(runEnv, req)
+	if err != nil {
+		return "", fmt.Errorf("checkout err: %w", err)
+	}
+	return out, nil
+}
+
+// switchBranch is `switch [-f] [-q] <branch>`, `switch (-c|-C) <new> [<start>]` and
+// `switch --detach [<commit>]`. unlike checkout a commit needs --detach.
+func switchBranch(runEnv string, args []string) (string, error) {
+	req := switchRequest{cmd: "switch"}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-c", "-C", "--create", "--force-create":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("switch err: %s needs a branch name", arg)
+			}
+			i++
+			req.create, req.reset = args[i], arg == "-C" || arg == "--force-create"
+		case "-d", "--detach":
+			req.detach = true
+		case "-f", "--force", "--discard-changes":
+			req.force = true
+		case "-q", "--quiet":
+			req.quiet = true
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return "", fmt.Errorf("switch err: unknown option %s", arg)
+			}
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) > 1 {
+		return "", errors.New("switch err: only one reference expected")
+	}
+	if len(rest) == 1 {
+		req.target = rest[0]
+	}
+	out, err := switchTo(runEnv, req)
+	if err != nil {
+		return "", fmt.Errorf("switch err: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckout(t *testing.T) {
+	setupWorktree(t, map[string]string{"a.txt": "a\n", "same.txt": "same\n", "d": "file\n"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	commitAll := func(msg string) {
+		t.Helper()
+		if err := add("test", []string{"-A"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := commit("test", []string{"-m", msg}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commitAll("base")
+	if _, err := branch("test", []string{"other"}); err != nil {
+		t.Fatal(err)
+	}
+	// on other a.txt changes, d turns from a file into a directory and new.txt appears
+	if _, err := checkout("test", []string{"other"}); err != nil {
+		t.Fatal(err)
+	}
+	writeWorktree(t, "a.txt", "a2\n")
+	os.Remove("tmp/d")
+	writeWorktree(t, "d/f.txt", "in\n")
+	writeWorktree(t, "new.txt", "new\n")
+	commitAll("other")
+	read := func(name string) string {
+		t.Helper()
+		raw, err := os.ReadFile("tmp/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	clean := func() {
+		t.Helper()
+		st, err := collectStatus("test", "normal")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(st.entries) != 0 || len(st.untracked) != 0 {
+			t.Fatalf("work tree is not clean: %+v", st)
+		}
+	}
+
+	t.Run("should rewrite the work tree and index for another branch", func(t *testing.T) {
+		out, err := switchBranch("test", []string{"main"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "Switched to branch 'main'\n" {
+			t.Fatalf("got %q", out)
+		}
+		if read("a.txt") != "a\n" || read("d") != "file\n" {
+			t.Fatal("files were not restored")
+		}
+		if _, err := os.Stat("tmp/new.txt"); !os.IsNotExist(err) {
+			t.Fatal("new.txt should be gone")
+		}
+		clean()
+		entries, _ := readReflog("test", "HEAD")
+		if last := entries[len(entries)-1].msg; last != "checkout: moving from other to main" {
+			t.Fatalf("unexpected reflog message %q", last)
+		}
+	})
+
+	t.Run("should carry changes to paths both commits agree on", func(t *testing.T) {
+		writeWorktree(t, "same.txt", "local\n")
+		out, err := checkout("test", []string{"-"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "M\tsame.txt\nSwitched to branch 'other'\n" || read("same.txt") != "local\n" || read("d/f.txt") != "in\n" {
+			t.Fatalf("got %q", out)
+		}
+		writeWorktree(t, "same.txt", "same\n")
+	})
+
+	t.Run("should refuse to overwrite local changes and untracked files", func(t *testing.T) {
+		writeWorktree(t, "a.txt", "dirty\n")
+		if _, err := checkout("test", []string{"main"}); err == nil || !strings.Contains(err.Error(), "local changes") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if read("a.txt") != "dirty\n" {
+			t.Fatal("the local change was lost")
+		}
+		if _, err := checkout("test", []string{"-f", "main"}); err != nil {
+			t.Fatal(err)
+		}
+		if read("a.txt") != "a\n" {
+			t.Fatal("-f did not drop the local change")
+		}
+		writeWorktree(t, "new.txt", "untracked\n")
+		if _, err := checkout("test", []string{"other"}); err == nil || !strings.Contains(err.Error(), "untracked working tree files") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		os.Remove("tmp/new.txt")
+	})
+
+	t.Run("should detach at commits and create branches", func(t *testing.T) {
+		_, base, _ := headCommit("test")
+		if _, err := switchBranch("test", []string{"other~1"}); err == nil {
+			t.Fatal("switch should need --detach for a commit")
+		}
+		out, err := checkout("test", []string{"other~1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current, head, _ := headCommit("test"); current != "" || head != base || out != "HEAD is now at "+base[:7]+" base\n" {
+			t.Fatalf("HEAD is %q at %s, output %q", current, head, out)
+		}
+		if out, err = switchBranch("test", []string{"-c", "feature", "other"}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(out, "Switched to a new branch 'feature'\n") || read("new.txt") != "new\n" {
+			t.Fatalf("got %q", out)
+		}
+		if current, _, _ := headCommit("test"); current != "feature" {
+			t.Fatalf("HEAD is on %q", current)
+		}
+		clean()
+	})
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is the identity and time on an author or committer line. TZ keeps the
+// offset exactly as it was written, like -0000, when it came from a parsed commit.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+	TZ    string
+}
+
+// String formats the signature the way commits store it, `Name <email> 1661410769 +0400`
+func (s Signature) String() string {
+	tz := s.TZ
+	if tz == "" {
+		tz = s.When.Format("-0700")
+	}
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), tz)
+}
+
+// parseSignature reads `Name <email> 1661410769 +0400` from an author or committer line
+func parseSignature(line string) (Signature, error) {
+	open := strings.IndexByte(line, '<')
+	closing := strings.IndexByte(line[max(open, 0):], '>') + open
+	if open < 0 || closing < open {
+		return Signature{}, fmt.Errorf("invalid signature %q", line)
+	}
+	sig := Signature{Name: strings.TrimSuffix(line[:open], " "), Email: line[open+1 : closing]}
+	stamp, tz, ok := strings.Cut(strings.TrimPrefix(line[closing+1:], " "), " ")
+	secs, err := strconv.ParseInt(stamp, 10, 64)
+	if !ok || err != nil {
+		return Signature{}, fmt.Errorf("invalid signature %q: bad date", line)
+	}
+	loc, err := parseTimezone(tz)
+	if err != nil {
+		// odd offsets are kept as written, the time itself is still right
+		loc = time.UTC
+	}
+	sig.When, sig.TZ = time.Unix(secs, 0).In(loc), tz
+	return sig, nil
+}
+
+// CommitHeader is a header besides tree, parent, author and committer, like encoding,
+// gpgsig or mergetag. the lines of a multi-line value are joined with "\n".
+type CommitHeader struct {
+	Key   string
+	Value string
+}
+
+// Commit is a parsed commit object, serialize gives back the exact bytes it was parsed from
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	Extra     []CommitHeader
+	Message   string
+}
+
+// parseCommit reads the headers up to the first empty line and keeps the rest as the
+// message. continuation lines of multi-line headers start with a single space.
+func parseCommit(payload []byte) (*Commit, error) {
+	header, message, _ := strings.Cut(string(payload), "\n\n")
+	c := &Commit{Message: message}
+	var last *CommitHeader
+	haveAuthor, haveCommitter := false, false
+	for _, line := range strings.Split(header, "\n") {
+		if strings.HasPrefix(line, " ") {
+			if last == nil {
+				return nil, fmt.Errorf("invalid commit: unexpected continuation line %q", line)
+			}
+			last.Value += "\n" + line[1:]
+			continue
+		}
+		last = nil
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid commit: bad header line %q", line)
+		}
+		var err error
+		switch key {
+		case "tree":
+			if c.Tree != "" || len(c.Parents) > 0 {
+				return nil, errors.New("invalid commit: misplaced tree")
+			}
+			c.Tree = value
+		case "parent":
+			if c.Tree == "" || haveAuthor {
+				return nil, errors.New("invalid commit: misplaced parent")
+			}
+			c.Parents = append(c.Parents, value)
+		case "author":
+			if c.Tree == "" || haveAuthor {
+				return nil, errors.New("invalid commit: misplaced author")
+			}
+			c.Author, err = parseSignature(value)
+			haveAuthor = true
+		case "committer":
+			if !haveAuthor || haveCommitter {
+				return nil, errors.New("invalid commit: misplaced committer")
+			}
+			c.Committer, err = parseSignature(value)
+			haveCommitter = true
+		default:
+			if !haveCommitter {
+				return nil, fmt.Errorf("invalid commit: %s header before committer", key)
+			}
+			c.Extra = append(c.Extra, CommitHeader{Key: key, Value: value})
+			last = &c.Extra[len(c.Extra)-1]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit: %w", err)
+		}
+	}
+	if len(c.Tree) != 40 || !isHex(c.Tree) {
+		return nil, errors.New("invalid commit: missing tree")
+	}
+	if !haveCommitter {
+		return nil, errors.New("invalid commit: missing author or committer")
+	}
+	return c, nil
+}
+
+// serialize is the inverse of parseCommit
+func (c *Commit) serialize() []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&sb, "parent %s\n", p)
+	}
+	fmt.Fprintf(&sb, "author %s\ncommitter %s\n", c.Author, c.Committer)
+	for _, h := range c.Extra {
+		fmt.Fprintf(&sb, "%s %s\n", h.Key, strings.ReplaceAll(h.Value, "\n", "\n "))
+	}
+	sb.WriteString("\n")
+	sb.WriteString(c.Message)
+	return []byte(sb.String())
+}
+
+// readCommit loads and parses a commit object
+func readCommit(store *ObjectStore, hash string) (*Commit, error) {
+	kind, payload, err := store.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "commit" {
+		return nil, fmt.Errorf("%s is a %s, not a commit", hash, kind)
+	}
+	c, err := parseCommit(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", hash, err)
+	}
+	return c, nil
+}
+
+// identity builds the author or committer signature from the GIT_<ROLE>_NAME, _EMAIL and
+// _DATE variables, falling back to user.name and user.email in the config and the clock
+func identity(runEnv, role string) (Signature, error) {
+	env := "GIT_" + strings.ToUpper(role) + "_"
+	sig := Signature{Name: os.Getenv(env + "NAME"), Email: os.Getenv(env + "EMAIL")}
+	if sig.Name == "" {
+		sig.Name = configValue(runEnv, "user.name")
+	}
+	if sig.Email == "" {
+		sig.Email = configValue(runEnv, "user.email")
+	}
+	if sig.Email == "" {
+		sig.Email = os.Getenv("EMAIL")
+	}
+	if sig.Name == "" || sig.Email == "" {
+		return Signature{}, fmt.Errorf("%s identity unknown, set user.name and user.email in the config or %sNAME and %sEMAIL", role, env, env)
+	}
+	if strings.ContainsAny(sig.Name+sig.Email, "<>\n") {
+		return Signature{}, fmt.Errorf("invalid %s identity %s <%s>", role, sig.Name, sig.Email)
+	}
+
+	sig.When = time.Now()
+	if raw := os.Getenv(env + "DATE"); raw != "" {
+		when, err := parseGitDate(raw)
+		if err != nil {
+			return Signature{}, fmt.Errorf("invalid %sDATE: %w", env, err)
+		}
+		sig.When = when
+	}
+	return sig, nil
+}
+
+// gitDateLayouts are the human formats accepted for GIT_*_DATE besides raw `<unix> <tz>`
+var gitDateLayouts = []string{
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// parseGitDate understands git's internal `1661410769 +0400` (optionally with a leading @),
+// RFC 2822 and ISO 8601 dates. dates without a zone are local time.
+func parseGitDate(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	stamp, zone, _ := strings.Cut(strings.TrimPrefix(raw, "@"), " ")
+	if secs, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+		loc := time.UTC
+		if zone != "" {
+			offset, err := parseTimezone(zone)
+			if err != nil {
+				return time.Time{}, err
+			}
+			loc = offset
+		}
+		return time.Unix(secs, 0).In(loc), nil
+	}
+	for _, layout := range gitDateLayouts {
+		if when, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", raw)
+}
+
+// parseTimezone turns a `+hhmm`/`-hhmm` offset into a fixed zone
+func parseTimezone(zone string) (*time.Location, error) {
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return nil, fmt.Errorf("bad timezone %q", zone)
+	}
+	hours, err1 := strconv.Atoi(zone[1:3])
+	minutes, err2 := strconv.Atoi(zone[3:])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("bad timezone %q", zone)
+	}
+	offset := (hours*60 + minutes) * 60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), nil
+}
+
+// commitTree writes a commit object for the tree, args are `<tree> [-p <parent>]...
+// [-m <message>]... [-F <file>]...`. without -m or -F the message is read from stdin.
+func commitTree(runEnv string, args []string, stdin io.Reader) (string, error) {
+	var tree string
+	var parents []string
+	var msg strings.Builder
+	haveMsg := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-p", "-m", "-F":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("commit-tree err: option %s needs a value", arg)
+			}
+			i++
+			switch arg {
+			case "-p":
+				parents = append(parents, args[i])
+			case "-m":
+				addParagraph(&msg, args[i])
+				haveMsg = true
+			case "-F":
+				data, err := readMessageFile(args[i], stdin)
+				if err != nil {
+					return "", fmt.Errorf("commit-tree err: %w", err)
+				}
+				addParagraph(&msg, data)
+				haveMsg = true
+			}
+		default:
+			if strings.HasPrefix(arg, "-") || tree != "" {
+				return "", fmt.Errorf("commit-tree err: unexpected argument %s", arg)
+			}
+			tree = arg
+		}
+	}
+	if tree == "" {
+		return "", errors.New("commit-tree err: must give exactly one tree")
+	}
+
+	store := newObjectStore(runEnv)
+	treeHash, err := resolveRevision(runEnv, tree)
+	if err != nil {
+		return "", fmt.Errorf("commit-tree err: %w", err)
+	}
+	if kind, _, err := store.Stat(treeHash); err != nil || kind != "tree" {
+		return "", fmt.Errorf("commit-tree err: %s is not a valid tree object", tree)
+	}
+	tree = treeHash
+	var unique []string
+	for _, rev := range parents {
+		p, err := resolveCommit(runEnv, rev)
+		if err != nil {
+			return "", fmt.Errorf("commit-tree err: %s is not a valid commit object", rev)
+		}
+		// git ignores a parent given twice
+		if !slices.Contains(unique, p) {
+			unique = append(unique, p)
+		}
+	}
+
+	author, err := identity(runEnv, "author")
+	if err != nil {
+		return "", fmt.Errorf("commit-tree err: %w", err)
+	}
+	committer, err := identity(runEnv, "committer")
+	if err != nil {
+		return "", fmt.Errorf("commit-tree err: %w", err)
+	}
+	if !haveMsg {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("commit-tree err: failed to read message: %w", err)
+		}
+		msg.Write(data)
+	}
+
+	hash, err := writeCommit(store, &Commit{Tree: tree, Parents: unique, Author: author, Committer: committer, Message: msg.String()})
+	if err != nil {
+		return "", fmt.Errorf("commit-tree err: %w", err)
+	}
+	return hash, nil
+}
+
+// addParagraph appends a -m or -F message as its own paragraph like git does
+func addParagraph(msg *strings.Builder, text string) {
+	if msg.Len() > 0 {
+		msg.WriteString("\n")
+	}
+	msg.WriteString(text)
+	if !strings.HasSuffix(text, "\n") {
+		msg.WriteString("\n")
+	}
+}
+
+// readMessageFile reads a -F message, "-" meaning stdin
+func readMessageFile(path string, stdin io.Reader) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read message: %w", err)
+	}
+	return string(data), nil
+}
+
+// writeCommit stores a commit object
+func writeCommit(store *ObjectStore, c *Commit) (string, error) {
+	hash, err := store.Put("commit", c.serialize())
+	if err != nil {
+		return "", fmt.Errorf("failed to write commit: %w", err)
+	}
+	return hash, nil
+}
+
+// messageSubject is the first line of a commit message
+func messageSubject(msg string) string {
+	subject, _, _ := strings.Cut(strings.TrimLeft(msg, "\n"), "\n")
+	return subject
+}
+
+// cleanupMessage is git's whitespace cleanup: trailing whitespace goes, runs of empty
+// lines collapse into one and leading and trailing empty lines are dropped
+func cleanupMessage(msg string) string {
+	var lines []string
+	pending := false
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			pending = len(lines) > 0
+			continue
+		}
+		if pending {
+			lines = append(lines, "")
+			pending = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// commit records what is staged as a new commit on top of HEAD and moves the current
+// branch to it. -m/-F give the message, --amend replaces the HEAD commit keeping its
+// author (and message unless a new one is given), --allow-empty commits an unchanged tree.
+func commit(runEnv string, args []string, stdin io.Reader) (string, error) {
+	var msg strings.Builder
+	haveMsg, amend, allowEmpty, quiet := false, false, false, false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--amend":
+			amend = true
+		case arg == "--allow-empty":
+			allowEmpty = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "-m" || arg == "-F":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("commit err: option %s needs a value", arg)
+			}
+			i++
+			text := args[i]
+			if arg == "-F" {
+				data, err := readMessageFile(text, stdin)
+				if err != nil {
+					return "", fmt.Errorf("commit err: %w", err)
+				}
+				text = data
+			}
+			addParagraph(&msg, text)
+			haveMsg = true
+		case strings.HasPrefix(arg, "--message="):
+			addParagraph(&msg, strings.TrimPrefix(arg, "--message="))
+			haveMsg = true
+		default:
+			return "", fmt.Errorf("commit err: unknown option %s", arg)
+		}
+	}
+
+	store := newObjectStore(runEnv)
+	ref, head, err := resolveRef(runEnv, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("commit err: %w", err)
+	}
+	tree, err := writeTree(runEnv, "")
+	if err != nil {
+		return "", fmt.Errorf("commit err: %w", err)
+	}
+
+	var parents []string
+	var author *Signature
+	message, reason := msg.String(), "commit"
+	switch {
+	case amend:
+		if head == "" {
+			return "", errors.New("commit err: you have nothing to amend")
+		}
+		old, err := readCommit(store, head)
+		if err != nil {
+			return "", fmt.Errorf("commit err: %w", err)
+		}
+		parents, author = old.Parents, &old.Author
+		if !haveMsg {
+			message = old.Message
+		}
+		reason = "commit (amend)"
+	case head != "":
+		parents = []string{head}
+	default:
+		reason = "commit (initial)"
+	}
+	if haveMsg {
+		message = cleanupMessage(message)
+	}
+	if strings.TrimSpace(message) == "" {
+		return "", errors.New("commit err: aborting commit due to empty commit message")
+	}
+
+	if !amend && !allowEmpty {
+		parentTree := hashFor("tree", nil)
+		if head != "" {
+			if parentTree, err = commitTreeHash(store, head); err != nil {
+				return "", fmt.Errorf("commit err: %w", err)
+			}
+		}
+		if tree == parentTree {
+			return "", errors.New("commit err: nothing to commit, use --allow-empty to record a commit without changes")
+		}
+	}
+
+	if author == nil {
+		sig, err := identity(runEnv, "author")
+		if err != nil {
+			return "", fmt.Errorf("commit err: %w", err)
+		}
+		author = &sig
+	}
+	committer, err := identity(runEnv, "committer")
+	if err != nil {
+		return "", fmt.Errorf("commit err: %w", err)
+	}
+	hash, err := writeCommit(store, &Commit{Tree: tree, Parents: parents, Author: *author, Committer: committer, Message: message})
+	if err != nil {
+		return "", fmt.Errorf("commit err: %w", err)
+	}
+	old := head
+	if old == "" {
+		old = zeroHash
+	}
+	// fails instead of losing work when someone else moved the branch meanwhile
+	if err := updateRef(runEnv, "HEAD", hash, old, reason+": "+messageSubject(message)); err != nil {
+		return "", fmt.Errorf("commit err: %w", err)
+	}
+
+	if quiet {
+		return "", nil
+	}
+	name := strings.TrimPrefix(ref, "refs/heads/")
+	if ref == "HEAD" {
+		name = "detached HEAD"
+	}
+	if len(parents) == 0 {
+		name += " (root-commit)"
+	}
+	return fmt.Sprintf("[%s %s] %s\n", name, hash[:7], messageSubject(message)), nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCommitTree(t *testing.T) {
+	setupWorktree(t, map[string]string{"a.txt": "a\n"})
+	if err := add("test", []string{"-A"}); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := writeTree("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_AUTHOR_DATE", "1661410769 +0400")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	t.Setenv("GIT_COMMITTER_DATE", "Thu, 07 Apr 2005 22:13:13 +0200")
+	store := newObjectStore("test")
+
+	root, err := commitTree("test", []string{tree, "-m", "first", "-m", "second"}, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("should write a commit object with both identities", func(t *testing.T) {
+		kind, payload, err := store.Get(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "tree " + tree + "\n" +
+			"author A U Thor <author@example.com> 1661410769 +0400\n" +
+			"committer C O Mitter <committer@example.com> 1112904793 +0200\n\n" +
+			"first\n\nsecond\n"
+		if kind != "commit" || string(payload) != want {
+			t.Fatalf("got %s %q, want %q", kind, payload, want)
+		}
+	})
+
+	t.Run("should record each parent once and read the message from stdin", func(t *testing.T) {
+		hash, err := commitTree("test", []string{tree, "-p", root, "-p", root}, strings.NewReader("from stdin\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, payload, err := store.Get(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(payload), "parent "+root) != 1 || !strings.HasSuffix(string(payload), "\n\nfrom stdin\n") {
+			t.Fatalf("unexpected commit %q", payload)
+		}
+	})
+
+	t.Run("should leave HEAD alone", func(t *testing.T) {
+		if _, head, _ := headCommit("test"); head != "" {
+			t.Fatalf("unborn main was set to %s", head)
+		}
+		if err := updateRef("test", "HEAD", root, "", "init"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := commitTree("test", []string{tree, "-p", "HEAD", "-m", "child"}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+		if _, head, _ := headCommit("test"); head != root {
+			t.Fatalf("HEAD moved from %s to %s", root, head)
+		}
+	})
+
+	t.Run("should reject parents that are not commits", func(t *testing.T) {
+		if _, err := commitTree("test", []string{tree, "-p", tree, "-m", "x"}, strings.NewReader("")); err == nil {
+			t.Fatal("expected invalid parent error")
+		}
+	})
+
+	t.Run("should fail without an identity", func(t *testing.T) {
+		t.Setenv("GIT_AUTHOR_NAME", "")
+		t.Setenv("HOME", t.TempDir())
+		if _, err := commitTree("test", []string{tree, "-m", "x"}, strings.NewReader("")); err == nil {
+			t.Fatal("expected identity error")
+		}
+	})
+}
+
+func TestParseGitDate(t *testing.T) {
+	for raw, want := range map[string]string{
+		"1661410769 +0400":                "1661410769 +0400",
+		"@1661410769 -0130":               "1661410769 -0130",
+		"1661410769":                      "1661410769 +0000",
+		"Thu, 07 Apr 2005 22:13:13 +0200": "1112904793 +0200",
+		"2005-04-07T22:13:13+02:00":       "1112904793 +0200",
+		"2005-04-07 22:13:13 +0200":       "1112904793 +0200",
+	} {
+		when, err := parseGitDate(raw)
+		if err != nil {
+			t.Fatalf("%q: %v", raw, err)
+		}
+		if got := (Signature{When: when}).String(); !strings.HasSuffix(got, "> "+want) {
+			t.Fatalf("%q parsed as %s, want %s", raw, got, want)
+		}
+	}
+	if _, err := parseGitDate("yesterday"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestCommit(t *testing.T) {
+	setupWorktree(t, map[string]string{"a.txt": "a\n"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_AUTHOR_DATE", "1661410769 +0400")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	store := newObjectStore("test")
+	if err := add("test", []string{"-A"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := commit("test", []string{"-m", "first  \n\n\n", "-m", "body"}, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, root, _ := headCommit("test")
+	t.Run("should create a root commit from the index and move the branch", func(t *testing.T) {
+		if out != "[main (root-commit) "+root[:7]+"] first\n" {
+			t.Fatalf("unexpected output %q", out)
+		}
+		c, err := readCommit(store, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, _ := writeTree("test", "")
+		if c.Tree != tree || len(c.Parents) != 0 || c.Message != "first\n\nbody\n" {
+			t.Fatalf("unexpected commit %+v", c)
+		}
+	})
+
+	t.Run("should refuse to commit nothing unless allowed", func(t *testing.T) {
+		if _, err := commit("test", []string{"-m", "same"}, strings.NewReader("")); err == nil {
+			t.Fatal("expected nothing to commit error")
+		}
+		if _, err := commit("test", []string{"--allow-empty", "-m", "same"}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+		_, head, _ := headCommit("test")
+		c, err := readCommit(store, head)
+		if err != nil || len(c.Parents) != 1 || c.Parents[0] != root {
+			t.Fatalf("HEAD is not on top of the root commit: %+v %v", c, err)
+		}
+	})
+
+	t.Run("should amend the tip keeping author and parents", func(t *testing.T) {
+		_, before, _ := headCommit("test")
+		writeWorktree(t, "b.txt", "b\n")
+		if err := add("test", []string{"tmp/b.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("GIT_AUTHOR_NAME", "Someone Else")
+		if _, err := commit("test", []string{"--amend"}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+		_, head, _ := headCommit("test")
+		c, err := readCommit(store, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head == before || len(c.Parents) != 1 || c.Parents[0] != root || c.Author.Name != "A U Thor" || c.Message != "same\n" {
+			t.Fatalf("unexpected amended commit %+v", c)
+		}
+		raw, err := os.ReadFile("tmp/.git/logs/refs/heads/main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(raw), "\tcommit (amend): same\n") || !strings.Contains(string(raw), "\tcommit (initial): first\n") {
+			t.Fatalf("unexpected reflog %q", raw)
+		}
+	})
+
+	t.Run("should not commit an empty message", func(t *testing.T) {
+		if _, err := commit("test", []string{"--allow-empty", "-m", "  \n"}, strings.NewReader("")); err == nil {
+			t.Fatal("expected empty message error")
+		}
+	})
+}
+
+func TestCommitRoundTrip(t *testing.T) {
+	payloads := map[string]string{
+		"signed merge": "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+			"parent 3b18e512dba79e4c8300dd08aeb37f8e728b8dad\n" +
+			"parent d5f0fc3dfb21ae6650f380a7b101c390f29940e4\n" +
+			"author Jöhn Q. Public Jr. <john@example.com> 1661410769 +0400\n" +
+			"committer Some  Body <> 1112904793 -0000\n" +
+			"encoding ISO-8859-1\n" +
+			"mergetag object d5f0fc3dfb21ae6650f380a7b101c390f29940e4\n type commit\n tag v1\n \n message\n" +
+			"gpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n =abcd\n -----END PGP SIGNATURE-----\n" +
+			"\n" +
+			"subject\n\nbody\n",
+		"empty message": "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+			"author a <a> 0 +0000\ncommitter a <a> 0 +0530\n\n",
+	}
+	for name, payload := range payloads {
+		c, err := parseCommit([]byte(payload))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := string(c.serialize()); got != payload {
+			t.Fatalf("%s did not round-trip:\n%q\n%q", name, got, payload)
+		}
+	}
+
+	c, _ := parseCommit([]byte(payloads["signed merge"]))
+	if len(c.Parents) != 2 || c.Author.Name != "Jöhn Q. Public Jr." || c.Committer.Name != "Some  Body" || c.Committer.Email != "" {
+		t.Fatalf("unexpected identities %+v", c)
+	}
+	if _, offset := c.Author.When.Zone(); offset != 4*3600 || c.Author.When.Unix() != 1661410769 {
+		t.Fatalf("author time lost its zone: %v", c.Author.When)
+	}
+	if len(c.Extra) != 3 || c.Extra[2].Key != "gpgsig" || !strings.HasPrefix(c.Extra[2].Value, "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n") {
+		t.Fatalf("unexpected extra headers %+v", c.Extra)
+	}
+	if c.Message != "subject\n\nbody\n" {
+		t.Fatalf("unexpected message %q", c.Message)
+	}
+
+	for _, bad := range []string{
+		"author a <a> 0 +0000\ncommitter a <a> 0 +0000\n\nno tree\n",
+		"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ncommitter a <a> 0 +0000\n\nno author\n",
+		"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor a <a> 0 +0000\ncommitter a <a> 0 +0000\nparent 3b18e512dba79e4c8300dd08aeb37f8e728b8dad\n\nlate parent\n",
+	} {
+		if _, err := parseCommit([]byte(bad)); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
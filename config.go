@@ -0,0 +1,889 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth stops include.path cycles like git does
+const maxIncludeDepth = 10
+
+var errConfigNotFound = errors.New("config key not found")
+
+// configVar is one `name = value` line, start and end are the byte range of its lines
+// in the file so it can be rewritten or removed without touching anything else. a
+// variable sharing its line with the section header starts right after the header,
+// afterHeader says the header needs a newline once the variable is gone.
+type configVar struct {
+	section     string
+	subsection  string
+	name        string
+	value       string
+	noValue     bool
+	start, end  int
+	afterHeader bool
+}
+
+// key is the canonical `section.subsection.name` with section and name lower cased
+func (v configVar) key() string {
+	if v.subsection != "" {
+		return v.section + "." + v.subsection + "." + v.name
+	}
+	return v.section + "." + v.name
+}
+
+// configSection is a `[section "subsection"]` header and the byte range of its line
+type configSection struct {
+	section    string
+	subsection string
+	start, end int
+}
+
+// configFile is a parsed config file kept next to its raw bytes for editing
+type configFile struct {
+	path     string
+	data     []byte
+	vars     []configVar
+	sections []configSection
+}
+
+// configEntry is a value with the scope and file it came from
+type configEntry struct {
+	key     string
+	value   string
+	noValue bool
+	scope   string
+	file    string
+}
+
+// Config is every value of every scope in the order git reads them, later values win
+type Config struct {
+	entries []configEntry
+}
+
+// canonicalKey lower cases the section and name of `section[.subsection].name`, the
+// subsection keeps its case
+func canonicalKey(key string) (string, error) {
+	first, last := strings.IndexByte(key, '.'), strings.LastIndexByte(key, '.')
+	if first <= 0 || last == len(key)-1 {
+		return "", fmt.Errorf("key does not contain a section: %s", key)
+	}
+	section, name := strings.ToLower(key[:first]), strings.ToLower(key[last+1:])
+	if !validConfigName(section, true) || !validConfigName(name, false) {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+	if first == last {
+		return section + "." + name, nil
+	}
+	return section + "." + key[first+1:last] + "." + name, nil
+}
+
+func validConfigName(name string, dots bool) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-'):
+		case dots && i > 0 && c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseConfig reads git's INI dialect: `[section]`, `[section "sub"]` and the legacy
+// `[section.sub]` headers, `name = value` or a bare `name` meaning true, quoting, the
+// \n \t \b \\ \" escapes, backslash line continuations and # or ; comments
+func parseConfig(path string, data []byte) (*configFile, error) {
+	cf := &configFile{path: path, data: data}
+	section, subsection := "", ""
+	headerEnd := 0
+	line := 1
+	bad := func() error { return fmt.Errorf("bad config line %d in file %s", line, path) }
+	lineStart := func(pos int) int {
+		for pos > 0 && data[pos-1] != '\n' {
+			pos--
+		}
+		return pos
+	}
+	lineEnd := func(pos int) int {
+		for pos < len(data) && data[pos] != '\n' {
+			pos++
+		}
+		if pos < len(data) {
+			pos++
+		}
+		return pos
+	}
+
+	i := 0
+	// skip a UTF-8 byte order mark
+	if len(data) >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf {
+		i = 3
+	}
+	for i < len(data) {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || c == ';':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '[':
+			start := i
+			end := i + 1
+			quoted, escaped := false, false
+			for ; end < len(data); end++ {
+				ch := data[end]
+				if ch == '\n' {
+					return nil, bad()
+				}
+				if escaped {
+					escaped = false
+					continue
+				}
+				if quoted && ch == '\\' {
+					escaped = true
+				} else if ch == '"' {
+					quoted = !quoted
+				} else if ch == ']' && !quoted {
+					break
+				}
+			}
+			if end >= len(data) {
+				return nil, bad()
+			}
+			var err error
+			section, subsection, err = parseSectionHeader(string(data[start+1 : end]))
+			if err != nil {
+				return nil, bad()
+			}
+			i = end + 1
+			headerEnd = i
+			cf.sections = append(cf.sections, configSection{section: section, subsection: subsection, start: lineStart(start), end: lineEnd(i)})
+		default:
+			if section == "" || !isAlpha(c) {
+				return nil, bad()
+			}
+			start := i
+			for i < len(data) && (isAlpha(data[i]) || data[i] >= '0' && data[i] <= '9' || data[i] == '-') {
+				i++
+			}
+			v := configVar{section: section, subsection: subsection, name: strings.ToLower(string(data[start:i])), start: lineStart(start)}
+			if v.start < headerEnd {
+				v.start, v.afterHeader = headerEnd, true
+			}
+			for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r') {
+				i++
+			}
+			switch {
+			case i >= len(data) || data[i] == '\n' || data[i] == '#' || data[i] == ';':
+				v.noValue = true
+			case data[i] == '=':
+				value, next, lines, err := parseConfigValue(data, i+1)
+				if err != nil {
+					return nil, bad()
+				}
+				v.value, i = value, next
+				line += lines
+			default:
+				return nil, bad()
+			}
+			v.end = lineEnd(i)
+			cf.vars = append(cf.vars, v)
+		}
+	}
+	return cf, nil
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseSectionHeader splits what is between [ and ] into section and subsection
+func parseSectionHeader(header string) (string, string, error) {
+	name, rest, quoted := strings.Cut(header, " ")
+	if !quoted {
+		// [section.sub] is the old spelling, the subsection is case insensitive there
+		section, sub, _ := strings.Cut(header, ".")
+		if !validConfigName(section, false) {
+			return "", "", errors.New("bad section")
+		}
+		return strings.ToLower(section), strings.ToLower(sub), nil
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	if !validConfigName(name, true) || len(rest) < 2 || rest[0] != '"' || rest[len(rest)-1] != '"' {
+		return "", "", errors.New("bad section")
+	}
+	var sub strings.Builder
+	inner := rest[1 : len(rest)-1]
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) {
+			i++
+		}
+		sub.WriteByte(inner[i])
+	}
+	return strings.ToLower(name), sub.String(), nil
+}
+
+// parseConfigValue reads the value starting right after '=' and returns it with the
+// offset of the newline (or end of data) that ends it and how many lines it continued over
+func parseConfigValue(data []byte, i int) (string, int, int, error) {
+	var value strings.Builder
+	quoted := false
+	spaces, lines := 0, 0
+	for ; i < len(data); i++ {
+		c := data[i]
+		if c == '\n' {
+			if quoted {
+				return "", i, lines, errors.New("unterminated quote")
+			}
+			break
+		}
+		if !quoted && (c == '#' || c == ';') {
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			break
+		}
+		if !quoted && (c == ' ' || c == '\t' || c == '\r') {
+			// inner whitespace is kept, leading and trailing whitespace is not
+			if value.Len() > 0 {
+				spaces++
+			}
+			continue
+		}
+		if spaces > 0 {
+			value.WriteString(strings.Repeat(" ", spaces))
+			spaces = 0
+		}
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++
+			if i >= len(data) {
+				return "", i, lines, errors.New("bad escape")
+			}
+			switch data[i] {
+			case '\n':
+				lines++
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			case '\\', '"':
+				value.WriteByte(data[i])
+			default:
+				return "", i, lines, errors.New("bad escape")
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	if quoted {
+		return "", i, lines, errors.New("unterminated quote")
+	}
+	return value.String(), i, lines, nil
+}
+
+// formatConfigValue quotes and escapes a value so parseConfigValue gives it back
+func formatConfigValue(value string) string {
+	var sb strings.Builder
+	quote := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+	for _, c := range value {
+		switch c {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteRune(c)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	if quote {
+		return `"` + sb.String() + `"`
+	}
+	return sb.String()
+}
+
+// readConfigFile parses a config file, a missing file is empty
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return parseConfig(path, data)
+}
+
+// configScopes lists the files of each scope in the order git reads them
+func configScopes(runEnv string) [][2]string {
+	var scopes [][2]string
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		system := os.Getenv("GIT_CONFIG_SYSTEM")
+		if system == "" {
+			system = "/etc/gitconfig"
+		}
+		scopes = append(scopes, [2]string{"system", system})
+	}
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		scopes = append(scopes, [2]string{"global", global})
+	} else if home, err := os.UserHomeDir(); err == nil {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" {
+			xdg = filepath.Join(home, ".config")
+		}
+		scopes = append(scopes, [2]string{"global", filepath.Join(xdg, "git", "config")})
+		scopes = append(scopes, [2]string{"global", filepath.Join(home, ".gitconfig")})
+	}
+	return append(scopes, [2]string{"local", filepath.Join(gitDir(runEnv), "config")})
+}
+
+// globalConfigPath is the file `config --global` writes
+func globalConfigPath() (string, error) {
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		return global, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find the home directory: %w", err)
+	}
+	return filepath.Join(home, ".gitconfig"), nil
+}
+
+// loadConfig reads every scope, following include.path
+func loadConfig(runEnv string) (*Config, error) {
+	cfg := &Config{}
+	for _, scope := range configScopes(runEnv) {
+		if err := cfg.load(scope[0], scope[1], true, 0); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// loadConfigFile reads a single file without includes, what `config --file` and the scope flags see
+func loadConfigFile(scope, path string) (*Config, error) {
+	cfg := &Config{}
+	return cfg, cfg.load(scope, path, false, 0)
+}
+
+func (c *Config) load(scope, path string, includes bool, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("exceeded maximum include depth (%d) while including %s", maxIncludeDepth, path)
+	}
+	cf, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for _, v := range cf.vars {
+		c.entries = append(c.entries, configEntry{key: v.key(), value: v.value, noValue: v.noValue, scope: scope, file: path})
+		if !includes || v.key() != "include.path" || v.noValue || v.value == "" {
+			continue
+		}
+		include := v.value
+		if strings.HasPrefix(include, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			include = filepath.Join(home, include[2:])
+		} else if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := c.load(scope, include, true, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAll returns every value of a key in read order
+func (c *Config) getAll(key string) []configEntry {
+	key, err := canonicalKey(key)
+	if err != nil {
+		return nil
+	}
+	var values []configEntry
+	for _, e := range c.entries {
+		if e.key == key {
+			values = append(values, e)
+		}
+	}
+	return values
+}
+
+// get returns the last value of a key
+func (c *Config) get(key string) (configEntry, bool) {
+	values := c.getAll(key)
+	if len(values) == 0 {
+		return configEntry{}, false
+	}
+	return values[len(values)-1], true
+}
+
+// parseConfigBool is git's boolean: true/yes/on/1 and a bare key are true, false/no/off/0
+// and an empty value are false
+func parseConfigBool(e configEntry) (bool, error) {
+	if e.noValue {
+		return true, nil
+	}
+	switch strings.ToLower(e.value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	n, err := parseConfigInt(e)
+	if err != nil {
+		return false, fmt.Errorf("bad boolean config value '%s' for '%s'", e.value, e.key)
+	}
+	return n != 0, nil
+}
+
+// parseConfigInt reads an integer with an optional k, m or g suffix
+func parseConfigInt(e configEntry) (int64, error) {
+	raw, unit := e.value, int64(1)
+	if raw != "" {
+		switch raw[len(raw)-1] {
+		case 'k', 'K':
+			unit = 1 << 10
+		case 'm', 'M':
+			unit = 1 << 20
+		case 'g', 'G':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			raw = raw[:len(raw)-1]
+		}
+	}
+	n, err := strconv.ParseInt(raw, 0, 64)
+	if err != nil || n > (1<<63-1)/unit || n < -(1<<63-1)/unit {
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s'", e.value, e.key)
+	}
+	return n * unit, nil
+}
+
+// bool reads a boolean key, def is used when it is not set
+func (c *Config) bool(key string, def bool) (bool, error) {
+	e, ok := c.get(key)
+	if !ok {
+		return def, nil
+	}
+	return parseConfigBool(e)
+}
+
+// int reads an integer key, def is used when it is not set
+func (c *Config) int(key string, def int64) (int64, error) {
+	e, ok := c.get(key)
+	if !ok {
+		return def, nil
+	}
+	return parseConfigInt(e)
+}
+
+// configValue returns the value of a key over all scopes, unreadable config counts as unset
+func configValue(runEnv, key string) string {
+	cfg, err := loadConfig(runEnv)
+	if err != nil {
+		return ""
+	}
+	e, _ := cfg.get(key)
+	return e.value
+}
+
+// splitKey turns a canonical key back into section, subsection and name
+func splitKey(key string) (string, string, string) {
+	first, last := strings.IndexByte(key, '.'), strings.LastIndexByte(key, '.')
+	if first == last {
+		return key[:first], "", key[last+1:]
+	}
+	return key[:first], key[first+1 : last], key[last+1:]
+}
+
+// matching returns the indexes of the variables for key whose value matches pattern (nil matches all)
+func (cf *configFile) matching(key string, pattern *regexp.Regexp) []int {
+	var found []int
+	for i, v := range cf.vars {
+		if v.key() == key && (pattern == nil || pattern.MatchString(v.value)) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// edit replaces the byte ranges of the given variables with the given text, an empty
+// text removes them
+func (cf *configFile) edit(indexes []int, text string) []byte {
+	var out []byte
+	pos := 0
+	for n, i := range indexes {
+		v := cf.vars[i]
+		out = append(out, cf.data[pos:v.start]...)
+		if v.afterHeader {
+			out = append(out, '\n')
+		}
+		if n == len(indexes)-1 {
+			out = append(out, text...)
+		}
+		pos = v.end
+	}
+	return append(out, cf.data[pos:]...)
+}
+
+// insert adds a variable at the end of the last matching section, or in a new section
+func (cf *configFile) insert(key, value string) []byte {
+	section, subsection, name := splitKey(key)
+	line := "\t" + name + " = " + formatConfigValue(value) + "\n"
+	at := -1
+	for _, s := range cf.sections {
+		if s.section == section && s.subsection == subsection {
+			at = s.end
+		}
+	}
+	if at >= 0 {
+		// skip to the end of that section's variables
+		for _, v := range cf.vars {
+			if v.start >= at && v.section == section && v.subsection == subsection {
+				at = v.end
+			}
+		}
+		out := append([]byte{}, cf.data[:at]...)
+		if len(out) > 0 && out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+		out = append(out, line...)
+		return append(out, cf.data[at:]...)
+	}
+	out := append([]byte{}, cf.data...)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	header := "[" + section + "]\n"
+	if subsection != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+		header = "[" + section + ` "` + escaped + "\"]\n"
+	}
+	return append(append(out, header...), line...)
+}
+
+// dropEmptySections removes the headers of sections left without variables or comments
+func dropEmptySections(path string, data []byte) ([]byte, error) {
+	cf, err := parseConfig(path, data)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	pos := 0
+	for n, s := range cf.sections {
+		end := len(data)
+		if n+1 < len(cf.sections) {
+			end = cf.sections[n+1].start
+		}
+		if strings.TrimSpace(string(data[s.end:end])) != "" || slices.ContainsFunc(cf.vars, func(v configVar) bool {
+			return v.afterHeader && v.start > s.start && v.start < end
+		}) {
+			continue
+		}
+		out = append(out, data[pos:s.start]...)
+		pos = end
+	}
+	return append(out, data[pos:]...), nil
+}
+
+// writeConfigFile stores new config content through the lock file
+func writeConfigFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	lock, err := acquireLock(path)
+	if err != nil {
+		return err
+	}
+	return lock.commit(data)
+}
+
+// setConfig sets key in the file, replacing the current value. add keeps existing
+// values, all replaces every value matching pattern.
+func setConfig(path, key, value string, pattern *regexp.Regexp, add, all bool) error {
+	key, err := canonicalKey(key)
+	if err != nil {
+		return err
+	}
+	cf, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	found := cf.matching(key, pattern)
+	var data []byte
+	switch {
+	case add || len(found) == 0:
+		data = cf.insert(key, value)
+	case len(found) > 1 && !all:
+		return fmt.Errorf("%s has multiple values, use a value pattern, --add or --replace-all", key)
+	default:
+		_, _, name := splitKey(key)
+		data = cf.edit(found, "\t"+name+" = "+formatConfigValue(value)+"\n")
+	}
+	return writeConfigFile(path, data)
+}
+
+// unsetConfig removes the value of key (all of them with all) matching pattern
+func unsetConfig(path, key string, pattern *regexp.Regexp, all bool) error {
+	key, err := canonicalKey(key)
+	if err != nil {
+		return err
+	}
+	cf, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	found := cf.matching(key, pattern)
+	if len(found) == 0 {
+		return fmt.Errorf("%w: %s", errConfigNotFound, key)
+	}
+	if len(found) > 1 && !all {
+		return fmt.Errorf("%s has multiple values, use --unset-all", key)
+	}
+	data, err := dropEmptySections(path, cf.edit(found, ""))
+	if err != nil {
+		return err
+	}
+	return writeConfigFile(path, data)
+}
+
+// config is `config [--system|--global|--local|--file <f>] [--type=bool|int] <action>`,
+// the actions being `<key>` or `--get <key>`, `--get-all <key>`, `<key> <value>`,
+// `--add <key> <value>`, `--replace-all <key> <value>`, `--unset <key>`, `--unset-all <key>`
+// and `-l/--list`. values patterns narrow set, get-all and unset down. `get`, `set`,
+// `unset` and `list` work as subcommands too.
+func config(runEnv string, args []string) (string, error) {
+	action, scope, file, kind := "", "", "", ""
+	var rest []string
+	setAction := func(a string) error {
+		if action != "" && action != a {
+			return errors.New("config err: only one action at a time")
+		}
+		action = a
+		return nil
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var err error
+		switch {
+		case arg == "--system" || arg == "--global" || arg == "--local":
+			scope = strings.TrimPrefix(arg, "--")
+		case arg == "-f" || arg == "--file":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("config err: %s needs a file", arg)
+			}
+			i++
+			scope, file = "file", args[i]
+		case strings.HasPrefix(arg, "--file="):
+			scope, file = "file", strings.TrimPrefix(arg, "--file=")
+		case arg == "--bool" || arg == "--int":
+			kind = strings.TrimPrefix(arg, "--")
+		case strings.HasPrefix(arg, "--type="):
+			kind = strings.TrimPrefix(arg, "--type=")
+			if kind != "bool" && kind != "int" {
+				return "", fmt.Errorf("config err: unrecognized --type argument, %s", kind)
+			}
+		case arg == "--get" || arg == "--get-all" || arg == "--add" || arg == "--replace-all" || arg == "--unset" || arg == "--unset-all":
+			err = setAction(strings.TrimPrefix(arg, "--"))
+		case arg == "-l" || arg == "--list":
+			err = setAction("list")
+		case arg == "--all" && (action == "get" || action == "unset"):
+			action += "-all"
+		case arg == "--append" && action == "set":
+			action = "add"
+		case len(rest) == 0 && action == "" && (arg == "get" || arg == "set" || arg == "unset" || arg == "list"):
+			action = arg
+		case strings.HasPrefix(arg, "-") && len(rest) == 0:
+			return "", fmt.Errorf("config err: unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if action == "" {
+		switch len(rest) {
+		case 0:
+			return "", errors.New("config err: no action given, use --list, <key> or <key> <value>")
+		case 1:
+			action = "get"
+		default:
+			action = "set"
+		}
+	}
+
+	// the file reads and writes go to, writes default to the repository config
+	path := filepath.Join(gitDir(runEnv), "config")
+	switch scope {
+	case "system":
+		if path = os.Getenv("GIT_CONFIG_SYSTEM"); path == "" {
+			path = "/etc/gitconfig"
+		}
+	case "global":
+		var err error
+		if path, err = globalConfigPath(); err != nil {
+			return "", fmt.Errorf("config err: %w", err)
+		}
+	case "file":
+		path = file
+	}
+	pattern := func(i int) (*regexp.Regexp, error) {
+		if len(rest) <= i {
+			return nil, nil
+		}
+		re, err := regexp.Compile(rest[i])
+		if err != nil {
+			return nil, fmt.Errorf("config err: invalid value pattern %s: %w", rest[i], err)
+		}
+		return re, nil
+	}
+	args2 := func(min, max int) error {
+		if len(rest) < min || len(rest) > max {
+			return fmt.Errorf("config err: wrong number of arguments for %s", action)
+		}
+		return nil
+	}
+
+	switch action {
+	case "get", "get-all", "list":
+		var cfg *Config
+		var err error
+		if scope == "" {
+			cfg, err = loadConfig(runEnv)
+		} else {
+			cfg, err = loadConfigFile(scope, path)
+		}
+		if err != nil {
+			return "", fmt.Errorf("config err: %w", err)
+		}
+		if action == "list" {
+			if err := args2(0, 0); err != nil {
+				return "", err
+			}
+			var sb strings.Builder
+			for _, e := range cfg.entries {
+				if e.noValue {
+					fmt.Fprintf(&sb, "%s\n", e.key)
+					continue
+				}
+				fmt.Fprintf(&sb, "%s=%s\n", e.key, e.value)
+			}
+			return sb.String(), nil
+		}
+		if err := args2(1, 2); err != nil {
+			return "", err
+		}
+		if _, err := canonicalKey(rest[0]); err != nil {
+			return "", fmt.Errorf("config err: %w", err)
+		}
+		re, err := pattern(1)
+		if err != nil {
+			return "", err
+		}
+		var values []configEntry
+		for _, e := range cfg.getAll(rest[0]) {
+			if re == nil || re.MatchString(e.value) {
+				values = append(values, e)
+			}
+		}
+		if len(values) == 0 {
+			return "", fmt.Errorf("config err: %w: %s", errConfigNotFound, rest[0])
+		}
+		if action == "get" {
+			values = values[len(values)-1:]
+		}
+		var sb strings.Builder
+		for _, e := range values {
+			value, err := formatTyped(e, kind)
+			if err != nil {
+				return "", fmt.Errorf("config err: %w", err)
+			}
+			sb.WriteString(value + "\n")
+		}
+		return sb.String(), nil
+
+	case "set", "add", "replace-all":
+		max := 2
+		if action != "add" {
+			max = 3
+		}
+		if err := args2(2, max); err != nil {
+			return "", err
+		}
+		value := rest[1]
+		if kind != "" {
+			typed, err := formatTyped(configEntry{key: rest[0], value: value}, kind)
+			if err != nil {
+				return "", fmt.Errorf("config err: %w", err)
+			}
+			value = typed
+		}
+		re, err := pattern(2)
+		if err != nil {
+			return "", err
+		}
+		if err := setConfig(path, rest[0], value, re, action == "add", action == "replace-all"); err != nil {
+			return "", fmt.Errorf("config err: %w", err)
+		}
+		return "", nil
+
+	case "unset", "unset-all":
+		if err := args2(1, 2); err != nil {
+			return "", err
+		}
+		re, err := pattern(1)
+		if err != nil {
+			return "", err
+		}
+		if err := unsetConfig(path, rest[0], re, action == "unset-all"); err != nil {
+			return "", fmt.Errorf("config err: %w", err)
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("config err: unknown action %s", action)
+}
+
+// formatTyped canonicalizes a value for --type=bool or --type=int
+func formatTyped(e configEntry, kind string) (string, error) {
+	switch kind {
+	case "bool":
+		b, err := parseConfigBool(e)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(b), nil
+	case "int":
+		n, err := parseConfigInt(e)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+	return e.value, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	data := "# comment\n" +
+		"[core]\n" +
+		"\tbare = false ; trailing comment\n" +
+		"\tFileMode\n" +
+		"[remote \"Origin \\\"x\\\"\"]\n" +
+		"\turl = \"  spaced # kept\"  \n" +
+		"\tpath = a\\tb\\\\c\\\n" +
+		"continued\n" +
+		"[alias.Short] co = checkout\n"
+	cf, err := parseConfig("test", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []configEntry{
+		{key: "core.bare", value: "false"},
+		{key: "core.filemode", noValue: true},
+		{key: "remote.Origin \"x\".url", value: "  spaced # kept"},
+		{key: "remote.Origin \"x\".path", value: "a\tb\\ccontinued"},
+		{key: "alias.short.co", value: "checkout"},
+	}
+	if len(cf.vars) != len(want) {
+		t.Fatalf("got %d vars: %+v", len(cf.vars), cf.vars)
+	}
+	for i, v := range cf.vars {
+		if v.key() != want[i].key || v.value != want[i].value || v.noValue != want[i].noValue {
+			t.Fatalf("var %d is %s=%q (%v), want %+v", i, v.key(), v.value, v.noValue, want[i])
+		}
+	}
+
+	t.Run("should reject broken lines", func(t *testing.T) {
+		for _, bad := range []string{"key = outside\n", "[core\n", "[core]\n\tname = \"open\n", "[core]\n\t1x = y\n"} {
+			if _, err := parseConfig("test", []byte(bad)); err == nil {
+				t.Fatalf("expected %q to fail", bad)
+			}
+		}
+	})
+
+	t.Run("should parse booleans and scaled integers", func(t *testing.T) {
+		for value, want := range map[string]bool{"yes": true, "On": true, "1": true, "false": false, "": false, "0": false} {
+			if got, err := parseConfigBool(configEntry{value: value}); err != nil || got != want {
+				t.Fatalf("%q parsed as %v %v", value, got, err)
+			}
+		}
+		if got, _ := parseConfigBool(configEntry{noValue: true}); !got {
+			t.Fatal("a bare key should be true")
+		}
+		for value, want := range map[string]int64{"10": 10, "1k": 1024, "2m": 2 << 20, "1g": 1 << 30, "-3": -3} {
+			if got, err := parseConfigInt(configEntry{value: value}); err != nil || got != want {
+				t.Fatalf("%q parsed as %d %v", value, got, err)
+			}
+		}
+		if _, err := parseConfigInt(configEntry{value: "12x"}); err == nil {
+			t.Fatal("expected bad numeric value")
+		}
+	})
+}
+
+func TestConfig(t *testing.T) {
+	setupWorktree(t, nil)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_CONFIG_GLOBAL", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[user]\n\tname = Global\n[include]\n\tpath = more.inc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "more.inc"), []byte("[user]\n\temail = included@example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := config("test", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("should merge scopes and follow includes", func(t *testing.T) {
+		run("user.name", "Local")
+		if got := run("user.name"); got != "Local\n" {
+			t.Fatalf("local value should win, got %q", got)
+		}
+		if got := run("--global", "user.name"); got != "Global\n" {
+			t.Fatalf("got %q", got)
+		}
+		if got := run("--get", "user.email"); got != "included@example.com\n" {
+			t.Fatalf("include was not followed, got %q", got)
+		}
+		if sig, err := identity("test", "author"); err != nil || sig.Name != "Local" || sig.Email != "included@example.com" {
+			t.Fatalf("identity did not come from the config: %+v %v", sig, err)
+		}
+	})
+
+	t.Run("should edit the repository config in place", func(t *testing.T) {
+		run("--add", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+		run("--add", "remote.origin.fetch", "+refs/tags/*:refs/tags/*")
+		if _, err := config("test", []string{"remote.origin.fetch", "x"}); err == nil {
+			t.Fatal("expected multiple values error")
+		}
+		run("core.comment", "has # hash")
+		raw, err := os.ReadFile("tmp/.git/config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "[user]\n\tname = Local\n" +
+			"[remote \"origin\"]\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n\tfetch = +refs/tags/*:refs/tags/*\n" +
+			"[core]\n\tcomment = \"has # hash\"\n"
+		if string(raw) != want {
+			t.Fatalf("config is %q, want %q", raw, want)
+		}
+		if got := run("--get-all", "remote.origin.fetch", "tags"); got != "+refs/tags/*:refs/tags/*\n" {
+			t.Fatalf("got %q", got)
+		}
+
+		run("--unset-all", "remote.origin.fetch")
+		run("unset", "core.comment")
+		raw, _ = os.ReadFile("tmp/.git/config")
+		if string(raw) != "[user]\n\tname = Local\n" {
+			t.Fatalf("empty sections were not removed: %q", raw)
+		}
+		if _, err := config("test", []string{"--unset", "core.comment"}); err == nil {
+			t.Fatal("expected missing key error")
+		}
+	})
+
+	t.Run("should keep a header that shares its line with the key", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "c")
+		run := func(args ...string) string {
+			t.Helper()
+			out, err := config("test", append([]string{"--file", file}, args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return out
+		}
+		if err := os.WriteFile(file, []byte("[core] editor = vim\n\tbare = false\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		run("core.editor", "emacs")
+		if raw, _ := os.ReadFile(file); string(raw) != "[core]\n\teditor = emacs\n\tbare = false\n" {
+			t.Fatalf("set gave %q", raw)
+		}
+		if err := os.WriteFile(file, []byte("[core] editor = vim\n\tbare = false\n[x] y = 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		run("--unset", "core.editor")
+		run("--unset", "x.y")
+		if raw, _ := os.ReadFile(file); string(raw) != "[core]\n\tbare = false\n" {
+			t.Fatalf("unset gave %q", raw)
+		}
+		if got := run("--list"); got != "core.bare=false\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should list and convert types", func(t *testing.T) {
+		run("pack.window", "1k")
+		if got := run("--type=int", "pack.window"); got != "1024\n" {
+			t.Fatalf("got %q", got)
+		}
+		run("--bool", "core.bare", "no")
+		if got := run("--local", "--list"); got != "user.name=Local\npack.window=1k\ncore.bare=false\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+)
+
+var errBadDelta = errors.New("corrupt delta")
+
+// deltaHeaderSize reads one of the two little endian base 128 sizes at the start of a delta
+func deltaHeaderSize(delta []byte, pos int) (int, int, error) {
+	size, shift := 0, uint(0)
+	for {
+		if pos >= len(delta) {
+			return 0, 0, fmt.Errorf("%w: truncated header", errBadDelta)
+		}
+		c := delta[pos]
+		pos++
+		size |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return size, pos, nil
+		}
+	}
+}
+
+// applyDelta rebuilds a target object from base and git's copy/insert instructions
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, pos, err := deltaHeaderSize(delta, 0)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("%w: base is %d bytes but delta expects %d", errBadDelta, len(base), srcSize)
+	}
+	dstSize, pos, err := deltaHeaderSize(delta, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+	for pos < len(delta) {
+		op := delta[pos]
+		pos++
+		switch {
+		case op&0x80 != 0:
+			// copy from base, bits 0-3 select offset bytes and bits 4-6 select size bytes
+			offset, size := 0, 0
+			for i := range 4 {
+				if op&(1<<i) != 0 {
+					if pos >= len(delta) {
+						return nil, fmt.Errorf("%w: truncated copy offset", errBadDelta)
+					}
+					offset |= int(delta[pos]) << (8 * i)
+					pos++
+				}
+			}
+			for i := range 3 {
+				if op&(0x10<<i) != 0 {
+					if pos >= len(delta) {
+						return nil, fmt.Errorf("%w: truncated copy size", errBadDelta)
+					}
+					size |= int(delta[pos]) << (8 * i)
+					pos++
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, fmt.Errorf("%w: copy of %d bytes at %d is outside the base", errBadDelta, size, offset)
+			}
+			out = append(out, base[offset:offset+size]...)
+		case op != 0:
+			// insert the next op bytes literally
+			if pos+int(op) > len(delta) {
+				return nil, fmt.Errorf("%w: truncated insert", errBadDelta)
+			}
+			out = append(out, delta[pos:pos+int(op)]...)
+			pos += int(op)
+		default:
+			return nil, fmt.Errorf("%w: reserved opcode 0", errBadDelta)
+		}
+	}
+	if len(out) != dstSize {
+		return nil, fmt.Errorf("%w: produced %d bytes, expected %d", errBadDelta, len(out), dstSize)
+	}
+	return out, nil
+}
+
+// deltaBaseCacheLimit caps the bytes kept around for resolving delta chains
+const deltaBaseCacheLimit = 32 << 20
+
+type deltaCacheKey struct {
+	pack   *packFile
+	offset int64
+}
+
+type deltaCacheEntry struct {
+	key  deltaCacheKey
+	kind string
+	data []byte
+}
+
+// deltaBaseCache is a least recently used cache of inflated pack objects bounded by size
+type deltaBaseCache struct {
+	limit int
+	used  int
+	order *list.List
+	items map[deltaCacheKey]*list.Element
+}
+
+func newDeltaBaseCache(limit int) *deltaBaseCache {
+	return &deltaBaseCache{
+		limit: limit,
+		order: list.New(),
+		items: map[deltaCacheKey]*list.Element{},
+	}
+}
+
+func (c *deltaBaseCache) get(p *packFile, offset int64) (string, []byte, bool) {
+	el, ok := c.items[deltaCacheKey{p, offset}]
+	if !ok {
+		return "", nil, false
+	}
+	c.order.MoveToFront(el)
+	entry := el.Value.(*deltaCacheEntry)
+	return entry.kind, entry.data, true
+}
+
+func (c *deltaBaseCache) add(p *packFile, offset int64, kind string, data []byte) {
+	if len(data) > c.limit {
+		return
+	}
+	key := deltaCacheKey{p, offset}
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&deltaCacheEntry{key, kind, data})
+	c.used += len(data)
+	for c.used > c.limit {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*deltaCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.items, entry.key)
+		c.used -= len(entry.data)
+	}
+}
+
+// deltaBlock is the granularity the base is indexed at when searching for copies
+const deltaBlock = 16
+
+// deltaIndex remembers where each block of a base object starts so many targets
+// can be diffed against the same base without rebuilding the lookup table
+type deltaIndex struct {
+	base   []byte
+	blocks map[uint32][]int
+}
+
+func blockHash(b []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range b {
+		h = (h ^ uint32(c)) * 16777619
+	}
+	return h
+}
+
+func newDeltaIndex(base []byte) *deltaIndex {
+	idx := &deltaIndex{base: base, blocks: map[uint32][]int{}}
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		h := blockHash(base[i : i+deltaBlock])
+		// very repetitive data would make buckets huge without finding better matches
+		if len(idx.blocks[h]) < 64 {
+			idx.blocks[h] = append(idx.blocks[h], i)
+		}
+	}
+	return idx
+}
+
+// appendDeltaSize writes a size in the little endian base 128 form of delta headers
+func appendDeltaSize(out []byte, size int) []byte {
+	for size >= 0x80 {
+		out = append(out, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(out, byte(size))
+}
+
+func appendInsert(out, data []byte) []byte {
+	for len(data) > 0 {
+		n := min(len(data), 0x7f)
+		out = append(out, byte(n))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+func appendCopy(out []byte, offset, size int) []byte {
+	for size > 0 {
+		n := min(size, 0x10000)
+		op := byte(0x80)
+		var args []byte
+		for i := range 4 {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				op |= 1 << i
+				args = append(args, b)
+			}
+		}
+		// a size of exactly 0x10000 is encoded by leaving every size byte out
+		if n != 0x10000 {
+			for i := range 3 {
+				if b := byte(n >> (8 * i)); b != 0 {
+					op |= 0x10 << i
+					args = append(args, b)
+				}
+			}
+		}
+		out = append(out, op)
+		out = append(out, args...)
+		offset += n
+		size -= n
+	}
+	return out
+}
+
+// createDelta encodes target as copy/insert instructions against the indexed base.
+// it gives up and returns nil as soon as the delta would grow past maxSize.
+func (idx *deltaIndex) createDelta(target []byte, maxSize int) []byte {
+	base := idx.base
+	out := appendDeltaSize(nil, len(base))
+	out = appendDeltaSize(out, len(target))
+
+	var pending []byte
+	i := 0
+	for i < len(target) {
+		bestOff, bestLen := 0, 0
+		if i+deltaBlock <= len(target) {
+			for _, off := range idx.blocks[blockHash(target[i:i+deltaBlock])] {
+				n := 0
+				for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+					n++
+				}
+				if n > bestLen {
+					bestOff, bestLen = off, n
+				}
+			}
+		}
+		if bestLen < deltaBlock {
+			pending = append(pending, target[i])
+			i++
+			if maxSize > 0 && len(out)+len(pending) > maxSize {
+				return nil
+			}
+			continue
+		}
+		i += bestLen
+		// grow the match backwards over bytes we were about to insert
+		for bestOff > 0 && len(pending) > 0 && base[bestOff-1] == pending[len(pending)-1] {
+			bestOff--
+			bestLen++
+			pending = pending[:len(pending)-1]
+		}
+		out = appendInsert(out, pending)
+		pending = pending[:0]
+		out = appendCopy(out, bestOff, bestLen)
+		if maxSize > 0 && len(out) > maxSize {
+			return nil
+		}
+	}
+	out = appendInsert(out, pending)
+	if maxSize > 0 && len(out) > maxSize {
+		return nil
+	}
+	return out
+}
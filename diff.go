@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// diffFile is one side of a compared path. worktree files are read from disk, everything
+// else from the object store.
+type diffFile struct {
+	mode     uint32
+	hash     string
+	worktree bool
+}
+
+// diffSource reads the content of one side, submodules show as the commit they point at
+func diffSource(runEnv string, store *ObjectStore, p string, f *diffFile) ([]byte, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if f.mode == modeGitlink {
+		return []byte("Subproject commit " + f.hash + "\n"), nil
+	}
+	if f.worktree {
+		full := worktreePath(runEnv, p)
+		info, err := os.Lstat(full)
+		if err != nil {
+			return nil, err
+		}
+		return readWorktreeFile(full, info)
+	}
+	_, data, err := store.Get(f.hash)
+	return data, err
+}
+
+// treeDiffFiles maps the paths of a tree-ish revision to their blobs
+func treeDiffFiles(runEnv string, store *ObjectStore, rev string) (map[string]*diffFile, error) {
+	files := map[string]*diffFile{}
+	hash, err := resolveRevision(runEnv, rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := peelObject(store, hash, "tree")
+	if err != nil {
+		return nil, err
+	}
+	flat, err := flattenTree(store, tree)
+	if err != nil {
+		return nil, err
+	}
+	for p, e := range flat {
+		files[p] = &diffFile{mode: e.mode, hash: e.hash}
+	}
+	return files, nil
+}
+
+// indexDiffFiles maps the merged paths of the index to their blobs
+func indexDiffFiles(idx *Index) map[string]*diffFile {
+	files := map[string]*diffFile{}
+	for _, e := range idx.Entries {
+		if e.stage() == 0 {
+			files[e.Path] = &diffFile{mode: e.Mode, hash: e.Hash}
+		}
+	}
+	return files
+}
+
+// worktreeDiffFiles maps the tracked paths that are still on disk to what they hold now,
+// files whose stat data matches the index are not hashed again
+func worktreeDiffFiles(runEnv string, idx *Index) (map[string]*diffFile, error) {
+	files := map[string]*diffFile{}
+	for _, e := range idx.Entries {
+		if e.stage() != 0 {
+			continue
+		}
+		full := worktreePath(runEnv, e.Path)
+		info, err := os.Lstat(full)
+		if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir() && e.Mode != modeGitlink) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		f := &diffFile{mode: fileMode(info), hash: e.Hash, worktree: true}
+		if e.Mode == modeGitlink || e.statMatches(info) {
+			f.mode = e.Mode
+			files[e.Path] = f
+			continue
+		}
+		data, err := readWorktreeFile(full, info)
+		if err != nil {
+			return nil, err
+		}
+		f.hash = hashFor("blob", data)
+		files[e.Path] = f
+	}
+	return files, nil
+}
+
+// diffOptions is how patches are made: lines of context around changes and the line diff algorithm
+type diffOptions struct {
+	context   int
+	algorithm string
+}
+
+// writeFilePatch prints the git style patch for one path, a missing side is a creation or
+// a deletion and a path that changes between file, symlink and submodule is shown as both
+func writeFilePatch(sb *strings.Builder, runEnv string, store *ObjectStore, p string, a, b *diffFile, opts diffOptions) error {
+	if a != nil && b != nil && typeChanged(a.mode, b.mode) {
+		if err := writeFilePatch(sb, runEnv, store, p, a, nil, opts); err != nil {
+			return err
+		}
+		return writeFilePatch(sb, runEnv, store, p, nil, b, opts)
+	}
+	short := func(f *diffFile) string {
+		if f == nil {
+			return abbrev(store, zeroHash, 7)
+		}
+		return abbrev(store, f.hash, 7)
+	}
+	fmt.Fprintf(sb, "diff --git a/%s b/%s\n", p, p)
+	switch {
+	case a == nil:
+		fmt.Fprintf(sb, "new file mode %06o\nindex %s..%s\n", b.mode, short(a), short(b))
+	case b == nil:
+		fmt.Fprintf(sb, "deleted file mode %06o\nindex %s..%s\n", a.mode, short(a), short(b))
+	case a.mode != b.mode:
+		fmt.Fprintf(sb, "old mode %06o\nnew mode %06o\n", a.mode, b.mode)
+		if a.hash == b.hash {
+			return nil
+		}
+		fmt.Fprintf(sb, "index %s..%s\n", short(a), short(b))
+	default:
+		fmt.Fprintf(sb, "index %s..%s %06o\n", short(a), short(b), a.mode)
+	}
+
+	before, err := diffSource(runEnv, store, p, a)
+	if err != nil {
+		return err
+	}
+	after, err := diffSource(runEnv, store, p, b)
+	if err != nil {
+		return err
+	}
+	from, to := "a/"+p, "b/"+p
+	if a == nil {
+		from = "/dev/null"
+	}
+	if b == nil {
+		to = "/dev/null"
+	}
+	if isBinary(before) || isBinary(after) {
+		fmt.Fprintf(sb, "Binary files %s and %s differ\n", from, to)
+		return nil
+	}
+	if opts.context == 0 {
+		// no context line can come from an identical tail, git skips diffing it
+		before, after = trimCommonTail(before, after)
+	}
+	hunks := diffLines(splitLines(before), splitLines(after), opts.algorithm).unified(opts.context)
+	if hunks != "" {
+		fmt.Fprintf(sb, "--- %s\n+++ %s\n%s", from, to, hunks)
+	}
+	return nil
+}
+
+// diff is `diff [-U<n>] [--] [<path>...]` for the work tree against the index,
+// `diff --cached [<commit>]` for the index against HEAD (or commit), `diff <commit>` for the
+// work tree against a commit and `diff <commit> <commit>` or `diff A..B` for two
+// revisions. changes print as git style patches with n lines of context (3 by default),
+// binary files only say that they differ. --diff-algorithm (or --minimal, --patience and
+// --histogram) picks the line diff algorithm, diff.algorithm sets the default.
+func diff(runEnv string, args []string) (string, error) {
+	cached, opts := false, diffOptions{context: 3}
+	var revs, paths []string
+	store := newObjectStore(runEnv)
+	if name := configValue(runEnv, "diff.algorithm"); name != "" {
+		algorithm, ok := parseDiffAlgorithm(name)
+		if !ok {
+			return "", fmt.Errorf("diff err: unknown value for config 'diff.algorithm': %s", name)
+		}
+		opts.algorithm = algorithm
+	}
+	isTreeish := func(rev string) bool {
+		hash, err := resolveRevision(runEnv, rev)
+		if err != nil {
+			return false
+		}
+		_, err = peelObject(store, hash, "tree")
+		return err == nil
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case arg == "--cached" || arg == "--staged":
+			cached = true
+		case strings.HasPrefix(arg, "-U") || strings.HasPrefix(arg, "--unified="):
+			n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(arg, "-U"), "--unified="))
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("diff err: invalid context length in %s", arg)
+			}
+			opts.context = n
+		case arg == "--minimal" || arg == "--patience" || arg == "--histogram":
+			opts.algorithm = arg[2:]
+		case arg == "--diff-algorithm" || strings.HasPrefix(arg, "--diff-algorithm="):
+			name, ok := strings.CutPrefix(arg, "--diff-algorithm=")
+			if !ok && i+1 < len(args) {
+				i++
+				name = args[i]
+			}
+			algorithm, ok := parseDiffAlgorithm(name)
+			if !ok {
+				return "", errors.New(`diff err: option diff-algorithm accepts "myers", "minimal", "patience" and "histogram"`)
+			}
+			opts.algorithm = algorithm
+		case strings.HasPrefix(arg, "-"):
+			return "", fmt.Errorf("diff err: unknown option %s", arg)
+		case len(paths) == 0 && len(revs) < 2 && isTreeish(arg):
+			revs = append(revs, arg)
+		case len(paths) == 0 && len(revs) == 0 && strings.Contains(arg, ".."):
+			from, to, _ := strings.Cut(arg, "..")
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			if !isTreeish(from) || !isTreeish(to) {
+				return "", fmt.Errorf("diff err: %s", unknownRevision(arg))
+			}
+			revs = append(revs, from, to)
+		default:
+			p, err := toRepoPath(runEnv, arg)
+			if err != nil {
+				return "", fmt.Errorf("diff err: %w", err)
+			}
+			if _, err := os.Lstat(worktreePath(runEnv, p)); err != nil {
+				return "", fmt.Errorf("diff err: %s", unknownRevision(arg))
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if cached && len(revs) > 1 {
+		return "", errors.New("diff err: --cached compares the index with a single commit")
+	}
+	specs, err := parsePathspecs(runEnv, paths)
+	if err != nil {
+		return "", fmt.Errorf("diff err: %w", err)
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return "", fmt.Errorf("diff err: %w", err)
+	}
+
+	var before, after map[string]*diffFile
+	var unmerged []string
+	switch {
+	case len(revs) == 2:
+		if before, err = treeDiffFiles(runEnv, store, revs[0]); err == nil {
+			after, err = treeDiffFiles(runEnv, store, revs[1])
+		}
+	case cached:
+		rev := "HEAD"
+		if len(revs) == 1 {
+			rev = revs[0]
+		}
+		if _, head, _ := headCommit(runEnv); head == "" && len(revs) == 0 {
+			before = map[string]*diffFile{}
+		} else {
+			before, err = treeDiffFiles(runEnv, store, rev)
+		}
+		after = indexDiffFiles(idx)
+	default:
+		if len(revs) == 1 {
+			before, err = treeDiffFiles(runEnv, store, revs[0])
+		} else {
+			before = indexDiffFiles(idx)
+			for _, e := range idx.Entries {
+				if e.stage() != 0 && matchAny(specs, e.Path) && (len(unmerged) == 0 || unmerged[len(unmerged)-1] != e.Path) {
+					unmerged = append(unmerged, e.Path)
+				}
+			}
+		}
+		if err == nil {
+			after, err = worktreeDiffFiles(runEnv, idx)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("diff err: %w", err)
+	}
+
+	changed := map[string]bool{}
+	for p, f := range before {
+		if n := after[p]; n == nil || n.hash != f.hash || n.mode != f.mode {
+			changed[p] = true
+		}
+	}
+	for p := range after {
+		if before[p] == nil {
+			changed[p] = true
+		}
+	}
+	for _, p := range unmerged {
+		changed[p] = true
+	}
+	sorted := make([]string, 0, len(changed))
+	for p := range changed {
+		if matchAny(specs, p) {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.Strings(sorted)
+
+	var sb strings.Builder
+	for _, p := range sorted {
+		if i := sort.SearchStrings(unmerged, p); i < len(unmerged) && unmerged[i] == p {
+			fmt.Fprintf(&sb, "* Unmerged path %s\n", p)
+			continue
+		}
+		if err := writeFilePatch(&sb, runEnv, store, p, before[p], after[p], opts); err != nil {
+			return "", fmt.Errorf("diff err: %s: %w", p, err)
+		}
+	}
+	return sb.String(), nil
+}
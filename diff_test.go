@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	setupWorktree(t, map[string]string{"a.txt": "one\ntwo\nthree\n", "bin": "\x00\x01"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	commitAll := func(msg string) {
+		t.Helper()
+		if err := add("test", []string{"-A"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := commit("test", []string{"-m", msg}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commitAll("first")
+	store := newObjectStore("test")
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := diff("test", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	short := func(content string) string {
+		return abbrev(store, hashFor("blob", []byte(content)), 7)
+	}
+
+	t.Run("should compare the work tree with the index", func(t *testing.T) {
+		if got := run(); got != "" {
+			t.Fatalf("a clean tree should have no diff, got %q", got)
+		}
+		writeWorktree(t, "a.txt", "one\n2\nthree\n")
+		want := "diff --git a/a.txt b/a.txt\n" +
+			"index " + short("one\ntwo\nthree\n") + ".." + short("one\n2\nthree\n") + " 100644\n" +
+			"--- a/a.txt\n+++ b/a.txt\n" +
+			"@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+		if got := run(); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got := run("-U0", "tmp/a.txt"); !strings.HasSuffix(got, "@@ -2 +2 @@ one\n-two\n+2\n") {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should compare the index with HEAD", func(t *testing.T) {
+		writeWorktree(t, "new.txt", "fresh\n")
+		if err := add("test", []string{"tmp/new.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		want := "diff --git a/new.txt b/new.txt\n" +
+			"new file mode 100644\n" +
+			"index 0000000.." + short("fresh\n") + "\n" +
+			"--- /dev/null\n+++ b/new.txt\n" +
+			"@@ -0,0 +1 @@\n+fresh\n"
+		if got := run("--cached"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should compare two revisions and spot binary files", func(t *testing.T) {
+		writeWorktree(t, "bin", "\x00\x02")
+		commitAll("second")
+		out := run("HEAD~1", "HEAD")
+		if !strings.Contains(out, "Binary files a/bin and b/bin differ\n") {
+			t.Fatalf("got %q", out)
+		}
+		if !strings.Contains(out, "+++ b/new.txt\n") || !strings.Contains(out, "-two\n+2\n") {
+			t.Fatalf("got %q", out)
+		}
+		if got := run("HEAD~1..HEAD"); got != out {
+			t.Fatalf("got %q, want %q", got, out)
+		}
+		if _, err := diff("test", []string{"tmp/nope"}); err == nil || !strings.Contains(err.Error(), "unknown revision or path") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+	t.Run("should pick the algorithm from the flags or diff.algorithm", func(t *testing.T) {
+		writeWorktree(t, "go.txt", "func a() {\n\treturn err\n}\n\nfunc b() {\n\treturn err\n}\n\nfunc c() {\n}\n")
+		commitAll("functions")
+		writeWorktree(t, "go.txt", "func b() {\n\treturn err\n}\n\nfunc a() {\n\treturn err\n}\n\nfunc c() {\n}\n")
+		myers, patience := "@@ -1,8 +1,8 @@\n-func a() {\n+func b() {\n", "@@ -1,10 +1,10 @@\n-func a() {\n-\treturn err\n"
+		if out := run("tmp/go.txt"); !strings.Contains(out, myers) {
+			t.Fatalf("got %q", out)
+		}
+		for _, args := range [][]string{{"--patience"}, {"--diff-algorithm=patience"}, {"--diff-algorithm", "patience"}} {
+			if out := run(append(args, "tmp/go.txt")...); !strings.Contains(out, patience) {
+				t.Fatalf("%v: got %q", args, out)
+			}
+		}
+		if _, err := config("test", []string{"diff.algorithm", "patience"}); err != nil {
+			t.Fatal(err)
+		}
+		if out := run("tmp/go.txt"); !strings.Contains(out, patience) {
+			t.Fatalf("got %q", out)
+		}
+		if out := run("--diff-algorithm=default", "tmp/go.txt"); !strings.Contains(out, myers) {
+			t.Fatalf("the flag should win over the config, got %q", out)
+		}
+		if _, err := diff("test", []string{"--diff-algorithm=fast"}); err == nil || !strings.Contains(err.Error(), "accepts") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := config("test", []string{"diff.algorithm", "fast"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := diff("test", nil); err == nil || !strings.Contains(err.Error(), "unknown value for config 'diff.algorithm'") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
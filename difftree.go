@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// treeChange is a line of diff-tree output, a nil side is a creation or deletion. renames
+// and copies keep the source path in from and its similarity out of maxScore in score.
+type treeChange struct {
+	status byte
+	path   string
+	from   string
+	score  int
+	a, b   *diffFile
+}
+
+// treeOrderKey sorts paths the way trees are ordered, a tree as if its name ended in '/'
+func treeOrderKey(p string, mode uint32) string {
+	if mode == modeTree {
+		return p + "/"
+	}
+	return p
+}
+
+// treeEntries lists the entries of a tree that the pathspecs select. without recursive only
+// the top level is listed and subtrees are entries of their own, trees are never listed
+// with it. an empty hash is an empty tree.
+func treeEntries(store *ObjectStore, tree string, specs []pathspec, recursive bool) (map[string]*diffFile, error) {
+	files := map[string]*diffFile{}
+	if tree == "" {
+		return files, nil
+	}
+	leads := func(p string) bool {
+		for _, s := range specs {
+			if s.glob != nil || strings.HasPrefix(s.path, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	return files, walkTree(store, tree, "", func(e treeEntry, p string) (bool, error) {
+		selected := matchAny(specs, p) || (e.isTree() && leads(p))
+		if e.isTree() && recursive {
+			return selected, nil
+		}
+		if selected {
+			files[p] = &diffFile{mode: e.mode, hash: e.hash}
+		}
+		return false, nil
+	})
+}
+
+// compareTrees pairs up the entries of two trees in tree order. a path that turns from a
+// tree into something else or back is deleted and created, the file kinds changing
+// between each other is a T.
+func compareTrees(before, after map[string]*diffFile) []*treeChange {
+	var changes []*treeChange
+	for p, a := range before {
+		b := after[p]
+		switch {
+		case b == nil || (a.mode == modeTree) != (b.mode == modeTree):
+			changes = append(changes, &treeChange{status: 'D', path: p, a: a})
+			if b != nil {
+				changes = append(changes, &treeChange{status: 'A', path: p, b: b})
+			}
+		case typeChanged(a.mode, b.mode):
+			changes = append(changes, &treeChange{status: 'T', path: p, a: a, b: b})
+		case a.hash != b.hash || a.mode != b.mode:
+			changes = append(changes, &treeChange{status: 'M', path: p, a: a, b: b})
+		}
+	}
+	for p, b := range after {
+		if before[p] == nil {
+			changes = append(changes, &treeChange{status: 'A', path: p, b: b})
+		}
+	}
+	key := func(c *treeChange) string {
+		if c.b != nil {
+			return treeOrderKey(c.path, c.b.mode)
+		}
+		return treeOrderKey(c.path, c.a.mode)
+	}
+	sort.Slice(changes, func(i, j int) bool { return key(changes[i]) < key(changes[j]) })
+	return changes
+}
+
+// rawLine is the `:mode mode sha sha status\tpath` line git prints for a change, renames
+// and copies carry their similarity in percent and both paths
+func (c *treeChange) rawLine() string {
+	side := func(f *diffFile) (uint32, string) {
+		if f == nil {
+			return 0, zeroHash
+		}
+		return f.mode, f.hash
+	}
+	modeA, hashA := side(c.a)
+	modeB, hashB := side(c.b)
+	if c.from != "" {
+		return fmt.Sprintf(":%06o %06o %s %s %c%03d\t%s\t%s\n", modeA, modeB, hashA, hashB, c.status, c.score*100/maxScore, c.from, c.path)
+	}
+	return fmt.Sprintf(":%06o %06o %s %s %c\t%s\n", modeA, modeB, hashA, hashB, c.status, c.path)
+}
+
+// diffTree is `diff-tree [-r] [-M[<n>]] [-C[<n>]] [--find-copies-harder] <tree-ish> <tree-ish>
+// [--] [<path>...]` and prints a raw line for every entry that differs between the two
+// trees. only the top level is compared unless -r, then just the files are. -M pairs
+// deleted and created files that are at least n similar (50% by default) as renames, -C
+// also finds copies of changed files and given twice (or --find-copies-harder) of any file.
+func diffTree(runEnv string, args []string) (string, error) {
+	recursive := false
+	opts := renameOptions{score: defaultRenameScore}
+	var revs, paths []string
+	scoreOption := func(arg, prefix, long string) error {
+		value := strings.TrimPrefix(strings.TrimPrefix(arg, prefix), "=")
+		if value == "" {
+			return nil
+		}
+		score, ok := parseRenameScore(value)
+		if !ok {
+			return fmt.Errorf("diff-tree err: invalid argument to %s", long)
+		}
+		opts.score = score
+		return nil
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case arg == "-r":
+			recursive = true
+		case arg == "--find-copies-harder":
+			opts.detect, opts.copies, opts.harder = true, true, true
+		case strings.HasPrefix(arg, "-M") || arg == "--find-renames" || strings.HasPrefix(arg, "--find-renames="):
+			opts.detect = true
+			prefix := "-M"
+			if strings.HasPrefix(arg, "--") {
+				prefix = "--find-renames"
+			}
+			if err := scoreOption(arg, prefix, "find-renames"); err != nil {
+				return "", err
+			}
+		case strings.HasPrefix(arg, "-C") || arg == "--find-copies" || strings.HasPrefix(arg, "--find-copies="):
+			// asking twice means looking at unchanged files too
+			opts.harder = opts.harder || opts.copies
+			opts.detect, opts.copies = true, true
+			prefix := "-C"
+			if strings.HasPrefix(arg, "--") {
+				prefix = "--find-copies"
+			}
+			if err := scoreOption(arg, prefix, "find-copies"); err != nil {
+				return "", err
+			}
+		case strings.HasPrefix(arg, "-"):
+			return "", fmt.Errorf("diff-tree err: unknown option %s", arg)
+		case len(revs) < 2:
+			revs = append(revs, arg)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(revs) < 2 {
+		return "", errors.New("diff-tree err: usage diff-tree [-r] [-M[<n>]] [-C[<n>]] <tree-ish> <tree-ish> [<path>...]")
+	}
+
+	store := newObjectStore(runEnv)
+	var trees []string
+	for _, rev := range revs {
+		hash, err := resolveRevision(runEnv, rev)
+		if err != nil {
+			return "", fmt.Errorf("diff-tree err: %s", unknownRevision(rev))
+		}
+		tree, err := peelObject(store, hash, "tree")
+		if err != nil {
+			return "", fmt.Errorf("diff-tree err: %s is not a tree-ish: %w", rev, err)
+		}
+		trees = append(trees, tree)
+	}
+	specs, err := parsePathspecs(runEnv, paths)
+	if err != nil {
+		return "", fmt.Errorf("diff-tree err: %w", err)
+	}
+	before, err := treeEntries(store, trees[0], specs, recursive)
+	if err != nil {
+		return "", fmt.Errorf("diff-tree err: %w", err)
+	}
+	after, err := treeEntries(store, trees[1], specs, recursive)
+	if err != nil {
+		return "", fmt.Errorf("diff-tree err: %w", err)
+	}
+
+	changes := compareTrees(before, after)
+	if opts.detect {
+		if changes, err = detectRenames(store, changes, before, opts); err != nil {
+			return "", fmt.Errorf("diff-tree err: %w", err)
+		}
+	}
+	var sb strings.Builder
+	for _, c := range changes {
+		sb.WriteString(c.rawLine())
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffTree(t *testing.T) {
+	lines := func(prefix string, n int) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(&sb, "%s%d\n", prefix, i)
+		}
+		return sb.String()
+	}
+	one, keep := lines("", 20), lines("k", 30)
+	setupWorktree(t, map[string]string{"d/one": one, "keep": keep, "gone": "bye\n"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	commitAll := func(msg string) {
+		t.Helper()
+		if err := add("test", []string{"-A"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := commit("test", []string{"-m", msg}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commitAll("first")
+	if _, err := rm("test", []string{"-r", "-q", "tmp/d", "tmp/gone"}); err != nil {
+		t.Fatal(err)
+	}
+	writeWorktree(t, "moved", one+"21\n")
+	writeWorktree(t, "keep", keep+"k31\n")
+	writeWorktree(t, "copy", keep)
+	commitAll("second")
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := diffTree("test", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	blob := func(content string) string {
+		return hashFor("blob", []byte(content))
+	}
+	added := func(content, p string) string {
+		return ":000000 100644 " + zeroHash + " " + blob(content) + " A\t" + p + "\n"
+	}
+	deleted := func(content, p string) string {
+		return ":100644 000000 " + blob(content) + " " + zeroHash + " D\t" + p + "\n"
+	}
+	modified := ":100644 100644 " + blob(keep) + " " + blob(keep+"k31\n") + " M\tkeep\n"
+	renamed := ":100644 100644 " + blob(one) + " " + blob(one+"21\n") + " R094\td/one\tmoved\n"
+
+	t.Run("should list changed files with -r", func(t *testing.T) {
+		want := added(keep, "copy") + deleted(one, "d/one") + deleted("bye\n", "gone") + modified + added(one+"21\n", "moved")
+		if got := run("-r", "HEAD~1", "HEAD"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should show a changed directory as one entry without -r", func(t *testing.T) {
+		tree, err := resolveRevision("test", "HEAD~1:d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := run("HEAD~1", "HEAD")
+		if !strings.Contains(got, ":040000 000000 "+tree+" "+zeroHash+" D\td\n") || strings.Contains(got, "d/one") {
+			t.Fatalf("got %q", got)
+		}
+		if got := run("HEAD~1", "HEAD", "tmp/keep"); got != modified {
+			t.Fatalf("got %q, want %q", got, modified)
+		}
+	})
+
+	t.Run("should pair similar files as renames with -M", func(t *testing.T) {
+		want := added(keep, "copy") + deleted("bye\n", "gone") + modified + renamed
+		if got := run("-r", "-M", "HEAD~1", "HEAD"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got := run("-r", "-M95%", "HEAD~1", "HEAD"); !strings.Contains(got, "D\td/one\n") || strings.Contains(got, "R094") {
+			t.Fatalf("94%% similar should not be a rename at 95%%, got %q", got)
+		}
+	})
+
+	t.Run("should find copies of changed files with -C", func(t *testing.T) {
+		copied := ":100644 100644 " + blob(keep) + " " + blob(keep) + " C100\tkeep\tcopy\n"
+		want := copied + deleted("bye\n", "gone") + modified + renamed
+		if got := run("-r", "-C", "HEAD~1", "HEAD"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should reject bad arguments", func(t *testing.T) {
+		if _, err := diffTree("test", []string{"-Mx", "HEAD~1", "HEAD"}); err == nil || !strings.Contains(err.Error(), "invalid argument to find-renames") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := diffTree("test", []string{"HEAD"}); err == nil {
+			t.Fatal("expected usage error")
+		}
+	})
+}
+
+func TestParseRenameScore(t *testing.T) {
+	for arg, want := range map[string]int{"5": 30000, "50%": 30000, "05": 3000, ".5": 30000, "100%": maxScore, "9": 54000, "75%": 45000} {
+		if got, ok := parseRenameScore(arg); !ok || got != want {
+			t.Fatalf("%s: got %d %v, want %d", arg, got, ok, want)
+		}
+	}
+	if _, ok := parseRenameScore("5x"); ok {
+		t.Fatal("expected trailing garbage to be rejected")
+	}
+}
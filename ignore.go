@@ -0,0 +1,255 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ignoreRule is one pattern line of a .gitignore or .git/info/exclude
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	base    bool // no slash in the pattern, so it is matched against the last path element
+}
+
+// ignoreMatcher answers whether work tree paths are ignored, .gitignore files are
+// loaded lazily the first time a path below their directory is asked about
+type ignoreMatcher struct {
+	root  string
+	rules map[string][]ignoreRule // by repo relative directory, "" is the top level
+}
+
+func newIgnoreMatcher(runEnv string) *ignoreMatcher {
+	m := &ignoreMatcher{root: workTree(runEnv), rules: map[string][]ignoreRule{}}
+	// info/exclude applies like a top level .gitignore with lower priority
+	exclude := parseIgnoreFile(filepath.Join(gitDir(runEnv), "info", "exclude"), "")
+	m.rules[""] = append(exclude, parseIgnoreFile(filepath.Join(m.root, ".gitignore"), "")...)
+	return m
+}
+
+func parseIgnoreFile(file, dir string) []ignoreRule {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.Contains(line, "/") {
+			rule.base = true
+			rule.re = compileGlob("", line, false)
+		} else {
+			line = strings.TrimPrefix(line, "/")
+			prefix := ""
+			if dir != "" {
+				prefix = regexp.QuoteMeta(dir + "/")
+			}
+			rule.re = compileGlob(prefix, line, false)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// globToRegexp translates a gitignore/pathspec glob, crossSlash lets * match / as pathspecs do
+func globToRegexp(glob string, crossSlash bool) string {
+	star := "[^/]*"
+	if crossSlash {
+		star = ".*"
+	}
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("/.*")
+			i += 2
+		case c == '*':
+			sb.WriteString(star)
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			class, n, ok := globClass(glob[i:], crossSlash)
+			if !ok {
+				sb.WriteString(`\[`)
+				continue
+			}
+			sb.WriteString(class)
+			i += n - 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// posixClasses are the [:name:] classes wildmatch knows, all of them are valid in regexp too
+var posixClasses = []string{"alnum", "alpha", "blank", "cntrl", "digit", "graph", "lower", "print", "punct", "space", "upper", "xdigit"}
+
+// globClass translates the bracket expression glob starts with and returns how many bytes
+// it took. like wildmatch a leading ] is literal, ! or ^ negate and a reversed range
+// matches nothing. ok is false when it is not closed or names an unknown class, then the
+// [ is a literal.
+func globClass(glob string, crossSlash bool) (string, int, bool) {
+	i := 1
+	negate := i < len(glob) && (glob[i] == '!' || glob[i] == '^')
+	if negate {
+		i++
+	}
+	var items strings.Builder
+	literal := func(r rune) {
+		if r < utf8.RuneSelf && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			items.WriteByte('\\')
+		}
+		items.WriteRune(r)
+	}
+	// next reads one possibly escaped character of the class
+	next := func() (rune, bool) {
+		if glob[i] == '\\' && i+1 < len(glob) {
+			i++
+		}
+		r, size := utf8.DecodeRuneInString(glob[i:])
+		i += size
+		return r, i < len(glob)
+	}
+	for first := true; i < len(glob); first = false {
+		if glob[i] == ']' && !first {
+			if !negate && items.Len() == 0 {
+				// nothing left to match, like [z-a]
+				return `[^\x00-\x{10FFFF}]`, i + 1, true
+			}
+			class := items.String()
+			if negate {
+				if !crossSlash {
+					class += "/"
+				}
+				return "[^" + class + "]", i + 1, true
+			}
+			return "[" + class + "]", i + 1, true
+		}
+		if strings.HasPrefix(glob[i:], "[:") {
+			end := strings.Index(glob[i+2:], ":]")
+			if end >= 0 {
+				name := glob[i+2 : i+2+end]
+				if !slices.Contains(posixClasses, name) {
+					return "", 0, false
+				}
+				items.WriteString("[:" + name + ":]")
+				i += end + 4
+				continue
+			}
+		}
+		lo, more := next()
+		if !more {
+			return "", 0, false
+		}
+		if glob[i] == '-' && i+1 < len(glob) && glob[i+1] != ']' {
+			i++
+			hi, more := next()
+			if !more {
+				return "", 0, false
+			}
+			if lo <= hi {
+				literal(lo)
+				items.WriteByte('-')
+				literal(hi)
+			}
+			continue
+		}
+		literal(lo)
+	}
+	return "", 0, false
+}
+
+// compileGlob turns a glob into an anchored regexp behind the already quoted prefix, a
+// glob that still does not compile is matched literally
+func compileGlob(prefix, glob string, crossSlash bool) *regexp.Regexp {
+	re, err := regexp.Compile("^" + prefix + globToRegexp(glob, crossSlash) + "$")
+	if err != nil {
+		return regexp.MustCompile("^" + prefix + regexp.QuoteMeta(glob) + "$")
+	}
+	return re
+}
+
+func (m *ignoreMatcher) rulesFor(dir string) []ignoreRule {
+	if rules, ok := m.rules[dir]; ok {
+		return rules
+	}
+	rules := parseIgnoreFile(filepath.Join(m.root, filepath.FromSlash(dir), ".gitignore"), dir)
+	m.rules[dir] = rules
+	return rules
+}
+
+// match applies the rules of every directory above p, deeper files and later lines win
+func (m *ignoreMatcher) match(p string, isDir bool) bool {
+	ignored := false
+	dirs := []string{""}
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' {
+			dirs = append(dirs, p[:i])
+		}
+	}
+	name := path.Base(p)
+	for _, dir := range dirs {
+		for _, rule := range m.rulesFor(dir) {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			subject := p
+			if rule.base {
+				subject = name
+			}
+			if rule.re.MatchString(subject) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// ignored reports whether a repo relative path is ignored, a path inside an
+// ignored directory is ignored whatever the rules say about it
+func (m *ignoreMatcher) ignored(p string, isDir bool) bool {
+	if p == ".git" || strings.HasPrefix(p, ".git/") {
+		return true
+	}
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && m.match(p[:i], true) {
+			return true
+		}
+	}
+	return m.match(p, isDir)
+}
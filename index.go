@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// index entry flags
+const (
+	indexAssumeValid  = 0x8000
+	indexExtended     = 0x4000
+	indexStageMask    = 0x3000
+	indexStageShift   = 12
+	indexNameMask     = 0x0fff
+	indexSkipWorktree = 0x4000 // in the v3 extended flags
+	indexIntentToAdd  = 0x2000 // in the v3 extended flags
+)
+
+// file modes as git stores them in trees and in the index
+const (
+	modeTree       = 0o040000
+	modeFile       = 0o100644
+	modeExecutable = 0o100755
+	modeSymlink    = 0o120000
+	modeGitlink    = 0o160000
+)
+
+// IndexEntry is one staged path with the stat data used to detect changes cheaply
+type IndexEntry struct {
+	CTimeSec  uint32
+	CTimeNsec uint32
+	MTimeSec  uint32
+	MTimeNsec uint32
+	Dev       uint32
+	Ino       uint32
+	Mode      uint32
+	UID       uint32
+	GID       uint32
+	Size      uint32
+	Hash      string
+	Flags     uint16 // assume-valid and stage, the name length is derived from Path
+	ExtFlags  uint16 // skip-worktree and intent-to-add, only written in v3
+	Path      string
+}
+
+func (e *IndexEntry) stage() int {
+	return int(e.Flags&indexStageMask) >> indexStageShift
+}
+
+// indexExtension is an optional extension block kept as raw bytes
+type indexExtension struct {
+	signature string
+	data      []byte
+}
+
+// Index is the staging area stored in .git/index (DIRC format, versions 2 and 3)
+type Index struct {
+	Version    uint32
+	Entries    []*IndexEntry
+	Extensions []indexExtension
+}
+
+func indexPath(runEnv string) string {
+	return filepath.Join(gitDir(runEnv), "index")
+}
+
+// readIndex loads the index, a repository without one has an empty v2 index
+func readIndex(runEnv string) (*Index, error) {
+	raw, err := os.ReadFile(indexPath(runEnv))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &Index{Version: 2}, nil
+		}
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	return parseIndex(raw)
+}
+
+func parseIndex(raw []byte) (*Index, error) {
+	if len(raw) < 12+20 || string(raw[:4]) != "DIRC" {
+		return nil, errors.New("index: bad signature")
+	}
+	sum := sha1.Sum(raw[:len(raw)-20])
+	if !bytes.Equal(sum[:], raw[len(raw)-20:]) {
+		return nil, errors.New("index: checksum mismatch")
+	}
+	idx := &Index{Version: binary.BigEndian.Uint32(raw[4:8])}
+	if idx.Version != 2 && idx.Version != 3 {
+		return nil, fmt.Errorf("index: unsupported version %d", idx.Version)
+	}
+	count := int(binary.BigEndian.Uint32(raw[8:12]))
+	body := raw[:len(raw)-20]
+	pos := 12
+
+	for range count {
+		if pos+62 > len(body) {
+			return nil, errors.New("index: truncated entry")
+		}
+		start := pos
+		field := func() uint32 {
+			v := binary.BigEndian.Uint32(body[pos:])
+			pos += 4
+			return v
+		}
+		e := &IndexEntry{}
+		e.CTimeSec, e.CTimeNsec = field(), field()
+		e.MTimeSec, e.MTimeNsec = field(), field()
+		e.Dev, e.Ino, e.Mode = field(), field(), field()
+		e.UID, e.GID, e.Size = field(), field(), field()
+		e.Hash = hex.EncodeToString(body[pos : pos+20])
+		pos += 20
+		flags := binary.BigEndian.Uint16(body[pos:])
+		pos += 2
+		if flags&indexExtended != 0 {
+			if idx.Version < 3 {
+				return nil, errors.New("index: extended flag in a v2 index")
+			}
+			if pos+2 > len(body) {
+				return nil, errors.New("index: truncated entry")
+			}
+			e.ExtFlags = binary.BigEndian.Uint16(body[pos:])
+			pos += 2
+		}
+		e.Flags = flags &^ (indexNameMask | indexExtended)
+
+		// names of 0xfff bytes or more are only terminated by the nul
+		nul := bytes.IndexByte(body[pos:], 0)
+		if nul < 0 {
+			return nil, errors.New("index: unterminated path")
+		}
+		e.Path = string(body[pos : pos+nul])
+		pos += nul
+		// entries are padded with 1-8 nuls to a multiple of 8 bytes
+		pos = start + (pos-start+8)&^7
+		if pos > len(body) {
+			return nil, errors.New("index: truncated entry padding")
+		}
+		idx.Entries = append(idx.Entries, e)
+	}
+
+	for pos+8 <= len(body) {
+		sig := string(body[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(body[pos+4:]))
+		pos += 8
+		if pos+size > len(body) {
+			return nil, fmt.Errorf("index: truncated %s extension", sig)
+		}
+		// lower case extensions are required to read the index correctly
+		if sig[0] < 'A' || sig[0] > 'Z' {
+			return nil, fmt.Errorf("index: unsupported required extension %q", sig)
+		}
+		idx.Extensions = append(idx.Extensions, indexExtension{sig, body[pos : pos+size]})
+		pos += size
+	}
+	if pos != len(body) {
+		return nil, errors.New("index: trailing garbage")
+	}
+	return idx, nil
+}
+
+// serialize encodes the index including the trailing checksum
+func (idx *Index) serialize() []byte {
+	idx.sort()
+	version := idx.Version
+	if version < 2 {
+		version = 2
+	}
+	for _, e := range idx.Entries {
+		if e.ExtFlags != 0 {
+			version = 3
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(idx.Entries)))
+	for _, e := range idx.Entries {
+		start := buf.Len()
+		for _, v := range []uint32{e.CTimeSec, e.CTimeNsec, e.MTimeSec, e.MTimeNsec, e.Dev, e.Ino, e.Mode, e.UID, e.GID, e.Size} {
+			binary.Write(&buf, binary.BigEndian, v)
+		}
+		raw, _ := hex.DecodeString(e.Hash)
+		buf.Write(raw)
+		flags := e.Flags&^(indexNameMask|indexExtended) | uint16(min(len(e.Path), indexNameMask))
+		if e.ExtFlags != 0 {
+			flags |= indexExtended
+		}
+		binary.Write(&buf, binary.BigEndian, flags)
+		if e.ExtFlags != 0 {
+			binary.Write(&buf, binary.BigEndian, e.ExtFlags)
+		}
+		buf.WriteString(e.Path)
+		pad := 8 - (buf.Len()-start)%8
+		buf.Write(make([]byte, pad))
+	}
+	for _, ext := range idx.Extensions {
+		buf.WriteString(ext.signature)
+		binary.Write(&buf, binary.BigEndian, uint32(len(ext.data)))
+		buf.Write(ext.data)
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+// write stores the index through index.lock so readers never see a half written file.
+// cached extensions like TREE describe the old entries and are dropped, REUC is kept.
+func (idx *Index) write(runEnv string) error {
+	kept := idx.Extensions[:0]
+	for _, ext := range idx.Extensions {
+		if ext.signature == "REUC" {
+			kept = append(kept, ext)
+		}
+	}
+	idx.Extensions = kept
+
+	lock, err := acquireLock(indexPath(runEnv))
+	if err != nil {
+		return err
+	}
+	return lock.commit(idx.serialize())
+}
+
+// sort orders entries by path then stage which is what git expects on disk
+func (idx *Index) sort() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.stage() < b.stage()
+	})
+}
+
+// find returns the stage 0 entry for path or nil
+func (idx *Index) find(path string) *IndexEntry {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Path >= path
+	})
+	for ; i < len(idx.Entries) && idx.Entries[i].Path == path; i++ {
+		if idx.Entries[i].stage() == 0 {
+			return idx.Entries[i]
+		}
+	}
+	return nil
+}
+
+// set adds or replaces the entries for e.Path, any conflict stages for it are resolved away
+func (idx *Index) set(e *IndexEntry) {
+	idx.remove(e.Path)
+	idx.Entries = append(idx.Entries, e)
+	idx.sort()
+}
+
+// remove drops every stage of path and reports whether anything was removed
+func (idx *Index) remove(path string) bool {
+	kept := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if e.Path != path {
+			kept = append(kept, e)
+		}
+	}
+	removed := len(kept) != len(idx.Entries)
+	idx.Entries = kept
+	return removed
+}
+
+// fileMode maps a file on disk to the mode git records for it
+func fileMode(info os.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return modeSymlink
+	case info.IsDir():
+		return modeGitlink
+	case info.Mode()&0o111 != 0:
+		return modeExecutable
+	default:
+		return modeFile
+	}
+}
+
+// newIndexEntry builds an entry for a file in the work tree that hashes to hash
+func newIndexEntry(path string, info os.FileInfo, hash string) *IndexEntry {
+	e := &IndexEntry{Path: path, Hash: hash, Mode: fileMode(info), Size: uint32(info.Size())}
+	fillStat(e, info)
+	return e
+}
+
+// statMatches reports whether the file still looks like the one the entry was made from
+func (e *IndexEntry) statMatches(info os.FileInfo) bool {
+	other := &IndexEntry{Mode: fileMode(info), Size: uint32(info.Size())}
+	fillStat(other, info)
+	return e.Mode == other.Mode && e.Size == other.Size &&
+		e.MTimeSec == other.MTimeSec && e.MTimeNsec == other.MTimeNsec &&
+		e.CTimeSec == other.CTimeSec && e.CTimeNsec == other.CTimeNsec &&
+		e.Ino == other.Ino && e.Dev == other.Dev
+}
+
+// lsFiles lists the staged paths, with -s/--stage in the `mode hash stage\tpath` form
+func lsFiles(runEnv string, args []string) (string, error) {
+	stage := false
+	for _, arg := range args {
+		switch arg {
+		case "-s", "--stage":
+			stage = true
+		default:
+			return "", fmt.Errorf("ls-files err: unknown option %s", arg)
+		}
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return "", fmt.Errorf("ls-files err: %w", err)
+	}
+	var sb strings.Builder
+	for _, e := range idx.Entries {
+		if stage {
+			fmt.Fprintf(&sb, "%06o %s %d\t%s\n", e.Mode, e.Hash, e.stage(), e.Path)
+		} else {
+			sb.WriteString(e.Path + "\n")
+		}
+	}
+	return sb.String(), nil
+}
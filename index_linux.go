@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fillStat copies the stat data git keeps for change detection
+func fillStat(e *IndexEntry, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		e.MTimeSec, e.MTimeNsec = uint32(info.ModTime().Unix()), uint32(info.ModTime().Nanosecond())
+		e.CTimeSec, e.CTimeNsec = e.MTimeSec, e.MTimeNsec
+		return
+	}
+	e.CTimeSec, e.CTimeNsec = uint32(st.Ctim.Sec), uint32(st.Ctim.Nsec)
+	e.MTimeSec, e.MTimeNsec = uint32(st.Mtim.Sec), uint32(st.Mtim.Nsec)
+	e.Dev, e.Ino = uint32(st.Dev), uint32(st.Ino)
+	e.UID, e.GID = st.Uid, st.Gid
+}
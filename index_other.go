@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// fillStat copies the stat data git keeps for change detection, only the
+// modification time is portable so it stands in for the change time too
+func fillStat(e *IndexEntry, info os.FileInfo) {
+	e.MTimeSec, e.MTimeNsec = uint32(info.ModTime().Unix()), uint32(info.ModTime().Nanosecond())
+	e.CTimeSec, e.CTimeNsec = e.MTimeSec, e.MTimeNsec
+}
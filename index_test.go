@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := initialize("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	longPath := strings.Repeat("d/", 2100) + "file.txt"
+	idx := &Index{Version: 2}
+	idx.set(&IndexEntry{Path: "b.txt", Mode: modeFile, Size: 5, MTimeSec: 10, MTimeNsec: 20, Hash: hashFor("blob", []byte("hello"))})
+	idx.set(&IndexEntry{Path: "a/run.sh", Mode: modeExecutable, Hash: hashFor("blob", nil), ExtFlags: indexIntentToAdd})
+	idx.set(&IndexEntry{Path: longPath, Mode: modeFile, Hash: hashFor("blob", []byte("x")), Flags: indexAssumeValid})
+	idx.Extensions = []indexExtension{{"TREE", []byte("stale")}, {"REUC", []byte("kept")}}
+
+	if err := idx.write("test"); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	if _, err := os.Stat("tmp/.git/index.lock"); !os.IsNotExist(err) {
+		t.Fatal("index.lock left behind")
+	}
+
+	got, err := readIndex("test")
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	if got.Version != 3 {
+		t.Fatalf("expected v3 because of extended flags, got %d", got.Version)
+	}
+	if len(got.Entries) != 3 || got.Entries[0].Path != "a/run.sh" || got.Entries[1].Path != "b.txt" {
+		t.Fatalf("entries out of order: %+v", got.Entries)
+	}
+	if e := got.find(longPath); e == nil || e.Flags&indexAssumeValid == 0 {
+		t.Fatalf("long path entry lost: %+v", e)
+	}
+	if e := got.find("a/run.sh"); e.Mode != modeExecutable || e.ExtFlags != indexIntentToAdd {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e := got.find("b.txt"); e.MTimeSec != 10 || e.MTimeNsec != 20 || e.Size != 5 {
+		t.Fatalf("stat data lost: %+v", e)
+	}
+	if len(got.Extensions) != 1 || got.Extensions[0].signature != "REUC" {
+		t.Fatalf("expected only REUC to survive a write, got %+v", got.Extensions)
+	}
+
+	raw, _ := os.ReadFile("tmp/.git/index")
+	if !bytes.Equal(got.serialize(), raw) {
+		t.Fatal("index did not round trip byte for byte")
+	}
+
+	t.Run("should reject a corrupt index", func(t *testing.T) {
+		raw[20] ^= 0xff
+		if _, err := parseIndex(raw); err == nil {
+			t.Fatal("expected checksum error")
+		}
+	})
+
+	t.Run("should refuse to write while locked", func(t *testing.T) {
+		if err := os.WriteFile("tmp/.git/index.lock", nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove("tmp/.git/index.lock")
+		if err := got.write("test"); err == nil {
+			t.Fatal("expected lock error")
+		}
+	})
+
+	t.Run("should list staged files", func(t *testing.T) {
+		out, err := lsFiles("test", []string{"-s"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "100755 e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 0\ta/run.sh\n") {
+			t.Fatalf("unexpected ls-files output:\n%s", out)
+		}
+	})
+}
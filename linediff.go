@@ -0,0 +1,1005 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// binaryCheckBytes is how far into a file git looks for a NUL byte before calling it binary
+const binaryCheckBytes = 8000
+
+// isBinary reports whether data should be shown as `Binary files ... differ` instead of lines
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binaryCheckBytes)], 0) >= 0
+}
+
+// splitLines cuts data after every newline, only the last line can lack one
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i:]
+	}
+	return lines
+}
+
+// trimCommonTail drops the identical end of both files in 1024 byte blocks and gives back
+// what follows the first newline of the dropped part, so both still end on a line boundary
+func trimCommonTail(a, b []byte) ([]byte, []byte) {
+	const block = 1024
+	trimmed := 0
+	for trimmed+block <= min(len(a), len(b)) && bytes.Equal(a[len(a)-trimmed-block:len(a)-trimmed], b[len(b)-trimmed-block:len(b)-trimmed]) {
+		trimmed += block
+	}
+	recovered := 0
+	for recovered < trimmed {
+		recovered++
+		if a[len(a)-trimmed+recovered-1] == '\n' {
+			break
+		}
+	}
+	return a[:len(a)-trimmed+recovered], b[:len(b)-trimmed+recovered]
+}
+
+// lineDiff is the edit from the lines of a to the lines of b. removed marks the lines of
+// a that are gone and added the lines of b that are new, the rest are common to both and
+// appear in the same order. ia and ib are the lines interned to ints so comparing is cheap.
+type lineDiff struct {
+	a, b           []string
+	ia, ib         []int
+	removed, added []bool
+}
+
+// the limits xdiff puts on the search so big files with few common lines stay fast
+const (
+	maxEqualLimit  = 1024 // lines seen more often are only kept when they sit between other matches
+	simscanWindow  = 100
+	keepRunFactor  = 4
+	maxCostMin     = 256
+	snakeCount     = 20
+	heuristicCost  = 256
+	heuristicScale = 4
+	lineMax        = math.MaxInt
+)
+
+// bogoSqrt is xdiff's cheap power of two estimate of the square root of n
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// the line diff algorithms, minimal is Myers without the shortcuts for expensive files
+const (
+	diffMyers     = "myers"
+	diffMinimal   = "minimal"
+	diffPatience  = "patience"
+	diffHistogram = "histogram"
+)
+
+// parseDiffAlgorithm checks the value of --diff-algorithm or diff.algorithm, default is myers
+func parseDiffAlgorithm(name string) (string, bool) {
+	switch name = strings.ToLower(name); name {
+	case "default":
+		return diffMyers, true
+	case diffMyers, diffMinimal, diffPatience, diffHistogram:
+		return name, true
+	}
+	return "", false
+}
+
+// diffLines finds the edit from a to b with the given algorithm the way git's xdiff does,
+// so the result matches `git diff` line for line, and then slides the changed blocks to
+// where a reader expects them
+func diffLines(a, b []string, algorithm string) *lineDiff {
+	d := &lineDiff{a: a, b: b, removed: make([]bool, len(a)), added: make([]bool, len(b))}
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d.ia, d.ib = intern(a), intern(b)
+	switch algorithm {
+	case diffPatience:
+		d.patience(0, len(a), 0, len(b))
+	case diffHistogram:
+		d.histogram(0, len(a), 0, len(b))
+	default:
+		myersDiff(d.ia, d.ib, d.removed, d.added, algorithm == diffMinimal)
+	}
+	compactChanges(a, d.ia, d.removed, d.added)
+	compactChanges(b, d.ib, d.added, d.removed)
+	return d
+}
+
+// myersDiff marks the lines of ia and ib that are not common with Myers' algorithm. common
+// ends are skipped and lines that cannot match are marked up front, without minimal the
+// search also gives up on a shortest edit for expensive stretches like xdiff does.
+func myersDiff(ia, ib []int, removed, added []bool, minimal bool) {
+	ids := 0
+	for _, id := range ia {
+		ids = max(ids, id+1)
+	}
+	for _, id := range ib {
+		ids = max(ids, id+1)
+	}
+	count1, count2 := make([]int, ids), make([]int, ids)
+	for _, id := range ia {
+		count1[id]++
+	}
+	for _, id := range ib {
+		count2[id]++
+	}
+	start := 0
+	for start < min(len(ia), len(ib)) && ia[start] == ib[start] {
+		start++
+	}
+	end := 0
+	for end < min(len(ia), len(ib))-start && ia[len(ia)-1-end] == ib[len(ib)-1-end] {
+		end++
+	}
+
+	// lines missing from the other file are changed for sure, lines found there a lot are
+	// left out of the search when the lines around them are just as hopeless
+	classify := func(ids []int, other []int) []byte {
+		limit := min(bogoSqrt(len(ids)), maxEqualLimit)
+		dis := make([]byte, len(ids))
+		for i := start; i < len(ids)-end; i++ {
+			switch n := other[ids[i]]; {
+			case n == 0:
+				dis[i] = 0
+			case n >= limit && !minimal:
+				dis[i] = 2
+			default:
+				dis[i] = 1
+			}
+		}
+		return dis
+	}
+	reduce := func(ids []int, dis []byte, changed []bool) (kept, index []int) {
+		for i := start; i < len(ids)-end; i++ {
+			if dis[i] == 1 || (dis[i] == 2 && !mostlyUnmatched(dis, i, start, len(ids)-end-1)) {
+				kept, index = append(kept, ids[i]), append(index, i)
+				continue
+			}
+			changed[i] = true
+		}
+		return kept, index
+	}
+	ha1, rindex1 := reduce(ia, classify(ia, count2), removed)
+	ha2, rindex2 := reduce(ib, classify(ib, count1), added)
+
+	s := &myersSearch{ha1: ha1, ha2: ha2, rchg1: make([]bool, len(ha1)), rchg2: make([]bool, len(ha2))}
+	diags := len(ha1) + len(ha2) + 3
+	s.off = len(ha2) + 1
+	s.kvdf, s.kvdb = make([]int, diags), make([]int, diags)
+	s.maxCost = max(bogoSqrt(diags), maxCostMin)
+	s.compare(0, len(ha1), 0, len(ha2), minimal)
+	for i, changed := range s.rchg1 {
+		removed[rindex1[i]] = removed[rindex1[i]] || changed
+	}
+	for i, changed := range s.rchg2 {
+		added[rindex2[i]] = added[rindex2[i]] || changed
+	}
+}
+
+// mark flags a[a0:a0+n1] as removed and b[b0:b0+n2] as added
+func (d *lineDiff) mark(a0, n1, b0, n2 int) {
+	for i := a0; i < a0+n1; i++ {
+		d.removed[i] = true
+	}
+	for j := b0; j < b0+n2; j++ {
+		d.added[j] = true
+	}
+}
+
+// fallBack diffs a[a0:a0+n1] against b[b0:b0+n2] with plain Myers, as if they were whole files
+func (d *lineDiff) fallBack(a0, n1, b0, n2 int) {
+	myersDiff(d.ia[a0:a0+n1], d.ib[b0:b0+n2], d.removed[a0:a0+n1], d.added[b0:b0+n2], false)
+}
+
+// patienceEntry is a line of a in the patience diff, line2 is its only match in b,
+// noMatch or notUnique. lcsPrev and lcsNext chain the longest common sequence.
+type patienceEntry struct {
+	line1, line2     int
+	next             *patienceEntry
+	lcsPrev, lcsNext *patienceEntry
+}
+
+const (
+	noMatch   = -1
+	notUnique = -2
+)
+
+// patience diffs a[a0:a0+n1] against b[b0:b0+n2] by anchoring on the longest common
+// sequence of lines that appear exactly once on both sides, recursing between the anchors.
+// without unique common lines it falls back to Myers.
+func (d *lineDiff) patience(a0, n1, b0, n2 int) {
+	if n1 == 0 || n2 == 0 {
+		d.mark(a0, n1, b0, n2)
+		return
+	}
+	byID := map[int]*patienceEntry{}
+	var first, last *patienceEntry
+	for i := a0; i < a0+n1; i++ {
+		if e, ok := byID[d.ia[i]]; ok {
+			e.line2 = notUnique
+			continue
+		}
+		e := &patienceEntry{line1: i, line2: noMatch}
+		byID[d.ia[i]] = e
+		if first == nil {
+			first = e
+		} else {
+			last.next = e
+		}
+		last = e
+	}
+	matches := false
+	for j := b0; j < b0+n2; j++ {
+		e, ok := byID[d.ib[j]]
+		if !ok {
+			continue
+		}
+		matches = true
+		if e.line2 != noMatch {
+			e.line2 = notUnique
+		} else {
+			e.line2 = j
+		}
+	}
+	if !matches {
+		d.mark(a0, n1, b0, n2)
+		return
+	}
+
+	// patience sorting: sequence[i] ends the longest chain of length i+1 with the smallest line2
+	var sequence []*patienceEntry
+	for e := first; e != nil; e = e.next {
+		if e.line2 < 0 {
+			continue
+		}
+		left, right := -1, len(sequence)
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > e.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		if left >= 0 {
+			e.lcsPrev = sequence[left]
+		}
+		if left+1 == len(sequence) {
+			sequence = append(sequence, e)
+		} else {
+			sequence[left+1] = e
+		}
+	}
+	if len(sequence) == 0 {
+		d.fallBack(a0, n1, b0, n2)
+		return
+	}
+	anchor := sequence[len(sequence)-1]
+	for anchor.lcsPrev != nil {
+		anchor.lcsPrev.lcsNext = anchor
+		anchor = anchor.lcsPrev
+	}
+
+	line1, line2, end1, end2 := a0, b0, a0+n1, b0+n2
+	for {
+		next1, next2 := end1, end2
+		if anchor != nil {
+			next1, next2 = anchor.line1, anchor.line2
+			for next1 > line1 && next2 > line2 && d.ia[next1-1] == d.ib[next2-1] {
+				next1, next2 = next1-1, next2-1
+			}
+		}
+		for line1 < next1 && line2 < next2 && d.ia[line1] == d.ib[line2] {
+			line1, line2 = line1+1, line2+1
+		}
+		if next1 > line1 || next2 > line2 {
+			d.patience(line1, next1-line1, line2, next2-line2)
+		}
+		if anchor == nil {
+			return
+		}
+		for anchor.lcsNext != nil && anchor.lcsNext.line1 == anchor.line1+1 && anchor.lcsNext.line2 == anchor.line2+1 {
+			anchor = anchor.lcsNext
+		}
+		line1, line2 = anchor.line1+1, anchor.line2+1
+		anchor = anchor.lcsNext
+	}
+}
+
+// histogramMaxChain is how often a line may occur in a before histogram gives up on it
+const histogramMaxChain = 64
+
+// histogramRecord is a distinct line of a: ptr is its first occurrence and cnt how often it occurs
+type histogramRecord struct {
+	ptr, cnt int
+}
+
+// histogramRegion is a run of common lines, a[begin1:end1+1] equals b[begin2:end2+1]
+type histogramRegion struct {
+	begin1, end1 int
+	begin2, end2 int
+}
+
+// histogram diffs a[a0:a0+n1] against b[b0:b0+n2] by splitting at the longest common run
+// that contains the rarest lines of a, recursing on both sides of it. when every common
+// line is too frequent it falls back to Myers.
+func (d *lineDiff) histogram(a0, n1, b0, n2 int) {
+	for n1 > 0 || n2 > 0 {
+		if n1 == 0 || n2 == 0 {
+			d.mark(a0, n1, b0, n2)
+			return
+		}
+		lcs, fallBack := d.histogramLCS(a0, n1, b0, n2)
+		if fallBack {
+			d.fallBack(a0, n1, b0, n2)
+			return
+		}
+		if lcs.begin1 < 0 {
+			d.mark(a0, n1, b0, n2)
+			return
+		}
+		d.histogram(a0, lcs.begin1-a0, b0, lcs.begin2-b0)
+		n1, a0 = a0+n1-1-lcs.end1, lcs.end1+1
+		n2, b0 = b0+n2-1-lcs.end2, lcs.end2+1
+	}
+}
+
+// histogramLCS indexes the lines of a by how often they occur and finds the longest common
+// run with the lowest occurrence count, fallBack means common lines exist but all of them
+// are too frequent to be worth it
+func (d *lineDiff) histogramLCS(a0, n1, b0, n2 int) (histogramRegion, bool) {
+	records := map[int]*histogramRecord{}
+	lineMap := make([]*histogramRecord, n1)
+	nextPtr := make([]int, n1)
+
+	// scan a backwards so each record ends up on the first occurrence, chained forward
+	for ptr := a0 + n1 - 1; ptr >= a0; ptr-- {
+		rec := records[d.ia[ptr]]
+		if rec == nil {
+			rec = &histogramRecord{ptr: ptr}
+			records[d.ia[ptr]] = rec
+			nextPtr[ptr-a0] = -1
+		} else {
+			nextPtr[ptr-a0] = rec.ptr
+			rec.ptr = ptr
+		}
+		rec.cnt++
+		lineMap[ptr-a0] = rec
+	}
+
+	lcs := histogramRegion{-1, -1, -1, -1}
+	cnt, common := histogramMaxChain+1, false
+	end1, end2 := a0+n1-1, b0+n2-1
+	for bPtr := b0; bPtr <= end2; {
+		bNext := bPtr + 1
+		rec := records[d.ib[bPtr]]
+		switch {
+		case rec == nil:
+		case rec.cnt > cnt:
+			// too frequent to beat the best run so far
+			common = true
+		default:
+			common = true
+			as := rec.ptr
+			for {
+				np := nextPtr[as-a0]
+				bs, ae, be, rc := bPtr, as, bPtr, rec.cnt
+				for a0 < as && b0 < bs && d.ia[as-1] == d.ib[bs-1] {
+					as, bs = as-1, bs-1
+					if rc > 1 {
+						rc = min(rc, lineMap[as-a0].cnt)
+					}
+				}
+				for ae < end1 && be < end2 && d.ia[ae+1] == d.ib[be+1] {
+					ae, be = ae+1, be+1
+					if rc > 1 {
+						rc = min(rc, lineMap[ae-a0].cnt)
+					}
+				}
+				bNext = max(bNext, be+1)
+				if lcs.end1-lcs.begin1 < ae-as || rc < cnt {
+					lcs, cnt = histogramRegion{as, ae, bs, be}, rc
+				}
+				// the next occurrence of the line in a that is past this run
+				for np != -1 && np <= ae {
+					np = nextPtr[np-a0]
+				}
+				if np == -1 {
+					break
+				}
+				as = np
+			}
+		}
+		bPtr = bNext
+	}
+	return lcs, common && cnt > histogramMaxChain
+}
+
+// mostlyUnmatched reports whether the frequent line at i sits in a run of lines that have
+// no match or many matches, scanning at most 100 lines each way between s and e
+func mostlyUnmatched(dis []byte, i, s, e int) bool {
+	s, e = max(s, i-simscanWindow), min(e, i+simscanWindow)
+	none, many := 0, 1
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			none++
+		} else if dis[i-r] == 2 {
+			many++
+		} else {
+			break
+		}
+	}
+	if none == 0 {
+		return false
+	}
+	noneAfter, manyAfter := 0, 1
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			noneAfter++
+		} else if dis[i+r] == 2 {
+			manyAfter++
+		} else {
+			break
+		}
+	}
+	if noneAfter == 0 {
+		return false
+	}
+	none, many = none+noneAfter, many+manyAfter
+	return many*keepRunFactor < many+none
+}
+
+// myersSearch is the divide and conquer Myers search over the lines left after reducing.
+// kvdf and kvdb hold the furthest reaching forward and backward paths per diagonal
+// i1 - i2, shifted by off so negative diagonals fit, rchg1 and rchg2 mark changed lines.
+type myersSearch struct {
+	ha1, ha2     []int
+	kvdf, kvdb   []int
+	off          int
+	maxCost      int
+	rchg1, rchg2 []bool
+}
+
+// compare marks the edit between ha1[off1:lim1] and ha2[off2:lim2], splitting the box at a
+// point of the edit path and recursing on both halves
+func (s *myersSearch) compare(off1, lim1, off2, lim2 int, minimal bool) {
+	for off1 < lim1 && off2 < lim2 && s.ha1[off1] == s.ha2[off2] {
+		off1, off2 = off1+1, off2+1
+	}
+	for off1 < lim1 && off2 < lim2 && s.ha1[lim1-1] == s.ha2[lim2-1] {
+		lim1, lim2 = lim1-1, lim2-1
+	}
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			s.rchg2[off2] = true
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			s.rchg1[off1] = true
+		}
+	default:
+		i1, i2, minLo, minHi := s.split(off1, lim1, off2, lim2, minimal)
+		s.compare(off1, i1, off2, i2, minLo)
+		s.compare(i1, lim1, i2, lim2, minHi)
+	}
+}
+
+// split runs the forward and the backward search until they meet and returns the meeting
+// point and whether each half still needs a minimal edit. without minimal it settles for
+// a long enough snake, or the furthest reaching path once the cost gets too high.
+func (s *myersSearch) split(off1, lim1, off2, lim2 int, minimal bool) (int, int, bool, bool) {
+	kf := func(d int) *int { return &s.kvdf[s.off+d] }
+	kb := func(d int) *int { return &s.kvdb[s.off+d] }
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax, bmin, bmax := fmid, fmid, bmid, bmid
+	*kf(fmid), *kb(bmid) = off1, lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+		if fmin > dmin {
+			fmin--
+			*kf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+		for d := fmax; d >= fmin; d -= 2 {
+			i1 := *kf(d + 1)
+			if *kf(d - 1) >= *kf(d + 1) {
+				i1 = *kf(d - 1) + 1
+			}
+			prev := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && s.ha1[i1] == s.ha2[i2] {
+				i1, i2 = i1+1, i2+1
+			}
+			if i1-prev > snakeCount {
+				gotSnake = true
+			}
+			*kf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kb(d) <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kb(bmin - 1) = lineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kb(bmax + 1) = lineMax
+		} else {
+			bmax--
+		}
+		for d := bmax; d >= bmin; d -= 2 {
+			i1 := *kb(d + 1) - 1
+			if *kb(d - 1) < *kb(d + 1) {
+				i1 = *kb(d - 1)
+			}
+			prev := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && s.ha1[i1-1] == s.ha2[i2-1] {
+				i1, i2 = i1-1, i2-1
+			}
+			if prev-i1 > snakeCount {
+				gotSnake = true
+			}
+			*kb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kf(d) {
+				return i1, i2, true, true
+			}
+		}
+
+		if minimal {
+			continue
+		}
+
+		// past the heuristic cost a long snake is taken as a good enough split
+		if gotSnake && ec > heuristicCost {
+			best, bi1, bi2 := 0, 0, 0
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := abs(d - fmid)
+				i1 := *kf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > heuristicScale*ec && v > best &&
+					off1+snakeCount <= i1 && i1 < lim1 && off2+snakeCount <= i2 && i2 < lim2 {
+					for k := 1; s.ha1[i1-k] == s.ha2[i2-k]; k++ {
+						if k == snakeCount {
+							best, bi1, bi2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return bi1, bi2, true, false
+			}
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := abs(d - bmid)
+				i1 := *kb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > heuristicScale*ec && v > best &&
+					off1 < i1 && i1 <= lim1-snakeCount && off2 < i2 && i2 <= lim2-snakeCount {
+					for k := 0; s.ha1[i1+k] == s.ha2[i2+k]; k++ {
+						if k == snakeCount-1 {
+							best, bi1, bi2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return bi1, bi2, false, true
+			}
+		}
+
+		// too expensive, split at the furthest reaching path of either search
+		if ec >= s.maxCost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := min(*kf(d), lim1)
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+			bbest, bbest1 := lineMax, lineMax
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := max(off1, *kb(d))
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return fbest1, fbest - fbest1, true, false
+			}
+			return bbest1, bbest - bbest1, false, true
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// the weights git's indent heuristic scores the possible positions of a change with
+const (
+	maxIndent                       = 200
+	maxBlanks                       = 20
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+	indentWeight                    = 60
+	indentHeuristicMaxSliding       = 100
+)
+
+// lineIndent is the width of the leading whitespace with tabs to multiples of 8,
+// -1 for a line that is only whitespace
+func lineIndent(line string) int {
+	indent := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			indent++
+		case '\t':
+			indent += 8 - indent%8
+		case '\n', '\r', '\v', '\f':
+		default:
+			return indent
+		}
+		if indent >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+// splitScore is how bad it looks to start or end a change before a line, lower is better
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+// add scores a split before lines[split] by the blank lines and indentation around it
+func (s *splitScore) add(lines []string, split int) {
+	endOfFile, indent := split >= len(lines), -1
+	if !endOfFile {
+		indent = lineIndent(lines[split])
+	}
+	preBlank, preIndent := 0, -1
+	for i := split - 1; i >= 0; i-- {
+		if preIndent = lineIndent(lines[i]); preIndent != -1 {
+			break
+		}
+		if preBlank++; preBlank == maxBlanks {
+			preIndent = 0
+			break
+		}
+	}
+	postBlank, postIndent := 0, -1
+	for i := split + 1; i < len(lines); i++ {
+		if postIndent = lineIndent(lines[i]); postIndent != -1 {
+			break
+		}
+		if postBlank++; postBlank == maxBlanks {
+			postIndent = 0
+			break
+		}
+	}
+
+	if preIndent == -1 && preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+	blankAfter := 0
+	if indent == -1 {
+		blankAfter = 1 + postBlank
+	}
+	totalBlank := preBlank + blankAfter
+	s.penalty += totalBlankWeight*totalBlank + postBlankWeight*blankAfter
+	if indent == -1 {
+		indent = postIndent
+	}
+	blanks := totalBlank != 0
+	s.effectiveIndent += indent
+	pick := func(withBlank, without int) int {
+		if blanks {
+			return withBlank
+		}
+		return without
+	}
+	switch {
+	case indent == -1 || preIndent == -1 || indent == preIndent:
+	case indent > preIndent:
+		s.penalty += pick(relativeIndentWithBlankPenalty, relativeIndentPenalty)
+	case postIndent != -1 && postIndent > indent:
+		s.penalty += pick(relativeOutdentWithBlankPenalty, relativeOutdentPenalty)
+	default:
+		s.penalty += pick(relativeDedentWithBlankPenalty, relativeDedentPenalty)
+	}
+}
+
+// compare orders two scores, negative when s is the better place to split
+func (s splitScore) compare(o splitScore) int {
+	cmp := 0
+	if s.effectiveIndent > o.effectiveIndent {
+		cmp = 1
+	} else if s.effectiveIndent < o.effectiveIndent {
+		cmp = -1
+	}
+	return indentWeight*cmp + s.penalty - o.penalty
+}
+
+// changeGroup is a run of changed lines [start, end) in one file, empty between two kept lines
+type changeGroup struct{ start, end int }
+
+// compactChanges moves every block of changed lines of one file to the position git
+// would show it at. a block whose first line equals the line after it (or whose last
+// line equals the one before it) can slide without changing the edit, so it is slid as
+// far as it goes, merging with the blocks it runs into, lined up with a change in the
+// other file if it passed one and otherwise put where the indentation reads best.
+// other holds the changes of the other file, kept lines of both files pair up in order.
+func compactChanges(lines []string, ids []int, changed, other []bool) {
+	groupAt := func(flags []bool, start int) changeGroup {
+		end := start
+		for end < len(flags) && flags[end] {
+			end++
+		}
+		return changeGroup{start, end}
+	}
+	next := func(flags []bool, g *changeGroup) bool {
+		if g.end == len(flags) {
+			return false
+		}
+		*g = groupAt(flags, g.end+1)
+		return true
+	}
+	previous := func(flags []bool, g *changeGroup) bool {
+		if g.start == 0 {
+			return false
+		}
+		g.end = g.start - 1
+		g.start = g.end
+		for g.start > 0 && flags[g.start-1] {
+			g.start--
+		}
+		return true
+	}
+	slideDown := func(g *changeGroup) bool {
+		if g.end == len(changed) || ids[g.start] != ids[g.end] {
+			return false
+		}
+		changed[g.start], changed[g.end] = false, true
+		g.start, g.end = g.start+1, g.end+1
+		for g.end < len(changed) && changed[g.end] {
+			g.end++
+		}
+		return true
+	}
+	slideUp := func(g *changeGroup) bool {
+		if g.start == 0 || ids[g.start-1] != ids[g.end-1] {
+			return false
+		}
+		g.start, g.end = g.start-1, g.end-1
+		changed[g.start], changed[g.end] = true, false
+		for g.start > 0 && changed[g.start-1] {
+			g.start--
+		}
+		return true
+	}
+
+	g, og := groupAt(changed, 0), groupAt(other, 0)
+	for {
+		if g.end != g.start {
+			size, earliestEnd, endMatchingOther := 0, 0, -1
+			for size != g.end-g.start {
+				size, endMatchingOther = g.end-g.start, -1
+				for slideUp(&g) {
+					previous(other, &og)
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+				for slideDown(&g) {
+					next(other, &og)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+			}
+			switch {
+			case g.end == earliestEnd:
+			case endMatchingOther != -1:
+				for og.end == og.start {
+					slideUp(&g)
+					previous(other, &og)
+				}
+			default:
+				best, bestScore := -1, splitScore{}
+				for shift := max(earliestEnd, g.end-size-1, g.end-indentHeuristicMaxSliding); shift <= g.end; shift++ {
+					var score splitScore
+					score.add(lines, shift)
+					score.add(lines, shift-size)
+					if best == -1 || score.compare(bestScore) <= 0 {
+						best, bestScore = shift, score
+					}
+				}
+				for g.end > best {
+					slideUp(&g)
+					previous(other, &og)
+				}
+			}
+		}
+		if !next(changed, &g) {
+			return
+		}
+		next(other, &og)
+	}
+}
+
+// diffChange is one block of the edit: del lines removed at a and ins lines added at b
+type diffChange struct {
+	a, del int
+	b, ins int
+}
+
+// changes lists the blocks of the edit in order
+func (d *lineDiff) changes() []diffChange {
+	var out []diffChange
+	for i, j := 0, 0; i < len(d.a) || j < len(d.b); {
+		if (i < len(d.a) && d.removed[i]) || (j < len(d.b) && d.added[j]) {
+			c := diffChange{a: i, b: j}
+			for i < len(d.a) && d.removed[i] {
+				i++
+			}
+			for j < len(d.b) && d.added[j] {
+				j++
+			}
+			c.del, c.ins = i-c.a, j-c.b
+			out = append(out, c)
+			continue
+		}
+		i, j = i+1, j+1
+	}
+	return out
+}
+
+// funcNameLen is how much of a function line git puts after a hunk header
+const funcNameLen = 80
+
+// funcName is the hunk header text for a line, git's default is any line starting with
+// a letter, `_` or `$`, cut to 80 bytes without trailing whitespace
+func funcName(line string) (string, bool) {
+	if line == "" || !(isAlpha(line[0]) || line[0] == '_' || line[0] == '$') {
+		return "", false
+	}
+	if len(line) > funcNameLen {
+		line = line[:funcNameLen]
+	}
+	return strings.TrimRight(line, " \t\n\v\f\r"), true
+}
+
+// hunkRange formats one side of a hunk header, an empty side names the line before it
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// unified renders the edit as unified diff hunks with context lines around every change,
+// changes that are at most 2*context lines apart share a hunk
+func (d *lineDiff) unified(context int) string {
+	changes := d.changes()
+	var sb strings.Builder
+	line := func(prefix byte, text string) {
+		sb.WriteByte(prefix)
+		sb.WriteString(text)
+		if !strings.HasSuffix(text, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	fn, searched := "", -1
+	for first := 0; first < len(changes); {
+		last := first
+		for last+1 < len(changes) && changes[last+1].a-(changes[last].a+changes[last].del) <= 2*context {
+			last++
+		}
+		fc, lc := changes[first], changes[last]
+		s1 := max(fc.a-context, 0)
+		s2 := fc.b - (fc.a - s1)
+		e1 := min(lc.a+lc.del+context, len(d.a))
+		e2 := lc.b + lc.ins + (e1 - (lc.a + lc.del))
+
+		// the function line is searched backwards from the hunk, an earlier hunk's is kept
+		for i := s1 - 1; i > searched; i-- {
+			if name, ok := funcName(d.a[i]); ok {
+				fn = name
+				break
+			}
+		}
+		searched = max(searched, s1-1)
+		fmt.Fprintf(&sb, "@@ -%s +%s @@", hunkRange(s1, e1-s1), hunkRange(s2, e2-s2))
+		if fn != "" {
+			sb.WriteString(" " + fn)
+		}
+		sb.WriteByte('\n')
+
+		i, j := s1, s2
+		for _, c := range changes[first : last+1] {
+			for ; i < c.a; i, j = i+1, j+1 {
+				line(' ', d.a[i])
+			}
+			for ; i < c.a+c.del; i++ {
+				line('-', d.a[i])
+			}
+			for ; j < c.b+c.ins; j++ {
+				line('+', d.b[j])
+			}
+		}
+		for ; i < e1; i++ {
+			line(' ', d.a[i])
+		}
+		first = last + 1
+	}
+	return sb.String()
+}
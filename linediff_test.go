@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	unified := func(a, b string, context int) string {
+		return diffLines(splitLines([]byte(a)), splitLines([]byte(b)), diffMyers).unified(context)
+	}
+
+	t.Run("should print hunks with context and merge close changes", func(t *testing.T) {
+		a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+		b := "1\n2\nthree\n4\n5\n6\n7\n8\nnine\n10\n"
+		want := "@@ -1,10 +1,10 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n"
+		if got := unified(a, b, 3); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		want = "@@ -3 +3 @@\n-3\n+three\n@@ -9 +9 @@\n-9\n+nine\n"
+		if got := unified(a, b, 0); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should name empty sides by the line before them", func(t *testing.T) {
+		if got, want := unified("", "a\nb\n", 3), "@@ -0,0 +1,2 @@\n+a\n+b\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got, want := unified("1\n2\n", "1\n", 0), "@@ -2 +1,0 @@\n-2\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got := unified("same\n", "same\n", 3); got != "" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should mark a missing final newline", func(t *testing.T) {
+		want := "@@ -1 +1 @@\n-x\n\\ No newline at end of file\n+x\n"
+		if got := unified("x", "x\n", 3); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should put the function line in the hunk header", func(t *testing.T) {
+		a := "func f() {\n\ta()\n\tb()\n\tc()\n\td()\n\te()\n}\n"
+		b := strings.Replace(a, "e()", "E()", 1)
+		if got := unified(a, b, 1); !strings.HasPrefix(got, "@@ -5,3 +5,3 @@ func f() {\n") {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should slide an added block to the blank line git picks", func(t *testing.T) {
+		a := "func a() {\n}\n\nfunc c() {\n}\n"
+		b := "func a() {\n}\n\nfunc b() {\n}\n\nfunc c() {\n}\n"
+		want := "@@ -1,5 +1,8 @@\n func a() {\n }\n \n+func b() {\n+}\n+\n func c() {\n }\n"
+		if got := unified(a, b, 3); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should move a swapped function with patience", func(t *testing.T) {
+		a := "func a() {\n\treturn err\n}\n\nfunc b() {\n\treturn err\n}\n\nfunc c() {\n}\n"
+		b := "func b() {\n\treturn err\n}\n\nfunc a() {\n\treturn err\n}\n\nfunc c() {\n}\n"
+		myers := "@@ -1,8 +1,8 @@\n-func a() {\n+func b() {\n \treturn err\n }\n \n-func b() {\n+func a() {\n \treturn err\n }\n \n"
+		patience := "@@ -1,10 +1,10 @@\n-func a() {\n-\treturn err\n-}\n-\n func b() {\n \treturn err\n }\n \n+func a() {\n+\treturn err\n+}\n+\n func c() {\n }\n"
+		for algorithm, want := range map[string]string{diffMyers: myers, diffMinimal: myers, diffPatience: patience, diffHistogram: myers} {
+			if got := diffLines(splitLines([]byte(a)), splitLines([]byte(b)), algorithm).unified(3); got != want {
+				t.Fatalf("%s: got %q, want %q", algorithm, got, want)
+			}
+		}
+	})
+
+	t.Run("should anchor histogram on the rarest common line", func(t *testing.T) {
+		a, b := "b\n{\n{\n\n", "a\n\n\nb\n"
+		for algorithm, want := range map[string]string{
+			diffMyers:     "@@ -1,3 +1,2 @@\n-b\n-{\n-{\n+a\n+\n@@ -4,0 +4 @@ b\n+b\n",
+			diffPatience:  "@@ -0,0 +1,3 @@\n+a\n+\n+\n@@ -2,3 +4,0 @@ b\n-{\n-{\n-\n",
+			diffHistogram: "@@ -1,3 +1 @@\n-b\n-{\n-{\n+a\n@@ -4,0 +3,2 @@ b\n+\n+b\n",
+		} {
+			if got := diffLines(splitLines([]byte(a)), splitLines([]byte(b)), algorithm).unified(0); got != want {
+				t.Fatalf("%s: got %q, want %q", algorithm, got, want)
+			}
+		}
+	})
+
+	t.Run("should name algorithms like git", func(t *testing.T) {
+		if got, ok := parseDiffAlgorithm("Default"); !ok || got != diffMyers {
+			t.Fatalf("got %q %v", got, ok)
+		}
+		if got, ok := parseDiffAlgorithm("HISTOGRAM"); !ok || got != diffHistogram {
+			t.Fatalf("got %q %v", got, ok)
+		}
+		if _, ok := parseDiffAlgorithm("fast"); ok {
+			t.Fatal("expected an unknown algorithm")
+		}
+	})
+
+	t.Run("should find binary content by a NUL byte", func(t *testing.T) {
+		if !isBinary([]byte("a\x00b")) || isBinary([]byte("plain text\n")) {
+			t.Fatal("wrong binary detection")
+		}
+		if isBinary(append([]byte(strings.Repeat("a", binaryCheckBytes)), 0)) {
+			t.Fatal("only the first 8000 bytes count")
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// lockFile is git's `<file>.lock` protocol: the new content is written next to the file
+// and renamed over it on commit, holding the lock keeps other writers out meanwhile
+type lockFile struct {
+	path string
+	f    *os.File
+}
+
+func acquireLock(path string) (*lockFile, error) {
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("unable to create %s: another process seems to be running", lock)
+		}
+		return nil, fmt.Errorf("unable to create %s: %w", lock, err)
+	}
+	return &lockFile{path: path, f: f}, nil
+}
+
+// commit writes data and moves it into place, the lock is released either way
+func (l *lockFile) commit(data []byte) error {
+	lock := l.f.Name()
+	if _, err := l.f.Write(data); err != nil {
+		l.rollback()
+		return fmt.Errorf("failed to write %s: %w", lock, err)
+	}
+	if err := l.f.Close(); err != nil {
+		os.Remove(lock)
+		return fmt.Errorf("failed to close %s: %w", lock, err)
+	}
+	if err := os.Rename(lock, l.path); err != nil {
+		os.Remove(lock)
+		return fmt.Errorf("failed to move %s into place: %w", lock, err)
+	}
+	return nil
+}
+
+// rollback drops the lock and leaves the file untouched
+func (l *lockFile) rollback() {
+	l.f.Close()
+	os.Remove(l.f.Name())
+}
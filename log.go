@@ -0,0 +1,329 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// logCommit is a commit together with its hash
+type logCommit struct {
+	hash string
+	*Commit
+}
+
+// revWalk loads commits once and walks history from a set of revisions
+type revWalk struct {
+	runEnv  string
+	store   *ObjectStore
+	commits map[string]*logCommit
+}
+
+func newRevWalk(runEnv string) *revWalk {
+	return &revWalk{runEnv: runEnv, store: newObjectStore(runEnv), commits: map[string]*logCommit{}}
+}
+
+func (w *revWalk) commit(hash string) (*logCommit, error) {
+	if c, ok := w.commits[hash]; ok {
+		return c, nil
+	}
+	c, err := readCommit(w.store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	w.commits[hash] = &logCommit{hash: hash, Commit: c}
+	return w.commits[hash], nil
+}
+
+// commitQueue pops the most recent committer date first, ties in insertion order like git
+type commitQueue struct {
+	items []*logCommit
+	order map[string]int
+	next  int
+}
+
+func (q *commitQueue) Len() int { return len(q.items) }
+func (q *commitQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if !a.Committer.When.Equal(b.Committer.When) {
+		return a.Committer.When.After(b.Committer.When)
+	}
+	return q.order[a.hash] < q.order[b.hash]
+}
+func (q *commitQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *commitQueue) Push(x any) {
+	c := x.(*logCommit)
+	q.order[c.hash] = q.next
+	q.next++
+	q.items = append(q.items, c)
+}
+func (q *commitQueue) Pop() any {
+	c := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return c
+}
+
+// hidden returns every ancestor of the excluded commits, the ^A side of A..B
+func (w *revWalk) hidden(excluded []string) (map[string]bool, error) {
+	seen := map[string]bool{}
+	stack := append([]string{}, excluded...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		c, err := w.commit(hash)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, c.Parents...)
+	}
+	return seen, nil
+}
+
+// walk lists the commits reachable from include but not from exclude, newest committer
+// date first. max stops the walk early, a negative max means no limit.
+func (w *revWalk) walk(include, exclude []string, max int) ([]*logCommit, error) {
+	hidden, err := w.hidden(exclude)
+	if err != nil {
+		return nil, err
+	}
+	q := &commitQueue{order: map[string]int{}}
+	queued := map[string]bool{}
+	push := func(hash string) error {
+		if queued[hash] || hidden[hash] {
+			return nil
+		}
+		queued[hash] = true
+		c, err := w.commit(hash)
+		if err != nil {
+			return err
+		}
+		heap.Push(q, c)
+		return nil
+	}
+	for _, hash := range include {
+		if err := push(hash); err != nil {
+			return nil, err
+		}
+	}
+	var out []*logCommit
+	for q.Len() > 0 && (max < 0 || len(out) < max) {
+		c := heap.Pop(q).(*logCommit)
+		out = append(out, c)
+		for _, p := range c.Parents {
+			if err := push(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// sortTopo reorders commits so no parent comes before any of its children. byDate picks
+// the newest ready commit (--date-order), otherwise a line of history is followed as
+// far as it goes before the next one starts (--topo-order).
+func sortTopo(commits []*logCommit, byDate bool) []*logCommit {
+	byHash := map[string]*logCommit{}
+	for _, c := range commits {
+		byHash[c.hash] = c
+	}
+	children := map[string]int{}
+	for _, c := range commits {
+		for _, p := range c.Parents {
+			if byHash[p] != nil {
+				children[p]++
+			}
+		}
+	}
+
+	var ready []*logCommit
+	q := &commitQueue{order: map[string]int{}}
+	add := func(c *logCommit) {
+		if byDate {
+			heap.Push(q, c)
+			return
+		}
+		ready = append(ready, c)
+	}
+	var tips []*logCommit
+	for _, c := range commits {
+		if children[c.hash] == 0 {
+			tips = append(tips, c)
+		}
+	}
+	if !byDate {
+		// a stack, so the first tip has to go in last
+		slices.Reverse(tips)
+	}
+	for _, c := range tips {
+		add(c)
+	}
+
+	out := make([]*logCommit, 0, len(commits))
+	for len(ready) > 0 || q.Len() > 0 {
+		var c *logCommit
+		if byDate {
+			c = heap.Pop(q).(*logCommit)
+		} else {
+			c = ready[len(ready)-1]
+			ready = ready[:len(ready)-1]
+		}
+		out = append(out, c)
+		for _, p := range c.Parents {
+			if byHash[p] == nil {
+				continue
+			}
+			children[p]--
+			if children[p] == 0 {
+				add(byHash[p])
+			}
+		}
+	}
+	return out
+}
+
+// gitDateFormat is how log prints dates, `Thu Apr 7 22:13:13 2005 +0200`
+const gitDateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+
+// formatLogEntry prints a commit in log's default medium format
+func formatLogEntry(c *logCommit) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "commit %s\n", c.hash)
+	if len(c.Parents) > 1 {
+		short := make([]string, len(c.Parents))
+		for i, p := range c.Parents {
+			short[i] = p[:7]
+		}
+		fmt.Fprintf(&sb, "Merge: %s\n", strings.Join(short, " "))
+	}
+	fmt.Fprintf(&sb, "Author: %s <%s>\n", c.Author.Name, c.Author.Email)
+	fmt.Fprintf(&sb, "Date:   %s\n\n", c.Author.When.Format(gitDateFormat))
+	for _, line := range strings.Split(strings.TrimRight(c.Message, "\n"), "\n") {
+		fmt.Fprintf(&sb, "    %s\n", line)
+	}
+	return sb.String()
+}
+
+// log shows the history reachable from the given revisions (HEAD by default) newest first.
+// `A..B` and `^A` hide what A can reach, -n/--max-count limits the output, --oneline prints
+// `<short hash> <subject>`, --reverse flips the order and --topo-order/--date-order never
+// show a parent before its children.
+func log(runEnv string, args []string) (string, error) {
+	max, oneline, reverse, order := -1, false, false, ""
+	var include, exclude []string
+	resolve := func(rev string) (string, error) {
+		hash, err := resolveCommit(runEnv, rev)
+		if err != nil {
+			return "", fmt.Errorf("log err: %w", err)
+		}
+		return hash, nil
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var err error
+		switch {
+		case arg == "-n" || arg == "--max-count":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("log err: %s needs a number", arg)
+			}
+			i++
+			max, err = strconv.Atoi(args[i])
+		case strings.HasPrefix(arg, "--max-count="):
+			max, err = strconv.Atoi(strings.TrimPrefix(arg, "--max-count="))
+		case strings.HasPrefix(arg, "-n") && len(arg) > 2:
+			max, err = strconv.Atoi(arg[2:])
+		case len(arg) > 1 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+			max, err = strconv.Atoi(arg[1:])
+		case arg == "--oneline":
+			oneline = true
+		case arg == "--reverse":
+			reverse = true
+		case arg == "--topo-order" || arg == "--date-order":
+			order = arg
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return "", fmt.Errorf("log err: unknown option %s", arg)
+		case strings.Contains(arg, ".."):
+			from, to, _ := strings.Cut(arg, "..")
+			if strings.HasPrefix(to, ".") {
+				return "", fmt.Errorf("log err: symmetric difference %s is not supported", arg)
+			}
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			var a, b string
+			if a, err = resolve(from); err != nil {
+				return "", err
+			}
+			if b, err = resolve(to); err != nil {
+				return "", err
+			}
+			exclude, include = append(exclude, a), append(include, b)
+		case strings.HasPrefix(arg, "^"):
+			hash, err := resolve(arg[1:])
+			if err != nil {
+				return "", err
+			}
+			exclude = append(exclude, hash)
+		default:
+			hash, err := resolve(arg)
+			if err != nil {
+				return "", err
+			}
+			include = append(include, hash)
+		}
+		if err != nil {
+			return "", fmt.Errorf("log err: invalid number in %s", arg)
+		}
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		branch, head, err := headCommit(runEnv)
+		if err != nil {
+			return "", fmt.Errorf("log err: %w", err)
+		}
+		if head == "" {
+			return "", fmt.Errorf("log err: your current branch '%s' does not have any commits yet", branch)
+		}
+		include = []string{head}
+	}
+
+	w := newRevWalk(runEnv)
+	limit := max
+	if order != "" {
+		// topological order needs the whole graph before anything can be cut off
+		limit = -1
+	}
+	commits, err := w.walk(include, exclude, limit)
+	if err != nil {
+		return "", fmt.Errorf("log err: %w", err)
+	}
+	if order != "" {
+		commits = sortTopo(commits, order == "--date-order")
+		if max >= 0 && len(commits) > max {
+			commits = commits[:max]
+		}
+	}
+	if reverse {
+		slices.Reverse(commits)
+	}
+
+	var sb strings.Builder
+	for i, c := range commits {
+		if oneline {
+			fmt.Fprintf(&sb, "%s %s\n", c.hash[:7], messageSubject(c.Message))
+			continue
+		}
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(formatLogEntry(c))
+	}
+	return sb.String(), nil
+}
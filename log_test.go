@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testCommit stores a commit of the empty tree with the given parents and committer time
+func testCommit(t *testing.T, msg string, when int, parents ...string) string {
+	t.Helper()
+	var sb strings.Builder
+	sb.WriteString("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n")
+	for _, p := range parents {
+		fmt.Fprintf(&sb, "parent %s\n", p)
+	}
+	fmt.Fprintf(&sb, "author A U Thor <author@example.com> %d +0130\ncommitter C O Mitter <committer@example.com> %d +0000\n\n%s\n", when, when, msg)
+	hash, err := newObjectStore("test").Put("commit", []byte(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestLog(t *testing.T) {
+	setupWorktree(t, nil)
+	if _, err := log("test", nil); err == nil {
+		t.Fatal("expected an error on an unborn branch")
+	}
+
+	// root - m1 - m2 ------ merge
+	//          \           /
+	//           s1 ----- s2
+	root := testCommit(t, "root", 1000)
+	m1 := testCommit(t, "m1", 1010, root)
+	s1 := testCommit(t, "s1", 1030, m1)
+	s2 := testCommit(t, "s2", 1040, s1)
+	m2 := testCommit(t, "m2", 1020, m1)
+	merge := testCommit(t, "merge", 1050, m2, s2)
+	if err := updateRef("test", "HEAD", merge, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateRef("test", "refs/heads/side", s2, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	oneline := func(hashes ...string) string {
+		var sb strings.Builder
+		for _, h := range hashes {
+			c, err := newRevWalk("test").commit(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&sb, "%s %s\n", h[:7], messageSubject(c.Message))
+		}
+		return sb.String()
+	}
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := log("test", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("should follow parents newest committer date first", func(t *testing.T) {
+		if got, want := run("--oneline"), oneline(merge, s2, s1, m2, m1, root); got != want {
+			t.Fatalf("got\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("should keep parents after their children in topo order", func(t *testing.T) {
+		if got, want := run("--topo-order", "--oneline"), oneline(merge, s2, s1, m2, m1, root); got != want {
+			t.Fatalf("got\n%s\nwant\n%s", got, want)
+		}
+		if got, want := run("--oneline", "--reverse", "-n", "2"), oneline(s2, merge); got != want {
+			t.Fatalf("got\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("should leave out what the left side of a range reaches", func(t *testing.T) {
+		if got, want := run("--oneline", "side..main"), oneline(merge, m2); got != want {
+			t.Fatalf("got\n%s\nwant\n%s", got, want)
+		}
+		if got, want := run("--oneline", "^"+m2, "side"), oneline(s2, s1); got != want {
+			t.Fatalf("got\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("should print the medium format", func(t *testing.T) {
+		want := "commit " + merge + "\n" +
+			"Merge: " + m2[:7] + " " + s2[:7] + "\n" +
+			"Author: A U Thor <author@example.com>\n" +
+			"Date:   Thu Jan 1 01:47:30 1970 +0130\n\n" +
+			"    merge\n"
+		if got := run("-1"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
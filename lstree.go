@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// treeFilter is a path argument of ls-tree, contents means it ended in a slash
+type treeFilter struct {
+	path     string
+	contents bool
+}
+
+// matches reports whether p is the filtered path or below it
+func (f treeFilter) matches(p string) bool {
+	return f.path == "" || p == f.path || strings.HasPrefix(p, f.path+"/")
+}
+
+// leads reports whether the filter points somewhere inside the tree at p
+func (f treeFilter) leads(p string) bool {
+	full := f.path
+	if f.contents {
+		full += "/"
+	}
+	return strings.HasPrefix(full, p+"/")
+}
+
+// lsTree is `ls-tree [-r] [-d] [-t] [-l] [--name-only] [--abbrev[=<n>]] <tree-ish> [<path>...]` and prints `mode type sha\tpath` for every entry. trees are
+// only descended into with -r or when a path points inside them, -t shows those trees
+// too and -d shows nothing but trees.
+func lsTree(runEnv string, args []string) (string, error) {
+	recursive, showTrees, onlyTrees, long, nameOnly, abbrevLen := false, false, false, false, false, 0
+	var rest []string
+	for _, arg := range args {
+		switch {
+		case arg == "-r":
+			recursive = true
+		case arg == "-t":
+			showTrees = true
+		case arg == "-d":
+			onlyTrees = true
+		case arg == "-l" || arg == "--long":
+			long = true
+		case arg == "--name-only" || arg == "--name-status":
+			nameOnly = true
+		case arg == "--abbrev":
+			abbrevLen = 7
+		case strings.HasPrefix(arg, "--abbrev="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--abbrev="))
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("ls-tree err: invalid length in %s", arg)
+			}
+			abbrevLen = n
+		case strings.HasPrefix(arg, "-") && len(rest) == 0:
+			return "", fmt.Errorf("ls-tree err: unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) == 0 {
+		return "", errors.New("ls-tree err: usage ls-tree [<options>] <tree-ish> [<path>...]")
+	}
+	store := newObjectStore(runEnv)
+	hash, err := resolveRevision(runEnv, rest[0])
+	if err != nil {
+		return "", fmt.Errorf("ls-tree err: not a valid object name %s: %w", rest[0], err)
+	}
+	tree, err := peelObject(store, hash, "tree")
+	if err != nil {
+		return "", fmt.Errorf("ls-tree err: not a tree object %s: %w", rest[0], err)
+	}
+
+	var filters []treeFilter
+	for _, arg := range rest[1:] {
+		p, err := toRepoPath(runEnv, arg)
+		if err != nil {
+			return "", fmt.Errorf("ls-tree err: %w", err)
+		}
+		filters = append(filters, treeFilter{path: p, contents: p != "" && strings.HasSuffix(arg, "/")})
+	}
+
+	var sb strings.Builder
+	show := func(e treeEntry, p string) {
+		if !nameOnly {
+			id := e.hash
+			if abbrevLen > 0 {
+				id = abbrev(store, id, abbrevLen)
+			}
+			fmt.Fprintf(&sb, "%06o %s %s", e.mode, e.objectType(), id)
+			if long {
+				size := "-"
+				if e.objectType() == "blob" {
+					if _, n, err := store.Stat(e.hash); err == nil {
+						size = strconv.Itoa(n)
+					}
+				}
+				fmt.Fprintf(&sb, " %7s", size)
+			}
+			sb.WriteString("\t")
+		}
+		sb.WriteString(p + "\n")
+	}
+	err = walkTree(store, tree, "", func(e treeEntry, p string) (bool, error) {
+		matched, leading := len(filters) == 0, false
+		for _, f := range filters {
+			matched = matched || f.matches(p)
+			leading = leading || (e.isTree() && f.leads(p))
+		}
+		if !e.isTree() {
+			if matched && !onlyTrees {
+				show(e, p)
+			}
+			return false, nil
+		}
+		if leading || (matched && recursive) {
+			if showTrees || (onlyTrees && recursive) {
+				show(e, p)
+			}
+			return true, nil
+		}
+		if matched {
+			show(e, p)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("ls-tree err: %w", err)
+	}
+	return sb.String(), nil
+}
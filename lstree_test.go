@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLsTreeListing(t *testing.T) {
+	setupWorktree(t, map[string]string{"d/e/g.txt": "g\n", "d/f.txt": "ff\n", "top.txt": "top\n"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	if err := add("test", []string{"-A"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit("test", []string{"-m", "root"}, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	hash := func(rev string) string {
+		h, err := resolveRevision("test", rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	d, e, g, f, top := hash("HEAD:d"), hash("HEAD:d/e"), hash("HEAD:d/e/g.txt"), hash("HEAD:d/f.txt"), hash("HEAD:top.txt")
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := lsTree("test", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("should list a commit's tree with full entries", func(t *testing.T) {
+		want := "040000 tree " + d + "\td\n100644 blob " + top + "\ttop.txt\n"
+		if got := run("HEAD"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should recurse and choose what to show", func(t *testing.T) {
+		if got, want := run("-r", "--name-only", "HEAD"), "d/e/g.txt\nd/f.txt\ntop.txt\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got, want := run("-r", "-t", "--name-only", "HEAD"), "d\nd/e\nd/e/g.txt\nd/f.txt\ntop.txt\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got, want := run("-r", "-d", "--name-only", "HEAD"), "d\nd/e\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should filter by path", func(t *testing.T) {
+		if got, want := run("HEAD", "tmp/d"), "040000 tree "+d+"\td\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got, want := run("HEAD", "tmp/d/"), "040000 tree "+e+"\td/e\n100644 blob "+f+"\td/f.txt\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if got, want := run("HEAD", "tmp/d/e/g.txt"), "100644 blob "+g+"\td/e/g.txt\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should show sizes and abbreviated hashes", func(t *testing.T) {
+		want := "040000 tree " + d[:7] + "       -\td\n100644 blob " + top[:7] + "       4\ttop.txt\n"
+		if got := run("-l", "--abbrev", "HEAD"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
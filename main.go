@@ -1,22 +1,10 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"compress/zlib"
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"os"
-	"path"
-	"path/filepath"
-	"slices"
-	"sort"
-	"strconv"
 	"strings"
-	"time"
 )
 
 func main() {
@@ -45,17 +33,21 @@ func main() {
 			return
 		}
 	case "cat-file":
-		if len(args) < 3 {
-			fmt.Println("give the hash you want to read")
+		if isBatch(args[2:]) {
+			if err := catFileBatch(runEnv, args[2:], os.Stdin, os.Stdout); err != nil {
+				println(err.Error())
+			}
 			return
 		}
-		hash := args[2]
-		resp, err := catFile(hash)
+		resp, err := catFile(runEnv, args[2:])
+		if errors.Is(err, errMissingObject) {
+			os.Exit(1)
+		}
 		if err != nil {
 			println(err.Error())
 			return
 		}
-		println(resp)
+		fmt.Print(resp)
 	case "hash-object":
 		if len(args) < 3 {
 			fmt.Println("give the file you want to hash")
@@ -69,14 +61,12 @@ func main() {
 		}
 		println(hash)
 	case "log":
-		logs, err := log()
+		resp, err := log(runEnv, args[2:])
 		if err != nil {
 			println(err.Error())
 			return
 		}
-		for _, log := range logs {
-			println(log)
-		}
+		fmt.Print(resp)
 	case "ls-objects":
 		// this is not an actual git command just for practice
 		// iot will read all hash directories in .git/objects and print each one with it object type
@@ -87,343 +77,193 @@ func main() {
 		}
 		println(resp)
 	case "ls-tree":
-		if len(args) < 3 {
-			fmt.Println("give the hash you want to read")
-			return
-		}
-		hash := args[2]
-		resp, err := lsTree(hash, runEnv)
+		resp, err := lsTree(runEnv, args[2:])
 		if err != nil {
 			println(err.Error())
 			return
 		}
-		println(resp)
+		fmt.Print(resp)
 	case "write-tree":
-
-		pwd, err := os.Getwd()
-		if err != nil {
-			panic(err)
+		prefix := ""
+		for _, arg := range args[2:] {
+			if !strings.HasPrefix(arg, "--prefix=") {
+				fmt.Printf("invalid write-tree option '%s'\n", arg)
+				return
+			}
+			prefix = strings.TrimPrefix(arg, "--prefix=")
 		}
-		resp, err := writeTree(pwd)
+		resp, err := writeTree(runEnv, prefix)
 		if err != nil {
 			println(err.Error())
 			return
 		}
 		println(resp)
 	case "commit-tree":
-		pwd, err := os.Getwd()
+		hash, err := commitTree(runEnv, args[2:], os.Stdin)
 		if err != nil {
-			panic(err)
+			println(err.Error())
+			return
 		}
-		hash, err := writeTree(pwd)
+		fmt.Println(hash)
+	case "commit":
+		resp, err := commit(runEnv, args[2:], os.Stdin)
 		if err != nil {
 			println(err.Error())
 			return
 		}
-
-		if len(args) < 3 {
-			fmt.Println("give the file you want to hash")
+		fmt.Print(resp)
+	case "config":
+		resp, err := config(runEnv, args[2:])
+		if err != nil {
+			println(err.Error())
 			return
 		}
-		msg := args[2]
-		commitHash, err := commitTree(hash, msg)
+		fmt.Print(resp)
+	case "rev-parse":
+		resp, err := revParse(runEnv, args[2:])
 		if err != nil {
 			println(err.Error())
 			return
 		}
-		println(commitHash)
-	default:
-		fmt.Printf("invalid command '%s' use help for list of commands\n", command)
-	}
-
-}
-func commitTree(treeHash, msg string) (string, error) {
-	raw := fmt.Sprintf("tree %s\n"+
-		"parent %s\n"+
-		"author teyyubismayil <tismayilov@pro.simbrella.com> 1661410769 +0400\n"+
-		"teyyubismayil <tismayilov@pro.simbrella.com> 1661410769 +0400\n\n"+
-		"%s\n", treeHash, "master", msg) // master for now
-
-	header := fmt.Sprintf("blob %d\x00", len(raw))
-	full := append([]byte(header), raw...)
-	hasher := sha1.New()
-	if _, err := hasher.Write(full); err != nil {
-		return "", fmt.Errorf("failed to hash header: %s",  err.Error())
-
-	}
-	hash := fmt.Sprintf("%x", hasher.Sum(nil))
-
-	targetDir := fmt.Sprintf(".git/objects/%s", hash[:2])
-	run_env := os.Getenv("run_env")
-	if run_env == "test" {
-		targetDir = fmt.Sprintf("tmp/.git/objects/%s", hash[:2])
-	}
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create %s dir: %s", targetDir, err.Error())
-
-	}
-	out, err := os.OpenFile(fmt.Sprintf("%s/%s", targetDir, hash[2:]), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
-	if err != nil {
-		return "", fmt.Errorf("failed to create %s file: %s", fmt.Sprintf("%s/%s", targetDir, hash[2:]), err.Error())
-
-	}
-	defer out.Close()
-	writer := zlib.NewWriter(out)
-	if _, err := writer.Write(full); err != nil {
-		return "", fmt.Errorf("failed to write compressed data: %s", err.Error())
-
-	}
-	defer writer.Close()
-	return hash , nil
-}
-func writeTree(dirPath string) (string, error) {
-	type entry struct {
-		name string
-		data []byte
-	}
-
-	hexToRaw := func(h string) ([]byte, error) {
-		b, err := hex.DecodeString(h)
+		fmt.Print(resp)
+	case "branch":
+		resp, err := branch(runEnv, args[2:])
+		fmt.Print(resp)
 		if err != nil {
-			return nil, err
+			println(err.Error())
+			return
 		}
-		if len(b) != 20 {
-			return nil, fmt.Errorf("sha length != 20 for %s", h)
+	case "checkout":
+		resp, err := checkout(runEnv, args[2:])
+		if err != nil {
+			println(err.Error())
+			return
 		}
-		return b, nil
-	}
-
-	writeObject := func(kind string, data []byte) (string, error) {
-		header := fmt.Sprintf("%s %d\x00", kind, len(data))
-		store := append([]byte(header), data...)
-		sha := sha1.Sum(store)
-		shaHex := hex.EncodeToString(sha[:])
-
-		objDir := filepath.Join(".git", "objects", shaHex[:2])
-		objPath := filepath.Join(objDir, shaHex[2:])
-
-		if _, err := os.Stat(objPath); err == nil {
-			return shaHex, nil
+		fmt.Print(resp)
+	case "switch":
+		resp, err := switchBranch(runEnv, args[2:])
+		if err != nil {
+			println(err.Error())
+			return
 		}
-
-		if err := os.MkdirAll(objDir, 0o755); err != nil {
-			return "", fmt.Errorf("mkdir: %w", err)
+		fmt.Print(resp)
+	case "restore":
+		if err := restore(runEnv, args[2:]); err != nil {
+			println(err.Error())
+			return
 		}
-
-		f, err := os.Create(objPath)
+	case "diff":
+		resp, err := diff(runEnv, args[2:])
 		if err != nil {
-			return "", fmt.Errorf("create: %w", err)
-		}
-		defer f.Close()
-
-		zw := zlib.NewWriter(f)
-		if _, err := zw.Write(store); err != nil {
-			return "", fmt.Errorf("zlib write: %w", err)
+			println(err.Error())
+			return
 		}
-		if err := zw.Close(); err != nil {
-			return "", fmt.Errorf("zlib close: %w", err)
+		fmt.Print(resp)
+	case "diff-tree":
+		resp, err := diffTree(runEnv, args[2:])
+		if err != nil {
+			println(err.Error())
+			return
 		}
-
-		return shaHex, nil
-	}
-
-	entries, err := os.ReadDir(dirPath)
-	if err != nil {
-		return "", fmt.Errorf("read dir %s: %w", dirPath, err)
-	}
-
-	ignorePath := filepath.Join(dirPath, ".gitignore")
-	ignores := map[string]bool{}
-	if data, err := os.ReadFile(ignorePath); err == nil {
-		for _, line := range strings.Split(string(data), "\n") {
-			line = strings.TrimSpace(line)
-			if line != "" && !strings.HasPrefix(line, "#") {
-				ignores[line] = true
-			}
+		fmt.Print(resp)
+	case "update-ref":
+		if err := updateRefCmd(runEnv, args[2:]); err != nil {
+			println(err.Error())
+			return
 		}
-	}
-
-	var treeEntries []entry
-
-	for _, e := range entries {
-		name := e.Name()
-		if name == ".git" {
-			continue
+	case "symbolic-ref":
+		resp, err := symbolicRef(runEnv, args[2:])
+		if err != nil {
+			println(err.Error())
+			return
 		}
-		if ignores[name] {
-			continue
+		fmt.Print(resp)
+	case "add":
+		if err := add(runEnv, args[2:]); err != nil {
+			println(err.Error())
+			return
 		}
-
-		full := filepath.Join(dirPath, name)
-
-		if e.IsDir() {
-			childHash, err := writeTree(full)
-			if err != nil {
-				return "", fmt.Errorf("subtree %s: %w", full, err)
-			}
-			raw, err := hexToRaw(childHash)
-			if err != nil {
-				return "", err
-			}
-			prefix := []byte(fmt.Sprintf("40000 %s\x00", name))
-			treeEntries = append(treeEntries, entry{name, append(prefix, raw...)})
-			continue
+	case "rm":
+		resp, err := rm(runEnv, args[2:])
+		if err != nil {
+			println(err.Error())
+			return
 		}
-
-		data, err := os.ReadFile(full)
+		fmt.Print(resp)
+	case "status":
+		resp, err := status(runEnv, args[2:])
 		if err != nil {
-			return "", fmt.Errorf("read file %s: %w", full, err)
+			println(err.Error())
+			return
 		}
-
-		blobHash, err := writeObject("blob", data)
+		fmt.Print(resp)
+	case "ls-files":
+		resp, err := lsFiles(runEnv, args[2:])
 		if err != nil {
-			return "", fmt.Errorf("write blob %s: %w", full, err)
+			println(err.Error())
+			return
 		}
-
-		raw, err := hexToRaw(blobHash)
+		fmt.Print(resp)
+	case "repack":
+		resp, err := repack(runEnv, args[2:])
 		if err != nil {
-			return "", err
+			println(err.Error())
+			return
 		}
-
-		prefix := []byte(fmt.Sprintf("100644 %s\x00", name))
-		treeEntries = append(treeEntries, entry{name, append(prefix, raw...)})
-	}
-
-	sort.Slice(treeEntries, func(i, j int) bool {
-		return treeEntries[i].name < treeEntries[j].name
-	})
-
-	var content []byte
-	for _, te := range treeEntries {
-		content = append(content, te.data...)
-	}
-
-	treeHash, err := writeObject("tree", content)
-	if err != nil {
-		return "", fmt.Errorf("write tree: %w", err)
+		println(resp)
+	default:
+		fmt.Printf("invalid command '%s' use help for list of commands\n", command)
 	}
 
-	return treeHash, nil
 }
 
-func lsTree(hash, runEnv string) (string, error) {
-	objDir := ".git/objects"
-	if runEnv == "test" {
-		objDir = "tmp/.git/objects"
-	}
-	filePath := path.Join(objDir, hash[:2], hash[2:])
-	file, err := os.OpenFile(filePath, os.O_RDONLY, 0755)
+// writeTree writes the tree objects for what is staged in the index, with a prefix
+// (like "src/") only the subtree for that directory is written
+func writeTree(runEnv, prefix string) (string, error) {
+	idx, err := readIndex(runEnv)
 	if err != nil {
-		return "", fmt.Errorf("failed to open %s: %s", filePath, err.Error())
+		return "", err
 	}
-	defer file.Close()
-
-	reader, err := zlib.NewReader(file)
-	if err != nil {
-		return "", fmt.Errorf("failed to read refPath: %s", err.Error())
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
 	}
-	defer reader.Close()
-
-	buf := bufio.NewReader(reader)
 
-	header, err := buf.ReadBytes(0x00)
-	if err != nil {
-		return "", fmt.Errorf("failed to read null byte: %s", err.Error())
-	}
-
-	header = header[:len(header)-1]
-	parts := bytes.SplitN(header, []byte(" "), 2)
-	objSize, _ := strconv.Atoi(string(parts[1]))
-	payload := make([]byte, objSize)
-	_, err = io.ReadFull(buf, payload)
-	if err != nil {
-		return "", fmt.Errorf("failed to read buf into payload: %s", err.Error())
-	}
-	i := 0
-	resp := ""
-	for i < len(payload) {
-		j := bytes.IndexByte(payload[i:], ' ')
-		if j < 0 {
-			return "", fmt.Errorf("invalid tree: missing space for mode")
-		}
-		mode := string(payload[i : i+j])
-		i += j + 1
-
-		k := bytes.IndexByte(payload[i:], 0x00)
-		if k < 0 {
-			return "", fmt.Errorf("invalid tree: missing null after filename")
+	var entries []*IndexEntry
+	for _, e := range idx.Entries {
+		if e.stage() != 0 {
+			return "", fmt.Errorf("%s: unmerged (%s)", e.Path, e.Hash)
 		}
-		filename := string(payload[i : i+k])
-		i += k + 1
-
-		if i+20 > len(payload) {
-			return "", fmt.Errorf("invalid tree: truncated sha1")
-		}
-		i += 20
-
-		if mode == "40000" {
-			filename = filename + "/"
+		// intent-to-add entries have no content yet so they stay out of trees
+		if e.ExtFlags&indexIntentToAdd != 0 || !strings.HasPrefix(e.Path, prefix) {
+			continue
 		}
-		resp = resp + strings.Trim(filename, " ") + "\n"
+		entries = append(entries, e)
+	}
+	if prefix != "" && len(entries) == 0 {
+		return "", fmt.Errorf("prefix %s not found", prefix)
 	}
 
-	return resp, nil
+	treeHash, err := writeIndexTree(newObjectStore(runEnv), entries, prefix)
+	if err != nil {
+		return "", fmt.Errorf("write tree: %w", err)
+	}
+	return treeHash, nil
 }
 
 func listObjects(run_env string) (string, error) {
-	targetDir := ".git/objects"
-	if run_env == "test" {
-		targetDir = "tmp/.git/objects"
-	}
-	entries, err := os.ReadDir(targetDir)
+	store := newObjectStore(run_env)
+	hashes, err := store.List()
 	if err != nil {
-		return "", fmt.Errorf("failed to read %s dir entries: %s", targetDir, err.Error())
-	}
-
-	notSearch := []string{
-		"info",
-		"pack",
+		return "", err
 	}
 	resp := ""
-	for _, entry := range entries {
-		if slices.Contains(notSearch, entry.Name()) {
-			continue
-		}
-		if entry.IsDir() {
-			subEntries, err := os.ReadDir(path.Join(targetDir, entry.Name()))
-			if err != nil {
-				return "", fmt.Errorf("failed to read sub entry content for %s dir: %s", entry.Name(), err.Error())
-			}
-			for _, subEntry := range subEntries {
-
-				refPath := fmt.Sprintf("%s/%s/%s", targetDir, entry.Name(), subEntry.Name())
-				file, err := os.OpenFile(refPath, os.O_RDONLY, 0755)
-				if err != nil {
-					return "", fmt.Errorf("failed to open file: %s", err.Error())
-				}
-				defer file.Close()
-
-				reader, err := zlib.NewReader(file)
-				if err != nil {
-					return "", fmt.Errorf("failed to read refPath: %s", err.Error())
-				}
-				defer reader.Close()
-
-				buf := bufio.NewReader(reader)
-
-				header, err := buf.ReadBytes(0x00)
-				if err != nil {
-					return "", fmt.Errorf("failed to read null byte: %s", err.Error())
-				}
-
-				header = header[:len(header)-1]
-
-				parts := bytes.SplitN(header, []byte(" "), 2)
-				objType := string(parts[0])
-				resp = resp + "\n" + fmt.Sprintf("%s - %s", objType, entry.Name()+subEntry.Name())
-			}
+	for _, hash := range hashes {
+		objType, _, err := store.Stat(hash)
+		if err != nil {
+			return "", err
 		}
-
+		resp = resp + "\n" + fmt.Sprintf("%s - %s", objType, hash)
 	}
 	return resp, nil
 }
@@ -434,260 +274,13 @@ func hashObject(file string) (string, error) {
 		return "", fmt.Errorf("failed to read %s: %s", file, err.Error())
 
 	}
-	header := fmt.Sprintf("blob %d\x00", len(raw))
-	full := append([]byte(header), raw...)
-	hasher := sha1.New()
-	if _, err = hasher.Write(full); err != nil {
-		return "", fmt.Errorf("failed to hash header %s: %s", file, err.Error())
-
-	}
-	hash := fmt.Sprintf("%x", hasher.Sum(nil))
-
-	targetDir := fmt.Sprintf(".git/objects/%s", hash[:2])
-	run_env := os.Getenv("run_env")
-	if run_env == "test" {
-		targetDir = fmt.Sprintf("tmp/.git/objects/%s", hash[:2])
-	}
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create %s dir: %s", targetDir, err.Error())
-
-	}
-	out, err := os.OpenFile(fmt.Sprintf("%s/%s", targetDir, hash[2:]), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
+	hash, err := newObjectStore(os.Getenv("run_env")).Put("blob", raw)
 	if err != nil {
-		return "", fmt.Errorf("failed to create %s file: %s", fmt.Sprintf("%s/%s", targetDir, hash[2:]), err.Error())
-
+		return "", fmt.Errorf("failed to store %s: %w", file, err)
 	}
-	defer out.Close()
-	writer := zlib.NewWriter(out)
-	if _, err := writer.Write(full); err != nil {
-		return "", fmt.Errorf("failed to write compressed data: %s", err.Error())
-
-	}
-	defer writer.Close()
-
 	return hash, nil
 }
 
-// NOTE: only commit and tree messages are handled
-func log() ([]string, error) {
-	run_env := os.Getenv("run_env")
-
-	searchDir := ".git/objects"
-	if run_env == "test" {
-		searchDir = "tmp/.git/objects"
-	}
-
-	notSearch := []string{
-		"info",
-		"pack",
-	}
-
-	stat, err := os.Stat(searchDir)
-	if err != nil {
-		return nil, fmt.Errorf("log err: the %s dir does not exist", searchDir)
-
-	}
-	if !stat.IsDir() {
-		return nil, fmt.Errorf("log err: the %s is not a dir", searchDir)
-
-	}
-
-	entries, err := os.ReadDir(searchDir)
-	if err != nil {
-		return nil, fmt.Errorf("log err: failed to read %s entries", searchDir)
-
-	}
-
-	refFiles := make([]string, 0)
-
-	for _, entry := range entries {
-		if slices.Contains(notSearch, entry.Name()) {
-			continue
-		}
-
-		if !entry.IsDir() || len(entry.Name()) != 2 {
-			continue
-		}
-
-		subDir := filepath.Join(searchDir, entry.Name())
-		subEntries, err := os.ReadDir(subDir)
-		if err != nil {
-			return nil, fmt.Errorf("log err: failed to read %s entries: %s", entry.Name(), err.Error())
-
-		}
-
-		for _, subEntry := range subEntries {
-			if subEntry.IsDir() {
-				continue
-			}
-
-			sha := entry.Name() + subEntry.Name()
-			if len(sha) != 40 {
-				continue
-			}
-
-			refFiles = append(refFiles, sha)
-		}
-	}
-	responses := make([]string, 0, len(refFiles))
-	for _, ref := range refFiles {
-		path := filepath.Join(searchDir, ref[:2], ref[2:])
-
-		objType, authorName, authorEmail, timestamp, msg, err :=
-			readCommitRef(path)
-
-		if err != nil {
-			continue
-		}
-
-		responses = append(responses, fmt.Sprintf("%s %s\nAuthor: %s %s\nDate: %s\n\n%s\n\n",
-			objType, ref, authorName, authorEmail, timestamp, msg))
-	}
-	return responses, nil
-}
-
-func readCommitRef(refPath string) (string, string, string, string, string, error) {
-	file, err := os.OpenFile(refPath, os.O_RDONLY, 0755)
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to open file: %s", err.Error())
-	}
-	defer file.Close()
-
-	reader, err := zlib.NewReader(file)
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to read refPath: %s", err.Error())
-	}
-	defer reader.Close()
-
-	buf := bufio.NewReader(reader)
-
-	header, err := buf.ReadBytes(0x00)
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to read null byte: %s", err.Error())
-	}
-
-	header = header[:len(header)-1]
-
-	parts := bytes.SplitN(header, []byte(" "), 2)
-	objType := string(parts[0])
-	allowedTypes := []string{
-		"commit",
-		"tree",
-	}
-	if !slices.Contains(allowedTypes, objType) {
-		return "", "", "", "", "", errors.New("none commit ref")
-	}
-
-	objSize, _ := strconv.Atoi(string(parts[1]))
-
-	payload := make([]byte, objSize)
-	_, err = io.ReadFull(buf, payload)
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to read buf into payload: %s", err.Error())
-	}
-
-	payloadStr := string(payload)
-	lines := strings.Split(payloadStr, "\n")
-
-	var authorName, authorEmail, timestamp string
-	var msg string
-
-	for i := range lines {
-		line := lines[i]
-
-		if strings.HasPrefix(line, "author ") {
-			fields := strings.Fields(line)
-			authorName = fields[1]
-			authorEmail = strings.Trim(fields[2], "<>")
-			timestamp = fields[3]
-			continue
-		}
-
-		if line == "" && i < len(lines)-1 {
-			msg = strings.Join(lines[i+1:], "\n")
-			break
-		}
-	}
-
-	tsInt, err := strconv.Atoi(timestamp)
-	if err != nil {
-		return "", "", "", "", "", fmt.Errorf("failed to parse timestamp of %s: %s", timestamp, err.Error())
-	}
-
-	t := time.Unix(int64(tsInt), 0).Local().String()
-	return objType, authorName, authorEmail, t, msg, nil
-}
-
-func catFile(inp string) (string, error) {
-	run_env := os.Getenv("run_env")
-
-	searchDir := ".git/objects"
-	if run_env == "test" {
-		searchDir = "tmp/.git/objects"
-	}
-
-	objDir := fmt.Sprintf("%s/%s", searchDir, inp[:2])
-
-	stat, err := os.Stat(objDir)
-	if err != nil {
-		return "", fmt.Errorf("cat-file err: the %s reference dir does not exits in %s at %s", inp, searchDir, objDir)
-	}
-	if !stat.IsDir() {
-		return "", fmt.Errorf("cat-file err: the reference is not a dir")
-	}
-	entries, err := os.ReadDir(objDir)
-	if err != nil {
-		return "", fmt.Errorf("cat-file err: failed to read %s entries: %s", objDir, err.Error())
-	}
-	found := ""
-	for _, entry := range entries {
-		entry.Name()
-		if strings.HasPrefix(entry.Name(), inp[2:]) {
-			found = entry.Name()
-			break
-		}
-	}
-	if found == "" {
-		return "", fmt.Errorf("cat-file err: failed to find any reference with prefix of : %s", inp[2:])
-
-	}
-	rp := fmt.Sprintf("%s/%s/%s", searchDir, inp[:2], found)
-
-	_, err = os.Stat(rp)
-	if err != nil {
-		return "", fmt.Errorf("cat-file err: the %s reference does not exits in %s at %s", inp, searchDir, rp)
-
-	}
-	refFile, err := os.OpenFile(rp, os.O_RDONLY, 0755)
-	if err != nil {
-		return "", fmt.Errorf("cat-file err: failed to open %s file: %s", rp, err.Error())
-
-	}
-	reader, err := zlib.NewReader(refFile)
-	if err != nil {
-		return "", fmt.Errorf("cat-file err: failed to create reader for %s file in zlib: %s", rp, err.Error())
-
-	}
-	defer reader.Close()
-	buf := bufio.NewReader(reader)
-	header, err := buf.ReadBytes(0x00) // read until null byte
-	if err != nil {
-		panic(err)
-	}
-	header = header[:len(header)-1] // remove last part which is null byte
-	parts := bytes.SplitN(header, []byte(" "), 2)
-	objType := string(parts[0])
-	objSize, _ := strconv.Atoi(string(parts[1]))
-	payload := make([]byte, objSize)
-	_, err = io.ReadFull(buf, payload)
-	if err != nil {
-		return "", fmt.Errorf("cat-file err: failed to read payload of %s object type: %s", err.Error(), objType)
-
-	}
-	return fmt.Sprintf("%s payload is:\n%s%s%s", rp, Blue, string(payload), Reset), nil // TODO: make the outcome look better
-
-}
-
 func initialize(runEnv string) error {
 	initDir := ".git"
 	if runEnv == "test" {
@@ -723,33 +316,84 @@ func help(subCmd string) (string, error) {
 			return `
 				init: initialized .git directory along:
 					-  .git/objects => for storing hash files
-					-  .git/refs => for storing refs(branches) (wont implement this one in clone)
+					-  .git/refs => for storing refs(branches), packed ones live in .git/packed-refs
 					- .git/HEAD => the file for storing the head commit of current branch
 			`, nil
 		case "hash-object":
 			return "hash-object <file>: creates a hash of file using its size and blob content then store that hash in .git/objects/{fist two char of hash}/{from second character to end of hash} and then write the compressed content by suing zlib to the hash file", nil
 		case "cat-file":
-			return "cat-file <hash>: reads the changes of a hash and prints the changes content", nil
+			return "cat-file (-t|-s|-e|-p) <object> | cat-file <type> <object>: reads an object from .git/objects. -t prints its type, -s its size, -e prints nothing and exits with 1 when the object is missing, -p pretty prints it (trees as `mode type sha\\tname` lines) and <type> prints the raw content after peeling tags (and commits for tree) to that type. cat-file (--batch[=<format>]|--batch-check[=<format>]) [--batch-all-objects] [--buffer] reads object names from stdin, one per line, and prints `<sha> <type> <size>` for each (--batch adds the content and a newline) or `<name> missing`. formats take %(objectname), %(objecttype), %(objectsize) and %(rest), --batch-all-objects lists every object instead of reading stdin and --buffer flushes only at the end", nil
 		case "log":
-			return "log: prints commits and tree hashes. and shows its author, date of commit and the commit message", nil
+			return "log [-n <count>] [--oneline] [--reverse] [--topo-order|--date-order] [<revision>|^<revision>|<a>..<b>]...: walks the history from HEAD (or the given revisions) through the parents of each commit, newest committer date first, and prints hash, author, date and message. ^A and A..B leave out what A can reach, --topo-order and --date-order never show a parent before its children", nil
 		case "ls-objects":
 			return "ls-objects: use for list the objects stored ar .git/objects with their type", nil
 		case "ls-tree":
-			return "ls-tree: give a hash and see list of files in that tree", nil
+			return "ls-tree [-r] [-d] [-t] [-l] [--name-only] [--abbrev[=<n>]] <tree-ish> [<path>...]: lists a tree (a commit or any revision is peeled to its tree) as `mode type sha\\tpath` lines. -r recurses into subtrees, -t also shows the trees it recursed into, -d shows only trees, -l adds blob sizes, --name-only prints just the paths and --abbrev shortens the hashes. paths limit the listing, `dir/` lists what is inside dir", nil
 		case "write-tree":
-			return "write-tree => creates a tree object from the current state of the staging area", nil
+			return "write-tree [--prefix=<dir>/] => creates the tree objects for what is staged in .git/index and prints the top tree hash, --prefix writes only the subtree of that directory", nil
+		case "add":
+			return "add [-f] [-A] <pathspec>...: hashes the matching files into .git/objects and stages them in .git/index, tracked files missing from disk are unstaged. ignored files need -f, -A stages the whole work tree", nil
+		case "rm":
+			return "rm [--cached] [-r] [-f] [-q] <pathspec>...: removes the matching files from the index and from disk, --cached keeps them on disk. directories need -r and files with unstaged changes need -f", nil
+		case "status":
+			return "status [-s|--porcelain[=v1|v2]] [-b] [-u<mode>]: shows staged changes (HEAD vs index), unstaged changes (index vs work tree) and untracked files. --porcelain prints `XY path` lines, --porcelain=v2 the detailed format with modes and hashes, -b adds the branch header and -u/--untracked-files=no|normal|all controls how untracked files are listed", nil
+		case "commit-tree":
+			return "commit-tree <tree> [-p <parent>]... [-m <message>]... [-F <file>]...: writes a commit object for the tree and prints its hash. -p is repeatable for merges, every -m/-F adds a paragraph and without them the message is read from stdin. author and committer come from GIT_AUTHOR_NAME/EMAIL/DATE and GIT_COMMITTER_NAME/EMAIL/DATE or user.name and user.email in the config", nil
+		case "commit":
+			return "commit [-m <message>]... [-F <file>] [--amend] [--allow-empty] [-q]: records what is staged in .git/index as a new commit whose parent is HEAD and moves the current branch to it, logging the update in .git/logs. --amend replaces the HEAD commit keeping its author and, without -m/-F, its message. a commit that changes nothing needs --allow-empty", nil
+		case "config":
+			return "config [--system|--global|--local|--file <file>] [--type=bool|int] (<key> [<value>] | --get <key> | --get-all <key> [<pattern>] | --add <key> <value> | --replace-all <key> <value> [<pattern>] | --unset <key> [<pattern>] | --unset-all <key> [<pattern>] | --list): reads and writes git's config files. reads see /etc/gitconfig, ~/.gitconfig and .git/config merged (later wins, include.path is followed) unless a scope is given, writes go to .git/config by default. get, set, unset and list also work as subcommands", nil
+		case "rev-parse":
+			return "rev-parse [--verify] [--short[=<n>]] [--abbrev-ref|--symbolic-full-name] <revision>...: prints the object hash each revision names. a revision is a full or unique abbreviated hash, a ref like main or v1 (@ is HEAD), <ref>@{n} for the value n updates ago in its reflog, @{-n} for the nth previous checkout, <rev>~n for the nth first parent, <rev>^n for the nth parent, <rev>^{tree|commit|blob|tag|object} to peel it, <rev>:<path> for a file or tree inside it and :[<stage>:]<path> for what the index holds. A..B prints B and ^A, --short abbreviates and --abbrev-ref/--symbolic-full-name print the ref name instead", nil
+		case "branch":
+			return "branch [--list] [-v] [<pattern>...] | branch [-f] <name> [<start>] | branch (-m|-M) [<old>] <new> | branch (-d|-D) <name>... | branch --show-current: lists the branches under .git/refs/heads marking the current one with *, -v adds the short hash and subject of each tip. with a name it creates a branch at HEAD or at the start revision (-f moves an existing one), -m renames a branch together with its reflog (-M replaces an existing target) and -d deletes branches whose tip HEAD already contains, -D deletes them anyway", nil
+		case "checkout":
+			return "checkout [-f] [-q] <branch> | checkout [-f] [-q] [--detach] <commit> | checkout (-b|-B) <new> [<start>]: switches to a branch, or detaches HEAD at any other commit, writing the files of its tree to the work tree and the index. paths that differ between the two commits are only touched when they have no local changes (and untracked files are never overwritten) unless -f throws local changes away, changes to other paths are carried over. -b creates the branch at start (HEAD by default) first, -B resets it if it exists, `-` is the previous branch. HEAD's reflog gets `checkout: moving from <old> to <new>`", nil
+		case "switch":
+			return "switch [-f] [-q] <branch> | switch (-c|-C) <new> [<start>] | switch --detach [<commit>]: like checkout but only for branches, moving to a bare commit needs --detach. -c creates the branch first, -C resets an existing one and -f throws local changes away", nil
+		case "restore":
+			return "restore [-s|--source=<rev>] [-S|--staged] [-W|--worktree] [--] <pathspec>...: writes the selected files from the source to the work tree (the default) and/or the staging area without moving HEAD. the source is the staging area when only the work tree is restored and HEAD otherwise, so `restore --staged <path>` unstages and `restore --source=HEAD~2 <path>` brings back an older version. tracked files the source does not have are removed from the restored places", nil
+		case "diff":
+			return "diff [-U<n>] [--diff-algorithm=<algorithm>] [--] [<path>...] | diff --cached [<commit>] | diff <commit> [<commit>] | diff A..B: prints the changes as unified patches, by default from the staging area to the work tree. --cached (or --staged) compares HEAD or commit with the staging area, one commit is compared with the work tree and two commits with each other. -U sets the lines of context around each change (3 by default), changes close enough share a hunk and hunk headers carry the nearest line above that starts a function. files with a NUL byte in their first 8000 bytes are reported as `Binary files a/<path> and b/<path> differ`. --diff-algorithm=<myers|minimal|patience|histogram> (or --minimal, --patience, --histogram) picks how lines are matched, patience and histogram anchor on rare lines so moved functions read better. diff.algorithm sets the default", nil
+		case "diff-tree":
+			return "diff-tree [-r] [-M[<n>]] [-C[<n>]] [--find-copies-harder] <tree-ish> <tree-ish> [--] [<path>...]: compares two trees entry by entry and prints `:mode mode sha sha status\\tpath` for every difference, the status being A, D, M or T. without -r only the top level entries are compared and a changed directory is one line. -M pairs deleted and created files as renames (R<similarity>\\told\\tnew) when they are at least n similar, n reads as a fraction so -M5 and -M50% are both 50% (the default). -C also finds copies (C<similarity>) of changed files, given twice or with --find-copies-harder of every file", nil
+		case "update-ref":
+			return "update-ref [-m <reason>] <ref> <new> [<old>] | update-ref -d <ref> [<old>]: points a ref (HEAD moves the branch it is on) at a new object under .git/refs, or deletes it from the loose and packed refs. with <old> the ref is only changed while it still holds that value, the zero id meaning it must not exist. branch and HEAD updates are logged under .git/logs", nil
+		case "symbolic-ref":
+			return "symbolic-ref [--short] <name> | symbolic-ref [-m <reason>] <name> <ref>: prints the ref a symbolic ref like HEAD points at, or points it at another ref", nil
+		case "ls-files":
+			return "ls-files [-s|--stage]: lists the paths staged in .git/index, -s also shows mode, hash and stage of each entry", nil
+		case "repack":
+			return "repack [-a] [-d] [--window=<n>] [--depth=<n>]: packs loose objects into .git/objects/pack/pack-{checksum}.pack with a v2 .idx next to it. -a packs every object including existing packs, -d removes the loose objects and packs that became redundant. similar objects are stored as deltas of one of the previous --window objects (default 10) with chains no longer than --depth (default 50), --window=0 turns deltas off", nil
 		default:
 			return "", fmt.Errorf("invalid sub command '%s' use 'help' for list of possible commands", subCmd)
 		}
 	} else {
 		return `
 			init => initialize required files and directories. 
-			cat-file => read a ref compressed hash file into actual content.
+			cat-file => prints the type, size or content of an object.
 			hash-object => read a ref compressed hash file into actual content.
-			log => shows list commits.
+			log => shows the commit history of HEAD.
 			ls-objects => *NOT AN OFFICIAL COMMAND* use for list the objects stored ar .git/objects with their type
-			ls-tree => give a hash and see list of files in that tree
-			write-tree => creates a tree object from the current state of the staging area(current dir)
+			ls-tree => lists the entries of a tree, commit or other revision
+			write-tree => creates a tree object from the current state of the staging area(.git/index)
+			commit => records the staged changes as a new commit on the current branch
+			commit-tree => creates a commit object for a tree
+			config => gets, sets, unsets and lists config values
+			rev-parse => turns revisions like HEAD~2 or main:src into object hashes
+			branch => lists, creates, renames and deletes branches
+			checkout => switches branches or detaches HEAD, updating the work tree
+			switch => switches branches, updating the work tree
+			restore => restores files in the work tree or the staging area from the index or a revision
+			update-ref => moves or deletes a ref
+			symbolic-ref => reads or changes where HEAD points
+			add => stages files in the staging area(.git/index)
+			rm => removes files from the staging area and the work tree
+			status => shows staged, unstaged and untracked changes
+			diff => shows changes between the work tree, the staging area and commits as patches
+			diff-tree => compares two trees and lists the changed entries, with rename and copy detection
+			ls-files => lists the paths in the staging area(.git/index)
+			repack => packs loose objects into a single packfile
 		`, nil
 	}
 }
@@ -73,12 +73,14 @@ func TestCatFile(t *testing.T) {
 			t.Fatalf("hash object error: %s", err.Error())
 		}
 
-		file, err := catFile(hash)
-		fmt.Printf("file: %v\n", file)
-
+		file, err := catFile("test", []string{"-p", hash[:7]})
 		if err != nil {
 			t.Fatalf("cat file error: %s", err.Error())
 		}
+		raw, _ := os.ReadFile("main.go")
+		if file != string(raw) {
+			t.Fatal("cat-file -p did not print the blob content")
+		}
 		t.Cleanup(func() {
 			if err := os.RemoveAll("tmp/"); err != nil {
 				t.Fatalf("failed to cleanup tmp dir: %s", err.Error())
@@ -91,7 +93,7 @@ func TestCatFile(t *testing.T) {
 		initialize("test")
 		keyname := "abcdefg"
 
-		_, err := catFile(keyname)
+		_, err := catFile("test", []string{"-p", keyname})
 		if !strings.ContainsAny(err.Error(), "cat-file err: the abcdefg reference dir does not exits in tmp/.git/objects at tmp/.git/objects/ab") {
 			t.Fatal("wrong error message")
 		}
@@ -110,7 +112,7 @@ func TestCatFile(t *testing.T) {
 			t.Fatalf("failed to create the dir with given prefix: %s", err.Error())
 		}
 
-		_, err := catFile(keyname)
+		_, err := catFile("test", []string{"-p", keyname})
 
 		if !strings.ContainsAny(err.Error(), "cat-file err: failed to find any reference with prefix of : cdefg") {
 			t.Fatal("stderr is not empty")
@@ -150,7 +152,7 @@ func TestHashObject(t *testing.T) {
 			t.Fatalf("failed to read %s: %s", hashPath, err.Error())
 		}
 
-		catFile(hash)
+		catFile("test", []string{"-p", hash})
 
 		t.Cleanup(func() {
 			if err := os.RemoveAll("tmp/"); err != nil {
@@ -231,19 +233,20 @@ func TestLsTree(t *testing.T) {
 	}
 	zw.Close()
 
-	gitObjectHash := "ab" + "cdef01"
-	filePath := "tmp/.git/objects/ab/cdef01"
+	gitObjectHash := "ab" + "cdef01" + strings.Repeat("0", 32)
+	filePath := "tmp/.git/objects/ab/" + gitObjectHash[2:]
 
 	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
 		t.Fatalf("write failed: %v", err)
 	}
 
-	out, err := lsTree(gitObjectHash, "test")
+	out, err := lsTree("test", []string{gitObjectHash})
 	if err != nil {
 		t.Fatalf("lsTree failed: %v", err)
 	}
 
-	expected := "src/\nmain.go\n"
+	expected := "040000 tree " + strings.Repeat("11", 20) + "\tsrc\n" +
+		"100644 blob " + strings.Repeat("22", 20) + "\tmain.go\n"
 
 	if out != expected {
 		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", out, expected)
@@ -256,9 +259,7 @@ func TestWriteTree(t *testing.T) {
 	}
 	defer os.RemoveAll(tmp)
 
-	if err := os.Chdir(tmp); err != nil {
-		t.Fatal(err)
-	}
+	t.Chdir(tmp)
 
 	if err := os.MkdirAll(".git/objects", 0o755); err != nil {
 		t.Fatal(err)
@@ -274,7 +275,13 @@ func TestWriteTree(t *testing.T) {
 	if err := os.WriteFile("dir/b.txt", []byte("world"), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	hash, err := writeTree(".")
+	if err := os.WriteFile("unstaged.txt", []byte("not in the index"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("", []string{"a.txt", "dir"}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	hash, err := writeTree("", "")
 	if err != nil {
 		t.Fatalf("writeTree failed: %v", err)
 	}
@@ -308,12 +315,18 @@ func TestWriteTree(t *testing.T) {
 		t.Fatalf("blob for dir/b.txt not created: %s", bBlobPath)
 	}
 
-	data, err := os.ReadFile(objPath)
+	// same ids `git write-tree` gives for this index
+	if hash != "d5f0fc3dfb21ae6650f380a7b101c390f29940e4" {
+		t.Fatalf("unexpected tree hash %s", hash)
+	}
+	prefixed, err := writeTree("", "dir/")
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("writeTree with prefix failed: %v", err)
 	}
-
-	if len(data) == 0 {
-		t.Fatalf("tree object empty or corrupted")
+	if prefixed != "0980762b58316262116e0b114d3bd5d44256399f" {
+		t.Fatalf("unexpected subtree hash %s", prefixed)
+	}
+	if _, err := writeTree("", "missing/"); err == nil {
+		t.Fatal("expected error for unknown prefix")
 	}
 }
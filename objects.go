@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+// gitDir returns the repository directory, tests run against tmp/.git
+func gitDir(runEnv string) string {
+	if runEnv == "test" {
+		return filepath.Join("tmp", ".git")
+	}
+	return ".git"
+}
+
+// ObjectStore is the single place that knows how objects are laid out under .git/objects.
+// every command reads and writes objects through it instead of touching the files directly.
+type ObjectStore struct {
+	dir   string
+	packs []*packFile
+	cache *deltaBaseCache
+}
+
+func newObjectStore(runEnv string) *ObjectStore {
+	return &ObjectStore{dir: filepath.Join(gitDir(runEnv), "objects")}
+}
+
+// hashFor returns the object id of data stored as kind without writing anything
+func hashFor(kind string, data []byte) string {
+	hasher := sha1.New()
+	fmt.Fprintf(hasher, "%s %d\x00", kind, len(data))
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func (s *ObjectStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash[2:])
+}
+
+// Put stores data as a loose object of the given kind and returns its hash.
+// writing an object that already exists is a no-op.
+func (s *ObjectStore) Put(kind string, data []byte) (string, error) {
+	hash := hashFor(kind, data)
+	if s.Has(hash) {
+		return hash, nil
+	}
+
+	objDir := filepath.Join(s.dir, hash[:2])
+	if err := os.MkdirAll(objDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create %s dir: %w", objDir, err)
+	}
+
+	// write into a temp file first so a crash never leaves a truncated object behind
+	tmp, err := os.CreateTemp(objDir, "tmp_obj_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp object in %s: %w", objDir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := zlib.NewWriter(tmp)
+	if _, err := fmt.Fprintf(zw, "%s %d\x00", kind, len(data)); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write object header: %w", err)
+	}
+	if _, err := zw.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write compressed data: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("zlib close: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to close temp object: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return "", fmt.Errorf("failed to chmod object: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(hash)); err != nil {
+		return "", fmt.Errorf("failed to move object into place: %w", err)
+	}
+	return hash, nil
+}
+
+// Get returns the type and payload of an object, loose objects win over packed ones
+func (s *ObjectStore) Get(hash string) (string, []byte, error) {
+	if len(hash) < 3 {
+		return "", nil, fmt.Errorf("%w: %s", errObjectNotFound, hash)
+	}
+	file, err := os.Open(s.path(hash))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if p, i := s.findPacked(hash); p != nil {
+				return p.get(i)
+			}
+			return "", nil, fmt.Errorf("%w: %s", errObjectNotFound, hash)
+		}
+		return "", nil, fmt.Errorf("failed to open object %s: %w", hash, err)
+	}
+	defer file.Close()
+
+	buf, kind, size, err := readLooseHeader(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %w", hash, err)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(buf, payload); err != nil {
+		return "", nil, fmt.Errorf("failed to read payload of %s: %w", hash, err)
+	}
+	return kind, payload, nil
+}
+
+// Has reports whether the object exists in the store
+func (s *ObjectStore) Has(hash string) bool {
+	if len(hash) < 3 {
+		return false
+	}
+	if _, err := os.Stat(s.path(hash)); err == nil {
+		return true
+	}
+	p, _ := s.findPacked(hash)
+	return p != nil
+}
+
+// Stat returns the type and size of an object by reading only its header
+func (s *ObjectStore) Stat(hash string) (string, int, error) {
+	if len(hash) < 3 {
+		return "", 0, fmt.Errorf("%w: %s", errObjectNotFound, hash)
+	}
+	file, err := os.Open(s.path(hash))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if p, i := s.findPacked(hash); p != nil {
+				return p.stat(i)
+			}
+			return "", 0, fmt.Errorf("%w: %s", errObjectNotFound, hash)
+		}
+		return "", 0, fmt.Errorf("failed to open object %s: %w", hash, err)
+	}
+	defer file.Close()
+
+	_, kind, size, err := readLooseHeader(file)
+	if err != nil {
+		return "", 0, fmt.Errorf("object %s: %w", hash, err)
+	}
+	return kind, size, nil
+}
+
+// List returns the names of all loose and packed objects sorted
+func (s *ObjectStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s dir entries: %w", s.dir, err)
+	}
+	hashes := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 2 {
+			continue
+		}
+		subEntries, err := os.ReadDir(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sub entry content for %s dir: %w", entry.Name(), err)
+		}
+		for _, subEntry := range subEntries {
+			if subEntry.IsDir() || !isHex(subEntry.Name()) {
+				continue
+			}
+			hashes = append(hashes, entry.Name()+subEntry.Name())
+		}
+	}
+	for _, p := range s.loadPacks() {
+		for i := range p.count() {
+			hashes = append(hashes, p.hashAt(i))
+		}
+	}
+	sort.Strings(hashes)
+	return slices.Compact(hashes), nil
+}
+
+// Expand returns every loose and packed object whose hash starts with the hex prefix,
+// prefixes shorter than two characters match nothing
+func (s *ObjectStore) Expand(prefix string) ([]string, error) {
+	if len(prefix) < 2 || len(prefix) > 40 || !isHex(prefix) {
+		return nil, nil
+	}
+	var hashes []string
+	entries, err := os.ReadDir(filepath.Join(s.dir, prefix[:2]))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", prefix[:2], err)
+	}
+	for _, entry := range entries {
+		if hash := prefix[:2] + entry.Name(); len(hash) == 40 && strings.HasPrefix(hash, prefix) {
+			hashes = append(hashes, hash)
+		}
+	}
+	for _, p := range s.loadPacks() {
+		hashes = append(hashes, p.withPrefix(prefix)...)
+	}
+	sort.Strings(hashes)
+	return slices.Compact(hashes), nil
+}
+
+func readLooseHeader(r io.Reader) (*bufio.Reader, string, int, error) {
+	reader, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to create zlib reader: %w", err)
+	}
+	buf := bufio.NewReader(reader)
+	header, err := buf.ReadBytes(0x00)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to read null byte: %w", err)
+	}
+	header = header[:len(header)-1]
+	parts := bytes.SplitN(header, []byte(" "), 2)
+	if len(parts) != 2 {
+		return nil, "", 0, fmt.Errorf("malformed header %q", header)
+	}
+	size, err := strconv.Atoi(string(parts[1]))
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("malformed object size %q", parts[1])
+	}
+	return buf, string(parts[0]), size, nil
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
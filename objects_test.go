@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObjectStore(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("run_env", "test")
+	if err := initialize("test"); err != nil {
+		t.Fatal(err)
+	}
+	store := newObjectStore("test")
+
+	t.Run("should put and get an object back", func(t *testing.T) {
+		hash, err := store.Put("blob", []byte("hello world\n"))
+		if err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+		// same id as `git hash-object` for "hello world\n"
+		if hash != "3b18e512dba79e4c8300dd08aeb37f8e728b8dad" {
+			t.Fatalf("unexpected hash: %s", hash)
+		}
+		if _, err := os.Stat(filepath.Join("tmp", ".git", "objects", hash[:2], hash[2:])); err != nil {
+			t.Fatalf("object not written under run_env dir: %v", err)
+		}
+		kind, data, err := store.Get(hash)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if kind != "blob" || string(data) != "hello world\n" {
+			t.Fatalf("unexpected object %s %q", kind, data)
+		}
+		kind, size, err := store.Stat(hash)
+		if err != nil || kind != "blob" || size != 12 {
+			t.Fatalf("unexpected stat %s %d %v", kind, size, err)
+		}
+		if !store.Has(hash) {
+			t.Fatal("expected object to exist")
+		}
+	})
+
+	t.Run("should be a no-op to put the same object twice", func(t *testing.T) {
+		first, err := store.Put("tree", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := store.Put("tree", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != second {
+			t.Fatalf("hash changed between writes: %s %s", first, second)
+		}
+		if _, _, err := store.Get(first); err != nil {
+			t.Fatalf("object corrupted after second put: %v", err)
+		}
+	})
+
+	t.Run("should report missing objects", func(t *testing.T) {
+		missing := "0000000000000000000000000000000000000000"
+		if store.Has(missing) {
+			t.Fatal("unexpected object")
+		}
+		if _, _, err := store.Get(missing); !errors.Is(err, errObjectNotFound) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	})
+
+	t.Run("should write trees and blobs through the same store", func(t *testing.T) {
+		if err := os.WriteFile("tmp/a.txt", []byte("a"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := add("test", []string{"-A"}); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := writeTree("test", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !store.Has(hash) || !store.Has(hashFor("blob", []byte("a"))) {
+			t.Fatal("write-tree did not honour run_env")
+		}
+	})
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pack entry types as stored in the 3 type bits of an entry header
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packKinds = map[byte]string{
+	packCommit: "commit",
+	packTree:   "tree",
+	packBlob:   "blob",
+	packTag:    "tag",
+}
+
+var idxMagic = []byte{0xff, 't', 'O', 'c'}
+
+// packFile is one .pack with the .idx (v2) that indexes it
+type packFile struct {
+	name    string
+	names   []byte // sorted 20 byte object ids back to back
+	offsets []int64
+	fanout  [256]uint32
+	file    *os.File
+	store   *ObjectStore // resolves ref delta bases that live outside this pack
+}
+
+// openPack parses idxPath and opens the .pack next to it
+func openPack(idxPath string) (*packFile, error) {
+	raw, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", idxPath, err)
+	}
+	if len(raw) < 8+256*4+40 || !bytes.Equal(raw[:4], idxMagic) {
+		return nil, fmt.Errorf("%s is not a v2 pack index", idxPath)
+	}
+	if version := binary.BigEndian.Uint32(raw[4:8]); version != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index version %d", idxPath, version)
+	}
+	sum := sha1.Sum(raw[:len(raw)-20])
+	if !bytes.Equal(sum[:], raw[len(raw)-20:]) {
+		return nil, fmt.Errorf("%s: index checksum mismatch", idxPath)
+	}
+
+	p := &packFile{name: strings.TrimSuffix(filepath.Base(idxPath), ".idx")}
+	pos := 8
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(raw[pos:])
+		pos += 4
+	}
+	count := int(p.fanout[255])
+
+	// names, crc32s, 4 byte offsets, 8 byte offsets, then two trailing checksums
+	if len(raw) < pos+count*(20+4+4)+40 {
+		return nil, fmt.Errorf("%s: index truncated", idxPath)
+	}
+	p.names = raw[pos : pos+count*20]
+	pos += count * 20
+	pos += count * 4
+
+	small := raw[pos : pos+count*4]
+	pos += count * 4
+	large := raw[pos : len(raw)-40]
+
+	p.offsets = make([]int64, count)
+	for i := range count {
+		off := binary.BigEndian.Uint32(small[i*4:])
+		if off&0x80000000 == 0 {
+			p.offsets[i] = int64(off)
+			continue
+		}
+		// the msb says the real offset lives in the 64 bit table
+		at := int(off&0x7fffffff) * 8
+		if at+8 > len(large) {
+			return nil, fmt.Errorf("%s: bad 64 bit offset index %d", idxPath, at/8)
+		}
+		p.offsets[i] = int64(binary.BigEndian.Uint64(large[at:]))
+	}
+
+	packPath := strings.TrimSuffix(idxPath, ".idx") + ".pack"
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", packPath, err)
+	}
+	header := make([]byte, 12)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s header: %w", packPath, err)
+	}
+	if string(header[:4]) != "PACK" {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a pack file", packPath)
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 && version != 3 {
+		file.Close()
+		return nil, fmt.Errorf("%s: unsupported pack version %d", packPath, version)
+	}
+	if n := binary.BigEndian.Uint32(header[8:12]); int(n) != count {
+		file.Close()
+		return nil, fmt.Errorf("%s has %d objects but its index has %d", packPath, n, count)
+	}
+	p.file = file
+	return p, nil
+}
+
+func (p *packFile) count() int {
+	return len(p.offsets)
+}
+
+func (p *packFile) hashAt(i int) string {
+	return hex.EncodeToString(p.names[i*20 : i*20+20])
+}
+
+// withPrefix returns the ids in the index that start with the hex prefix
+func (p *packFile) withPrefix(prefix string) []string {
+	first, err := strconv.ParseUint(prefix[:2], 16, 8)
+	if err != nil {
+		return nil
+	}
+	lo := 0
+	if first > 0 {
+		lo = int(p.fanout[first-1])
+	}
+	hi := int(p.fanout[first])
+	i := lo + sort.Search(hi-lo, func(i int) bool { return p.hashAt(lo+i) >= prefix })
+	var found []string
+	for ; i < hi && strings.HasPrefix(p.hashAt(i), prefix); i++ {
+		found = append(found, p.hashAt(i))
+	}
+	return found
+}
+
+// find returns the position of hash in the index or -1
+func (p *packFile) find(hash string) int {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != 20 {
+		return -1
+	}
+	lo := 0
+	if raw[0] > 0 {
+		lo = int(p.fanout[raw[0]-1])
+	}
+	hi := int(p.fanout[raw[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.names[(lo+i)*20:(lo+i)*20+20], raw) >= 0
+	})
+	if i < hi && bytes.Equal(p.names[i*20:i*20+20], raw) {
+		return i
+	}
+	return -1
+}
+
+// packEntry is the decoded header of one object inside a pack
+type packEntry struct {
+	offset     int64
+	kind       byte
+	size       int64
+	dataOffset int64
+	baseOffset int64  // for ofs deltas
+	baseHash   string // for ref deltas
+}
+
+func (p *packFile) readEntry(offset int64) (packEntry, error) {
+	buf := make([]byte, 32)
+	n, err := p.file.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return packEntry{}, fmt.Errorf("failed to read pack entry at %d: %w", offset, err)
+	}
+	buf = buf[:n]
+	if len(buf) == 0 {
+		return packEntry{}, fmt.Errorf("pack entry at %d is out of range", offset)
+	}
+
+	// type in bits 4-6 of the first byte, size as a little endian base 128 varint
+	entry := packEntry{offset: offset, kind: (buf[0] >> 4) & 0x7, size: int64(buf[0] & 0x0f)}
+	i, shift := 0, uint(4)
+	for buf[i]&0x80 != 0 {
+		i++
+		if i >= len(buf) {
+			return packEntry{}, fmt.Errorf("pack entry at %d has a truncated size", offset)
+		}
+		entry.size |= int64(buf[i]&0x7f) << shift
+		shift += 7
+	}
+	i++
+
+	switch entry.kind {
+	case packOfsDelta:
+		// big endian varint where each continuation adds one to avoid duplicate encodings
+		if i >= len(buf) {
+			return packEntry{}, fmt.Errorf("pack entry at %d has a truncated delta offset", offset)
+		}
+		c := buf[i]
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			i++
+			if i >= len(buf) {
+				return packEntry{}, fmt.Errorf("pack entry at %d has a truncated delta offset", offset)
+			}
+			c = buf[i]
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		i++
+		entry.baseOffset = offset - rel
+		if rel <= 0 || entry.baseOffset < 12 {
+			return packEntry{}, fmt.Errorf("pack entry at %d has a bad delta base offset", offset)
+		}
+	case packRefDelta:
+		if i+20 > len(buf) {
+			return packEntry{}, fmt.Errorf("pack entry at %d has a truncated base id", offset)
+		}
+		entry.baseHash = hex.EncodeToString(buf[i : i+20])
+		i += 20
+	case packCommit, packTree, packBlob, packTag:
+	default:
+		return packEntry{}, fmt.Errorf("pack entry at %d has unknown type %d", offset, entry.kind)
+	}
+	entry.dataOffset = offset + int64(i)
+	return entry, nil
+}
+
+// inflate decompresses the zlib stream of an entry, size is the expected inflated length
+func (p *packFile) inflate(entry packEntry) ([]byte, error) {
+	reader, err := zlib.NewReader(io.NewSectionReader(p.file, entry.dataOffset, 1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate pack entry at %d: %w", entry.dataOffset, err)
+	}
+	defer reader.Close()
+	data := make([]byte, entry.size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("failed to inflate pack entry at %d: %w", entry.dataOffset, err)
+	}
+	return data, nil
+}
+
+// maxDeltaChain guards against corrupt packs whose delta bases loop
+const maxDeltaChain = 10000
+
+// get reads the object at position i of the index
+func (p *packFile) get(i int) (string, []byte, error) {
+	kind, data, err := p.unpack(p.offsets[i])
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", p.hashAt(i), err)
+	}
+	return kind, data, nil
+}
+
+// unpack materialises the object at offset, walking down its delta chain to the
+// first base that is either a whole object or already cached and then applying
+// the deltas back up, caching every intermediate result on the way
+func (p *packFile) unpack(offset int64) (string, []byte, error) {
+	cache := p.store.deltaCache()
+	var chain []packEntry
+	var kind string
+	var data []byte
+	at := offset
+	for {
+		if k, d, ok := cache.get(p, at); ok {
+			kind, data = k, d
+			if len(chain) == 0 {
+				// never hand out the cached slice itself
+				data = bytes.Clone(d)
+			}
+			break
+		}
+		entry, err := p.readEntry(at)
+		if err != nil {
+			return "", nil, err
+		}
+		if k, ok := packKinds[entry.kind]; ok {
+			d, err := p.inflate(entry)
+			if err != nil {
+				return "", nil, err
+			}
+			kind, data = k, d
+			if len(chain) > 0 {
+				cache.add(p, at, kind, data)
+			}
+			break
+		}
+		chain = append(chain, entry)
+		if len(chain) > maxDeltaChain {
+			return "", nil, fmt.Errorf("delta chain at %d is too deep", offset)
+		}
+		if entry.kind == packOfsDelta {
+			at = entry.baseOffset
+			continue
+		}
+		if i := p.find(entry.baseHash); i >= 0 {
+			at = p.offsets[i]
+			continue
+		}
+		// the base of a ref delta may live loose or in another pack
+		k, d, err := p.store.Get(entry.baseHash)
+		if err != nil {
+			return "", nil, fmt.Errorf("delta base %s: %w", entry.baseHash, err)
+		}
+		kind, data = k, d
+		break
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		delta, err := p.inflate(chain[i])
+		if err != nil {
+			return "", nil, err
+		}
+		data, err = applyDelta(data, delta)
+		if err != nil {
+			return "", nil, fmt.Errorf("delta at %d: %w", chain[i].offset, err)
+		}
+		if i > 0 {
+			cache.add(p, chain[i].offset, kind, data)
+		}
+	}
+	return kind, data, nil
+}
+
+// stat returns the type and size at position i without materialising the object
+func (p *packFile) stat(i int) (string, int, error) {
+	entry, err := p.readEntry(p.offsets[i])
+	if err != nil {
+		return "", 0, err
+	}
+	if kind, ok := packKinds[entry.kind]; ok {
+		return kind, int(entry.size), nil
+	}
+
+	// the final size is the second varint of the delta, the type is the one of the chain base
+	head, err := p.inflateHead(entry, 20)
+	if err != nil {
+		return "", 0, err
+	}
+	_, pos, err := deltaHeaderSize(head, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	size, _, err := deltaHeaderSize(head, pos)
+	if err != nil {
+		return "", 0, err
+	}
+	for range maxDeltaChain {
+		if entry.kind == packRefDelta {
+			j := p.find(entry.baseHash)
+			if j < 0 {
+				kind, _, err := p.store.Stat(entry.baseHash)
+				return kind, size, err
+			}
+			entry, err = p.readEntry(p.offsets[j])
+		} else {
+			entry, err = p.readEntry(entry.baseOffset)
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		if kind, ok := packKinds[entry.kind]; ok {
+			return kind, size, nil
+		}
+	}
+	return "", 0, fmt.Errorf("delta chain of %s is too deep", p.hashAt(i))
+}
+
+// inflateHead decompresses at most n bytes from the start of an entry
+func (p *packFile) inflateHead(entry packEntry, n int) ([]byte, error) {
+	reader, err := zlib.NewReader(io.NewSectionReader(p.file, entry.dataOffset, 1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate pack entry at %d: %w", entry.dataOffset, err)
+	}
+	defer reader.Close()
+	head := make([]byte, min(int64(n), entry.size))
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, fmt.Errorf("failed to inflate pack entry at %d: %w", entry.dataOffset, err)
+	}
+	return head, nil
+}
+
+// loadPacks opens every pack in objects/pack once per store
+func (s *ObjectStore) loadPacks() []*packFile {
+	if s.packs != nil {
+		return s.packs
+	}
+	s.packs = []*packFile{}
+	idxs, _ := filepath.Glob(filepath.Join(s.dir, "pack", "*.idx"))
+	sort.Strings(idxs)
+	for _, idx := range idxs {
+		p, err := openPack(idx)
+		if err != nil {
+			// a broken pack should not hide the objects in the others
+			fmt.Fprintf(os.Stderr, "warning: skipping %s: %s\n", idx, err.Error())
+			continue
+		}
+		p.store = s
+		s.packs = append(s.packs, p)
+	}
+	return s.packs
+}
+
+// findPacked returns the pack holding hash and its position in that pack's index
+func (s *ObjectStore) findPacked(hash string) (*packFile, int) {
+	for _, p := range s.loadPacks() {
+		if i := p.find(hash); i >= 0 {
+			return p, i
+		}
+	}
+	return nil, -1
+}
+
+func (s *ObjectStore) deltaCache() *deltaBaseCache {
+	if s.cache == nil {
+		s.cache = newDeltaBaseCache(deltaBaseCacheLimit)
+	}
+	return s.cache
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type testPackObject struct {
+	kind byte
+	data []byte
+	// deltas store data as instructions against objs[base] and name themselves after result
+	base   int
+	result []byte
+}
+
+// buildTestPack writes a pack and a v2 index for objs into objDir/pack.
+// when large is set every offset goes through the 64 bit offset table.
+func buildTestPack(t *testing.T, objDir string, objs []testPackObject, large bool) []string {
+	t.Helper()
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(objs)))
+
+	type idxEntry struct {
+		name   []byte
+		offset uint64
+	}
+	var entries []idxEntry
+	var hashes []string
+	var offsets []int
+	for _, obj := range objs {
+		offset := pack.Len()
+		offsets = append(offsets, offset)
+		size := len(obj.data)
+		b := byte(obj.kind<<4) | byte(size&0x0f)
+		size >>= 4
+		for size > 0 {
+			pack.WriteByte(b | 0x80)
+			b = byte(size & 0x7f)
+			size >>= 7
+		}
+		pack.WriteByte(b)
+		switch obj.kind {
+		case packOfsDelta:
+			rel := offset - offsets[obj.base]
+			enc := []byte{byte(rel & 0x7f)}
+			for rel >>= 7; rel > 0; rel >>= 7 {
+				rel--
+				enc = append([]byte{byte(0x80 | rel&0x7f)}, enc...)
+			}
+			pack.Write(enc)
+		case packRefDelta:
+			raw, _ := hex.DecodeString(hashes[obj.base])
+			pack.Write(raw)
+		}
+		zw := zlib.NewWriter(&pack)
+		zw.Write(obj.data)
+		zw.Close()
+
+		hash := ""
+		if obj.result != nil {
+			kind, _ := resolveTestKind(objs, obj)
+			hash = hashFor(kind, obj.result)
+		} else {
+			hash = hashFor(packKinds[obj.kind], obj.data)
+		}
+		raw, _ := hex.DecodeString(hash)
+		entries = append(entries, idxEntry{raw, uint64(offset)})
+		hashes = append(hashes, hash)
+	}
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].name, entries[j].name) < 0 })
+	var idx bytes.Buffer
+	idx.Write(idxMagic)
+	binary.Write(&idx, binary.BigEndian, uint32(2))
+	for b := range 256 {
+		n := 0
+		for _, e := range entries {
+			if int(e.name[0]) <= b {
+				n++
+			}
+		}
+		binary.Write(&idx, binary.BigEndian, uint32(n))
+	}
+	for _, e := range entries {
+		idx.Write(e.name)
+	}
+	for range entries {
+		binary.Write(&idx, binary.BigEndian, uint32(0))
+	}
+	for i, e := range entries {
+		if large {
+			binary.Write(&idx, binary.BigEndian, uint32(0x80000000|i))
+		} else {
+			binary.Write(&idx, binary.BigEndian, uint32(e.offset))
+		}
+	}
+	if large {
+		for _, e := range entries {
+			binary.Write(&idx, binary.BigEndian, e.offset)
+		}
+	}
+	idx.Write(sum[:])
+	idxSum := sha1.Sum(idx.Bytes())
+	idx.Write(idxSum[:])
+
+	packDir := filepath.Join(objDir, "pack")
+	if err := os.MkdirAll(packDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	name := "pack-" + hex.EncodeToString(sum[:])
+	if err := os.WriteFile(filepath.Join(packDir, name+".pack"), pack.Bytes(), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(packDir, name+".idx"), idx.Bytes(), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+func resolveTestKind(objs []testPackObject, obj testPackObject) (string, bool) {
+	for obj.result != nil {
+		obj = objs[obj.base]
+	}
+	kind, ok := packKinds[obj.kind]
+	return kind, ok
+}
+
+func TestPackRead(t *testing.T) {
+	for _, large := range []bool{false, true} {
+		t.Run("should read packed objects", func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := initialize("test"); err != nil {
+				t.Fatal(err)
+			}
+			blob := bytes.Repeat([]byte("packed blob content\n"), 50)
+			tree := append([]byte("100644 a.txt\x00"), make([]byte, 20)...)
+			hashes := buildTestPack(t, "tmp/.git/objects", []testPackObject{
+				{kind: packBlob, data: blob},
+				{kind: packTree, data: tree},
+				{kind: packCommit, data: []byte("tree " + hashFor("tree", tree) + "\n\nmsg\n")},
+			}, large)
+
+			store := newObjectStore("test")
+			kind, data, err := store.Get(hashes[0])
+			if err != nil {
+				t.Fatalf("get packed blob: %v", err)
+			}
+			if kind != "blob" || !bytes.Equal(data, blob) {
+				t.Fatalf("unexpected packed object %s (%d bytes)", kind, len(data))
+			}
+			kind, size, err := store.Stat(hashes[1])
+			if err != nil || kind != "tree" || size != len(tree) {
+				t.Fatalf("unexpected stat %s %d %v", kind, size, err)
+			}
+			if !store.Has(hashes[2]) {
+				t.Fatal("expected packed commit to exist")
+			}
+			all, err := store.List()
+			if err != nil || len(all) != 3 {
+				t.Fatalf("expected 3 packed objects, got %v %v", all, err)
+			}
+
+			out, err := lsTree("test", []string{"--name-only", hashes[1]})
+			if err != nil || out != "a.txt\n" {
+				t.Fatalf("ls-tree on packed tree: %q %v", out, err)
+			}
+		})
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello brave new world")
+	// src 21, dst 17: copy 6 bytes at 0, insert "old ", copy 5 bytes at 16, insert "!\n"
+	delta := []byte{21, 17, 0x90, 6, 4, 'o', 'l', 'd', ' ', 0x91, 16, 5, 2, '!', '\n'}
+	out, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("apply delta: %v", err)
+	}
+	if string(out) != "hello old world!\n" {
+		t.Fatalf("unexpected delta result %q", out)
+	}
+	if _, err := applyDelta(base[:3], delta); err == nil {
+		t.Fatal("expected base size mismatch error")
+	}
+	if _, err := applyDelta(base, []byte{21, 1, 0x91, 30, 5}); err == nil {
+		t.Fatal("expected out of range copy error")
+	}
+}
+
+func TestPackDeltaChains(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := initialize("test"); err != nil {
+		t.Fatal(err)
+	}
+	v1 := []byte("package main\n\nfunc main() {}\n")
+	v2 := []byte("package main\n\nfunc main() { run() }\n")
+	v3 := []byte("package main\n\nfunc main() { run() }\nfunc run() {}\n")
+	// v2 copies the first 27 bytes of v1 and inserts the rest
+	d2 := append([]byte{byte(len(v1)), byte(len(v2)), 0x90, 27, byte(len(v2) - 27)}, v2[27:]...)
+	// v3 copies all of v2 and appends
+	d3 := append([]byte{byte(len(v2)), byte(len(v3)), 0x90, byte(len(v2)), byte(len(v3) - len(v2))}, v3[len(v2):]...)
+
+	hashes := buildTestPack(t, "tmp/.git/objects", []testPackObject{
+		{kind: packBlob, data: v1},
+		{kind: packOfsDelta, data: d2, base: 0, result: v2},
+		{kind: packRefDelta, data: d3, base: 1, result: v3},
+	}, false)
+
+	store := newObjectStore("test")
+	for i, want := range [][]byte{v1, v2, v3} {
+		kind, data, err := store.Get(hashes[i])
+		if err != nil {
+			t.Fatalf("get %d: %v", i, err)
+		}
+		if kind != "blob" || !bytes.Equal(data, want) {
+			t.Fatalf("object %d resolved to %s %q", i, kind, data)
+		}
+		kind, size, err := store.Stat(hashes[i])
+		if err != nil || kind != "blob" || size != len(want) {
+			t.Fatalf("stat %d: %s %d %v", i, kind, size, err)
+		}
+	}
+
+	// a second read is served from the base cache and must not share memory with it
+	_, data, _ := store.Get(hashes[1])
+	data[0] = 'X'
+	if _, again, _ := store.Get(hashes[1]); !bytes.Equal(again, v2) {
+		t.Fatal("cached base was modified through a returned slice")
+	}
+}
+
+func TestRepack(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := initialize("test"); err != nil {
+		t.Fatal(err)
+	}
+	store := newObjectStore("test")
+	var hashes []string
+	for i := range 5 {
+		hash, err := store.Put("blob", bytes.Repeat([]byte{byte('a' + i)}, 100*(i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	t.Run("should pack loose objects and drop them with -d", func(t *testing.T) {
+		if _, err := repack("test", []string{"-d"}); err != nil {
+			t.Fatalf("repack failed: %v", err)
+		}
+		loose, err := store.looseObjects()
+		if err != nil || len(loose) != 0 {
+			t.Fatalf("expected no loose objects left, got %v %v", loose, err)
+		}
+		idxs, _ := filepath.Glob("tmp/.git/objects/pack/*.idx")
+		if len(idxs) != 1 {
+			t.Fatalf("expected one pack index, got %v", idxs)
+		}
+		for i, hash := range hashes {
+			kind, data, err := newObjectStore("test").Get(hash)
+			if err != nil {
+				t.Fatalf("get %s from pack: %v", hash, err)
+			}
+			if kind != "blob" || len(data) != 100*(i+1) {
+				t.Fatalf("unexpected packed object %s %d", kind, len(data))
+			}
+		}
+	})
+
+	t.Run("should consolidate packs with -a -d", func(t *testing.T) {
+		extra, err := store.Put("blob", []byte("one more"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repack("test", []string{"-d"}); err != nil {
+			t.Fatal(err)
+		}
+		if idxs, _ := filepath.Glob("tmp/.git/objects/pack/*.idx"); len(idxs) != 2 {
+			t.Fatalf("expected two packs before consolidating, got %v", idxs)
+		}
+		if _, err := repack("test", []string{"-a", "-d"}); err != nil {
+			t.Fatal(err)
+		}
+		if idxs, _ := filepath.Glob("tmp/.git/objects/pack/*.idx"); len(idxs) != 1 {
+			t.Fatalf("expected a single pack after -a -d, got %v", idxs)
+		}
+		all, err := newObjectStore("test").List()
+		if err != nil || len(all) != 6 || !newObjectStore("test").Has(extra) {
+			t.Fatalf("objects lost while consolidating: %v %v", all, err)
+		}
+	})
+
+	t.Run("should say when there is nothing to pack", func(t *testing.T) {
+		out, err := repack("test", nil)
+		if err != nil || out != "Nothing new to pack." {
+			t.Fatalf("unexpected output %q %v", out, err)
+		}
+	})
+}
+
+func TestBuildPackIndexLargeOffsets(t *testing.T) {
+	entries := []packIndexEntry{
+		{name: bytes.Repeat([]byte{0x02}, 20), offset: 12},
+		{name: bytes.Repeat([]byte{0x01}, 20), offset: 1 << 33},
+	}
+	idx := buildPackIndex(entries, make([]byte, 20))
+	count := 2
+	small := idx[8+256*4+count*24:]
+	if binary.BigEndian.Uint32(small) != 0x80000000 {
+		t.Fatalf("expected first sorted entry to use the 64 bit table, got %x", small[:4])
+	}
+	if binary.BigEndian.Uint32(small[4:]) != 12 {
+		t.Fatalf("expected small offset inline, got %x", small[4:8])
+	}
+	if binary.BigEndian.Uint64(small[8:]) != 1<<33 {
+		t.Fatalf("unexpected 64 bit offset %x", small[8:16])
+	}
+}
+
+func TestCreateDelta(t *testing.T) {
+	base := bytes.Repeat([]byte("func main() { fmt.Println(\"hello\") }\n"), 200)
+	target := append(bytes.Clone(base[:3000]), []byte("// inserted in the middle\n")...)
+	target = append(target, base[3000:]...)
+	target = append(target, bytes.Repeat([]byte{0}, 0x12000)...)
+
+	delta := newDeltaIndex(base).createDelta(target, 0)
+	if delta == nil {
+		t.Fatal("expected a delta")
+	}
+	out, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("apply created delta: %v", err)
+	}
+	if !bytes.Equal(out, target) {
+		t.Fatal("delta did not round trip")
+	}
+	if newDeltaIndex(base).createDelta(target, 10) != nil {
+		t.Fatal("expected delta to be dropped when over max size")
+	}
+}
+
+func TestRepackDeltas(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := initialize("test"); err != nil {
+		t.Fatal(err)
+	}
+	store := newObjectStore("test")
+	content := bytes.Repeat([]byte("some line of go code that repeats\n"), 300)
+	var hashes []string
+	for i := range 6 {
+		content = append(content, []byte("// one more revision\n")...)
+		content[i*40] = 'X'
+		hash, err := store.Put("blob", bytes.Clone(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	packSize := func(args ...string) int64 {
+		if _, err := repack("test", args); err != nil {
+			t.Fatalf("repack %v: %v", args, err)
+		}
+		packs, _ := filepath.Glob("tmp/.git/objects/pack/*.pack")
+		if len(packs) != 1 {
+			t.Fatalf("expected one pack, got %v", packs)
+		}
+		info, err := os.Stat(packs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	full := packSize("-a", "-d", "--window=0")
+	deltified := packSize("-a", "-d", "--window=10", "--depth=3")
+	if deltified >= full {
+		t.Fatalf("deltas did not shrink the pack: %d >= %d", deltified, full)
+	}
+
+	reader := newObjectStore("test")
+	p := reader.loadPacks()[0]
+	deltas := 0
+	for i := range p.count() {
+		entry, err := p.readEntry(p.offsets[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.kind == packOfsDelta {
+			deltas++
+		}
+		// chains never go past --depth
+		depth := 0
+		for entry.kind == packOfsDelta {
+			depth++
+			if entry, err = p.readEntry(entry.baseOffset); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if depth > 3 {
+			t.Fatalf("delta chain of %d exceeds depth 3", depth)
+		}
+	}
+	if deltas == 0 {
+		t.Fatal("expected deltified entries in the pack")
+	}
+	for _, hash := range hashes {
+		if _, _, err := reader.Get(hash); err != nil {
+			t.Fatalf("get %s: %v", hash, err)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var packTypes = map[string]byte{
+	"commit": packCommit,
+	"tree":   packTree,
+	"blob":   packBlob,
+	"tag":    packTag,
+}
+
+// packWriter streams entries into a pack while tracking offsets and the trailer checksum
+type packWriter struct {
+	out    io.Writer
+	sum    hash.Hash
+	offset int64
+}
+
+func (w *packWriter) Write(b []byte) (int, error) {
+	n, err := w.out.Write(b)
+	w.sum.Write(b[:n])
+	w.offset += int64(n)
+	return n, err
+}
+
+// packIndexEntry is what the .idx needs to know about one written object
+type packIndexEntry struct {
+	name   []byte
+	crc    uint32
+	offset int64
+}
+
+// writeEntry appends one object, baseOffset > 0 makes it an ofs delta of the entry at that offset
+func (w *packWriter) writeEntry(kind byte, data []byte, baseOffset int64) (uint32, error) {
+	var entry bytes.Buffer
+	size := len(data)
+	b := kind<<4 | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		entry.WriteByte(b | 0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	entry.WriteByte(b)
+
+	if kind == packOfsDelta {
+		rel := w.offset - baseOffset
+		enc := []byte{byte(rel & 0x7f)}
+		for rel >>= 7; rel > 0; rel >>= 7 {
+			rel--
+			enc = append([]byte{byte(0x80 | rel&0x7f)}, enc...)
+		}
+		entry.Write(enc)
+	}
+
+	zw := zlib.NewWriter(&entry)
+	if _, err := zw.Write(data); err != nil {
+		return 0, fmt.Errorf("failed to compress pack entry: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress pack entry: %w", err)
+	}
+	if _, err := w.Write(entry.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to write pack entry: %w", err)
+	}
+	return crc32.ChecksumIEEE(entry.Bytes()), nil
+}
+
+// packOptions tune the delta search done while writing a pack
+type packOptions struct {
+	window int // how many previous objects are tried as a delta base, 0 disables deltas
+	depth  int // longest delta chain allowed
+}
+
+var defaultPackOptions = packOptions{window: 10, depth: 50}
+
+// packCandidate is an object waiting to be written, ordered so similar objects end up close
+type packCandidate struct {
+	hash     string
+	kind     string
+	size     int
+	nameHash uint32
+}
+
+// packNameHash is git's pack name hash, it weighs the last characters of a path most
+// so files with the same name or extension sort next to each other
+func packNameHash(name string) uint32 {
+	var h uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		h = (h >> 2) + uint32(c)<<24
+	}
+	return h
+}
+
+// packNameHints maps blobs and trees to the file name they appear under in the packed trees
+func (s *ObjectStore) packNameHints(candidates []packCandidate) map[string]string {
+	names := map[string]string{}
+	for _, c := range candidates {
+		if c.kind != "tree" {
+			continue
+		}
+		_, data, err := s.Get(c.hash)
+		if err != nil {
+			continue
+		}
+		for len(data) > 0 {
+			sp := bytes.IndexByte(data, ' ')
+			nul := bytes.IndexByte(data, 0)
+			if sp < 0 || nul < sp || nul+21 > len(data) {
+				break
+			}
+			child := hex.EncodeToString(data[nul+1 : nul+21])
+			if _, ok := names[child]; !ok {
+				names[child] = string(data[sp+1 : nul])
+			}
+			data = data[nul+21:]
+		}
+	}
+	return names
+}
+
+// packWindowEntry is an already written object that later objects may delta against
+type packWindowEntry struct {
+	kind   string
+	offset int64
+	depth  int
+	index  *deltaIndex
+}
+
+// writePack packs the given objects into objects/pack and returns the new pack name.
+// objects are sorted by type, name and size and each one is tried as a delta against
+// the previous opts.window objects of the same type, like pack-objects does.
+func (s *ObjectStore) writePack(hashes []string, opts packOptions) (string, error) {
+	candidates := make([]packCandidate, 0, len(hashes))
+	for _, hash := range hashes {
+		kind, size, err := s.Stat(hash)
+		if err != nil {
+			return "", err
+		}
+		candidates = append(candidates, packCandidate{hash: hash, kind: kind, size: size})
+	}
+	if opts.window > 0 {
+		names := s.packNameHints(candidates)
+		for i := range candidates {
+			candidates[i].nameHash = packNameHash(names[candidates[i].hash])
+		}
+		// bigger objects first so most deltas only remove data
+		sort.SliceStable(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if a.kind != b.kind {
+				return packTypes[a.kind] < packTypes[b.kind]
+			}
+			if a.nameHash != b.nameHash {
+				return a.nameHash < b.nameHash
+			}
+			return a.size > b.size
+		})
+	}
+
+	packDir := filepath.Join(s.dir, "pack")
+	if err := os.MkdirAll(packDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create %s dir: %w", packDir, err)
+	}
+	tmp, err := os.CreateTemp(packDir, "tmp_pack_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp pack: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := &packWriter{out: tmp, sum: sha1.New()}
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(candidates)))
+	if _, err := w.Write(header); err != nil {
+		return "", fmt.Errorf("failed to write pack header: %w", err)
+	}
+
+	entries := make([]packIndexEntry, 0, len(candidates))
+	window := make([]packWindowEntry, 0, opts.window)
+	for _, c := range candidates {
+		kind, data, err := s.Get(c.hash)
+		if err != nil {
+			return "", err
+		}
+
+		// a delta is only worth it when it is well under half of the whole object
+		var delta []byte
+		var base packWindowEntry
+		maxSize := len(data)/2 - 20
+		for i := len(window) - 1; i >= 0 && maxSize > 0; i-- {
+			cand := window[i]
+			if cand.kind != kind || cand.depth >= opts.depth {
+				continue
+			}
+			if d := cand.index.createDelta(data, maxSize); d != nil {
+				delta, base, maxSize = d, cand, len(d)-1
+			}
+		}
+
+		offset := w.offset
+		var crc uint32
+		depth := 0
+		if delta != nil {
+			crc, err = w.writeEntry(packOfsDelta, delta, base.offset)
+			depth = base.depth + 1
+		} else {
+			crc, err = w.writeEntry(packTypes[kind], data, 0)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", c.hash, err)
+		}
+		name, _ := hex.DecodeString(c.hash)
+		entries = append(entries, packIndexEntry{name: name, crc: crc, offset: offset})
+
+		if opts.window > 0 {
+			if len(window) == opts.window {
+				window = window[1:]
+			}
+			window = append(window, packWindowEntry{kind: kind, offset: offset, depth: depth, index: newDeltaIndex(data)})
+		}
+	}
+	return s.finishPack(tmp, w, entries)
+}
+
+// finishPack writes the trailer and the matching .idx, then moves both into place
+func (s *ObjectStore) finishPack(tmp *os.File, w *packWriter, entries []packIndexEntry) (string, error) {
+	packSum := w.sum.Sum(nil)
+	if _, err := tmp.Write(packSum); err != nil {
+		return "", fmt.Errorf("failed to write pack trailer: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to close pack: %w", err)
+	}
+
+	idx := buildPackIndex(entries, packSum)
+	name := "pack-" + hex.EncodeToString(packSum)
+	base := filepath.Join(s.dir, "pack", name)
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return "", fmt.Errorf("failed to chmod pack: %w", err)
+	}
+	// the pack goes first, an .idx without its pack would make every lookup fail
+	if err := os.Rename(tmp.Name(), base+".pack"); err != nil {
+		return "", fmt.Errorf("failed to move pack into place: %w", err)
+	}
+	if err := os.WriteFile(base+".idx.tmp", idx, 0o444); err != nil {
+		return "", fmt.Errorf("failed to write pack index: %w", err)
+	}
+	if err := os.Rename(base+".idx.tmp", base+".idx"); err != nil {
+		return "", fmt.Errorf("failed to move pack index into place: %w", err)
+	}
+	s.closePacks()
+	return name, nil
+}
+
+// buildPackIndex serialises a v2 .idx for the entries of a pack
+func buildPackIndex(entries []packIndexEntry, packSum []byte) []byte {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].name, entries[j].name) < 0
+	})
+
+	var idx bytes.Buffer
+	idx.Write(idxMagic)
+	binary.Write(&idx, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, e := range entries {
+		fanout[e.name[0]]++
+	}
+	total := uint32(0)
+	for _, n := range fanout {
+		total += n
+		binary.Write(&idx, binary.BigEndian, total)
+	}
+	for _, e := range entries {
+		idx.Write(e.name)
+	}
+	for _, e := range entries {
+		binary.Write(&idx, binary.BigEndian, e.crc)
+	}
+	// offsets that do not fit in 31 bits point into a trailing 64 bit table
+	var large []int64
+	for _, e := range entries {
+		if e.offset < 0x80000000 {
+			binary.Write(&idx, binary.BigEndian, uint32(e.offset))
+			continue
+		}
+		binary.Write(&idx, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, e.offset)
+	}
+	for _, off := range large {
+		binary.Write(&idx, binary.BigEndian, uint64(off))
+	}
+	idx.Write(packSum)
+	sum := sha1.Sum(idx.Bytes())
+	idx.Write(sum[:])
+	return idx.Bytes()
+}
+
+// closePacks drops the opened packs so the next lookup sees the current pack dir
+func (s *ObjectStore) closePacks() {
+	for _, p := range s.packs {
+		p.file.Close()
+	}
+	s.packs = nil
+	s.cache = nil
+}
+
+// looseObjects lists only the objects stored as single zlib files
+func (s *ObjectStore) looseObjects() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s dir entries: %w", s.dir, err)
+	}
+	var hashes []string
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 2 || !isHex(entry.Name()) {
+			continue
+		}
+		subEntries, err := os.ReadDir(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s dir: %w", entry.Name(), err)
+		}
+		for _, subEntry := range subEntries {
+			if len(subEntry.Name()) == 38 && isHex(subEntry.Name()) {
+				hashes = append(hashes, entry.Name()+subEntry.Name())
+			}
+		}
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// repack collects objects into a single pack.
+// -a packs every object including the existing packs, -d removes what became redundant,
+// --window and --depth tune the delta search.
+func repack(runEnv string, args []string) (string, error) {
+	all, prune := false, false
+	opts := defaultPackOptions
+	for _, arg := range args {
+		switch {
+		case arg == "-a":
+			all = true
+		case arg == "-d":
+			prune = true
+		case arg == "-ad" || arg == "-da":
+			all, prune = true, true
+		case strings.HasPrefix(arg, "--window="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--window="))
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("repack err: invalid window %s", arg)
+			}
+			opts.window = n
+		case strings.HasPrefix(arg, "--depth="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--depth="))
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("repack err: invalid depth %s", arg)
+			}
+			opts.depth = n
+		default:
+			return "", fmt.Errorf("repack err: unknown option %s", arg)
+		}
+	}
+
+	store := newObjectStore(runEnv)
+	loose, err := store.looseObjects()
+	if err != nil {
+		return "", fmt.Errorf("repack err: %w", err)
+	}
+	hashes := loose
+	oldPacks := []string{}
+	if all {
+		if hashes, err = store.List(); err != nil {
+			return "", fmt.Errorf("repack err: %w", err)
+		}
+		for _, p := range store.loadPacks() {
+			oldPacks = append(oldPacks, p.name)
+		}
+	}
+	if len(hashes) == 0 {
+		return "Nothing new to pack.", nil
+	}
+
+	name, err := store.writePack(hashes, opts)
+	if err != nil {
+		return "", fmt.Errorf("repack err: %w", err)
+	}
+
+	if prune {
+		for _, hash := range loose {
+			if err := os.Remove(store.path(hash)); err != nil {
+				return "", fmt.Errorf("repack err: failed to remove loose %s: %w", hash, err)
+			}
+			// leaves the fan-out dir alone when it still has objects in it
+			os.Remove(filepath.Dir(store.path(hash)))
+		}
+		for _, old := range oldPacks {
+			if old == name {
+				continue
+			}
+			base := filepath.Join(store.dir, "pack", old)
+			if _, err := os.Stat(base + ".keep"); err == nil {
+				continue
+			}
+			for _, ext := range []string{".idx", ".pack", ".rev"} {
+				if err := os.Remove(base + ext); err != nil && !os.IsNotExist(err) {
+					return "", fmt.Errorf("repack err: failed to remove %s%s: %w", old, ext, err)
+				}
+			}
+		}
+	}
+
+	return fmt.Sprintf("packed %d objects into %s", len(hashes), strings.TrimPrefix(name, "pack-")), nil
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const symrefPrefix = "ref: "
+
+// maxSymrefDepth stops HEAD -> refs/heads/a -> HEAD style loops
+const maxSymrefDepth = 5
+
+var errRefNotFound = errors.New("ref not found")
+
+// refEntry is a ref name with the commit it finally points at
+type refEntry struct {
+	name string
+	hash string
+}
+
+func refPath(runEnv, name string) string {
+	return filepath.Join(gitDir(runEnv), filepath.FromSlash(name))
+}
+
+func packedRefsPath(runEnv string) string {
+	return filepath.Join(gitDir(runEnv), "packed-refs")
+}
+
+func reflogPath(runEnv, name string) string {
+	return filepath.Join(gitDir(runEnv), "logs", filepath.FromSlash(name))
+}
+
+// checkRefName applies the rules of git check-ref-format to a full ref name
+func checkRefName(name string) error {
+	bad := func() error { return fmt.Errorf("'%s' is not a valid ref name", name) }
+	if name == "HEAD" {
+		return nil
+	}
+	if !strings.HasPrefix(name, "refs/") || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") || strings.Contains(name, "//") {
+		return bad()
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return bad()
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return bad()
+		}
+	}
+	return nil
+}
+
+// readPackedRefs parses packed-refs into name -> hash, peeled `^` lines are skipped
+func readPackedRefs(runEnv string) (map[string]string, error) {
+	refs := map[string]string{}
+	f, err := os.Open(packedRefsPath(runEnv))
+	if errors.Is(err, os.ErrNotExist) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packed-refs: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok || len(hash) != 40 || !isHex(hash) {
+			return nil, fmt.Errorf("invalid packed-refs line %q", line)
+		}
+		refs[name] = hash
+	}
+	return refs, scanner.Err()
+}
+
+// readRawRef returns what a ref holds without following it, `ref: <target>` for
+// symbolic refs. loose refs win over packed ones.
+func readRawRef(runEnv, name string) (string, error) {
+	raw, err := os.ReadFile(refPath(runEnv, name))
+	if err == nil {
+		value := strings.TrimSpace(string(raw))
+		if !strings.HasPrefix(value, symrefPrefix) && (len(value) != 40 || !isHex(value)) {
+			return "", fmt.Errorf("%s is corrupt: %q", name, value)
+		}
+		return value, nil
+	}
+	// a missing file, or a directory holding refs below this name, falls back to packed-refs
+	if info, serr := os.Lstat(refPath(runEnv, name)); serr == nil && !info.IsDir() {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	packed, err := readPackedRefs(runEnv)
+	if err != nil {
+		return "", err
+	}
+	if hash, ok := packed[name]; ok {
+		return hash, nil
+	}
+	return "", errRefNotFound
+}
+
+// resolveRef follows symbolic refs and returns the last ref in the chain and its
+// commit. the hash is empty when that ref does not exist yet, like an unborn branch.
+func resolveRef(runEnv, name string) (string, string, error) {
+	for range maxSymrefDepth {
+		value, err := readRawRef(runEnv, name)
+		if errors.Is(err, errRefNotFound) {
+			return name, "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if !strings.HasPrefix(value, symrefPrefix) {
+			return name, value, nil
+		}
+		name = strings.TrimPrefix(value, symrefPrefix)
+	}
+	return "", "", fmt.Errorf("symbolic ref loop at %s", name)
+}
+
+// headCommit returns the branch HEAD points at and its commit, the commit is empty
+// on an unborn branch and the branch is empty when HEAD is detached
+func headCommit(runEnv string) (string, string, error) {
+	ref, hash, err := resolveRef(runEnv, "HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	if ref == "HEAD" {
+		if hash == "" {
+			return "", "", errors.New("HEAD is missing, not a git repository")
+		}
+		return "", hash, nil
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), hash, nil
+}
+
+// listRefs returns every ref below prefix (like "refs/heads/") sorted by name
+func listRefs(runEnv, prefix string) ([]refEntry, error) {
+	names := map[string]bool{}
+	packed, err := readPackedRefs(runEnv)
+	if err != nil {
+		return nil, err
+	}
+	for name := range packed {
+		names[name] = true
+	}
+	root := refPath(runEnv, "refs")
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(gitDir(runEnv), path)
+		if err != nil {
+			return err
+		}
+		names[filepath.ToSlash(rel)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	var refs []refEntry
+	for name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		_, hash, err := resolveRef(runEnv, name)
+		if err != nil {
+			return nil, err
+		}
+		// dangling symbolic refs have nothing to show
+		if hash != "" {
+			refs = append(refs, refEntry{name: name, hash: hash})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	return refs, nil
+}
+
+// derefTarget returns the ref an update of name really writes, HEAD on a branch writes the branch
+func derefTarget(runEnv, name string) (string, error) {
+	ref, _, err := resolveRef(runEnv, name)
+	return ref, err
+}
+
+// checkOld compares the current value of a locked ref with the expected one, an empty
+// expectation skips the check and zeroHash means the ref must not exist yet
+func checkOld(name, current, old string) error {
+	if old == "" {
+		return nil
+	}
+	if old == zeroHash {
+		if current != "" {
+			return fmt.Errorf("cannot lock ref '%s': reference already exists", name)
+		}
+		return nil
+	}
+	if current != old {
+		if current == "" {
+			return fmt.Errorf("cannot lock ref '%s': unable to resolve reference", name)
+		}
+		return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", name, current, old)
+	}
+	return nil
+}
+
+// updateRef points name at hash when it still holds old (see checkOld) and records
+// msg in the reflog. updating a symbolic ref like HEAD moves the branch it points at.
+func updateRef(runEnv, name, hash, old, msg string) error {
+	target, err := derefTarget(runEnv, name)
+	if err != nil {
+		return err
+	}
+	if err := checkRefName(target); err != nil {
+		return err
+	}
+	path := refPath(runEnv, target)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", target, err)
+	}
+	lock, err := acquireLock(path)
+	if err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", target, err)
+	}
+	_, current, err := resolveRef(runEnv, target)
+	if err != nil {
+		lock.rollback()
+		return err
+	}
+	if err := checkOld(target, current, old); err != nil {
+		lock.rollback()
+		return err
+	}
+	if err := lock.commit([]byte(hash + "\n")); err != nil {
+		return err
+	}
+
+	if err := appendReflog(runEnv, target, current, hash, msg); err != nil {
+		return err
+	}
+	if target != "HEAD" {
+		// the HEAD reflog follows the checked out branch
+		if head, _, err := resolveRef(runEnv, "HEAD"); err == nil && head == target {
+			return appendReflog(runEnv, "HEAD", current, hash, msg)
+		}
+	}
+	return nil
+}
+
+// deleteRef removes a loose and packed ref together with its reflog
+func deleteRef(runEnv, name, old string) error {
+	target, err := derefTarget(runEnv, name)
+	if err != nil {
+		return err
+	}
+	path := refPath(runEnv, target)
+	// a packed only ref may have no directory to hold its lock
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", target, err)
+	}
+	lock, err := acquireLock(path)
+	if err != nil {
+		removeEmptyRefDirs(runEnv, target)
+		return fmt.Errorf("cannot lock ref '%s': %w", target, err)
+	}
+	if err := removeRef(runEnv, target, old); err != nil {
+		lock.rollback()
+		removeEmptyRefDirs(runEnv, target)
+		return err
+	}
+	lock.rollback()
+	os.Remove(reflogPath(runEnv, target))
+	removeEmptyRefDirs(runEnv, target)
+	return nil
+}
+
+// renameRef moves a ref and its reflog to a new name and logs msg there. HEAD follows
+// when it was on the old name, its reflog gets the same msg.
+func renameRef(runEnv, oldName, newName, msg string) error {
+	if err := checkRefName(newName); err != nil {
+		return err
+	}
+	onHead := false
+	if raw, err := readRawRef(runEnv, "HEAD"); err == nil && raw == symrefPrefix+oldName {
+		onHead = true
+	}
+	_, hash, err := resolveRef(runEnv, oldName)
+	if err != nil {
+		return err
+	}
+	if _, existing, err := resolveRef(runEnv, newName); err != nil || existing != "" {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("cannot lock ref '%s': reference already exists", newName)
+	}
+	if hash == "" {
+		// an unborn branch only exists as the target of HEAD
+		if !onHead {
+			return fmt.Errorf("cannot rename ref '%s': not found", oldName)
+		}
+		return setSymbolicRef(runEnv, "HEAD", newName, "")
+	}
+
+	// the reflog is parked outside the ref namespace while the old ref is deleted
+	tmpLog := reflogPath(runEnv, "refs/.tmp-renamed-log")
+	hasLog := os.Rename(reflogPath(runEnv, oldName), tmpLog) == nil
+	if err := deleteRef(runEnv, oldName, hash); err != nil {
+		if hasLog {
+			os.Rename(tmpLog, reflogPath(runEnv, oldName))
+		}
+		return err
+	}
+	if hasLog {
+		newLog := reflogPath(runEnv, newName)
+		if err := os.MkdirAll(filepath.Dir(newLog), 0o755); err != nil {
+			return fmt.Errorf("failed to move reflog of %s: %w", oldName, err)
+		}
+		if err := os.Rename(tmpLog, newLog); err != nil {
+			return fmt.Errorf("failed to move reflog of %s: %w", oldName, err)
+		}
+	}
+
+	path := refPath(runEnv, newName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", newName, err)
+	}
+	lock, err := acquireLock(path)
+	if err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", newName, err)
+	}
+	if err := lock.commit([]byte(hash + "\n")); err != nil {
+		return err
+	}
+	if err := appendReflog(runEnv, newName, hash, hash, msg); err != nil {
+		return err
+	}
+	if !onHead {
+		return nil
+	}
+	if err := setSymbolicRef(runEnv, "HEAD", newName, ""); err != nil {
+		return err
+	}
+	return appendReflog(runEnv, "HEAD", "", hash, msg)
+}
+
+// removeRef drops the loose and packed copies of a ref its caller holds the lock of
+func removeRef(runEnv, name, old string) error {
+	_, current, err := resolveRef(runEnv, name)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return fmt.Errorf("cannot delete ref '%s': not found", name)
+	}
+	if err := checkOld(name, current, old); err != nil {
+		return err
+	}
+	if err := removePackedRef(runEnv, name); err != nil {
+		return err
+	}
+	if err := os.Remove(refPath(runEnv, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", name, err)
+	}
+	return nil
+}
+
+// removePackedRef rewrites packed-refs without the line of name and the `^` peeled line
+// under it, everything else including the header's traits stays as it was
+func removePackedRef(runEnv, name string) error {
+	path := packedRefsPath(runEnv)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	lock, err := acquireLock(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		lock.rollback()
+		return fmt.Errorf("failed to read packed-refs: %w", err)
+	}
+	var kept strings.Builder
+	found := false
+	lines := strings.SplitAfter(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if hash, ref, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " "); ref == name && len(hash) == 40 {
+			found = true
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "^") {
+				i++
+			}
+			continue
+		}
+		kept.WriteString(line)
+	}
+	if !found {
+		lock.rollback()
+		return nil
+	}
+	return lock.commit([]byte(kept.String()))
+}
+
+// removeEmptyRefDirs cleans the directories a deleted ref leaves behind, up to refs/heads and friends
+func removeEmptyRefDirs(runEnv, name string) {
+	for _, base := range []string{"", "logs/"} {
+		dir := filepath.Dir(filepath.FromSlash(name))
+		for strings.Count(filepath.ToSlash(dir), "/") >= 2 {
+			if os.Remove(filepath.Join(gitDir(runEnv), base, dir)) != nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+}
+
+// setSymbolicRef makes name (normally HEAD) point at target
+func setSymbolicRef(runEnv, name, target, msg string) error {
+	if err := checkRefName(target); err != nil {
+		return err
+	}
+	_, old, err := resolveRef(runEnv, name)
+	if err != nil {
+		return err
+	}
+	lock, err := acquireLock(refPath(runEnv, name))
+	if err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", name, err)
+	}
+	if err := lock.commit([]byte(symrefPrefix + target + "\n")); err != nil {
+		return err
+	}
+	_, hash, err := resolveRef(runEnv, target)
+	if err != nil || msg == "" || hash == "" {
+		return err
+	}
+	return appendReflog(runEnv, name, old, hash, msg)
+}
+
+// detachHead points HEAD straight at a commit instead of at a branch
+func detachHead(runEnv, hash, msg string) error {
+	_, old, err := resolveRef(runEnv, "HEAD")
+	if err != nil {
+		return err
+	}
+	lock, err := acquireLock(refPath(runEnv, "HEAD"))
+	if err != nil {
+		return fmt.Errorf("cannot lock ref 'HEAD': %w", err)
+	}
+	if err := lock.commit([]byte(hash + "\n")); err != nil {
+		return err
+	}
+	return appendReflog(runEnv, "HEAD", old, hash, msg)
+}
+
+// logsRefUpdates follows core.logAllRefUpdates, by default HEAD and branches get a
+// reflog, "always" logs every ref and false only appends to reflogs that already exist
+func logsRefUpdates(runEnv, name string) bool {
+	if _, err := os.Stat(reflogPath(runEnv, name)); err == nil {
+		return true
+	}
+	mode := "true"
+	if cfg, err := loadConfig(runEnv); err == nil {
+		if e, ok := cfg.get("core.logAllRefUpdates"); ok && !strings.EqualFold(e.value, "always") {
+			if on, err := parseConfigBool(e); err == nil && !on {
+				mode = "false"
+			}
+		} else if ok {
+			mode = "always"
+		}
+	}
+	switch mode {
+	case "always":
+		return true
+	case "false":
+		return false
+	}
+	return name == "HEAD" || strings.HasPrefix(name, "refs/heads/") || strings.HasPrefix(name, "refs/remotes/") || strings.HasPrefix(name, "refs/notes/")
+}
+
+// reflogIdentity is the committer, falling back to user@host like git does for reflogs
+func reflogIdentity(runEnv string) Signature {
+	if sig, err := identity(runEnv, "committer"); err == nil {
+		return sig
+	}
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return Signature{Name: name, Email: name + "@" + host, When: time.Now()}
+}
+
+// appendReflog adds `old new identity\tmsg` to logs/<name>
+func appendReflog(runEnv, name, old, hash, msg string) error {
+	if !logsRefUpdates(runEnv, name) {
+		return nil
+	}
+	if old == "" {
+		old = zeroHash
+	}
+	path := reflogPath(runEnv, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create reflog for %s: %w", name, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog for %s: %w", name, err)
+	}
+	defer f.Close()
+	msg = strings.Join(strings.Fields(msg), " ")
+	if _, err := fmt.Fprintf(f, "%s %s %s\t%s\n", old, hash, reflogIdentity(runEnv), msg); err != nil {
+		return fmt.Errorf("failed to write reflog for %s: %w", name, err)
+	}
+	return nil
+}
+
+// reflogEntry is one line of logs/<ref>, the update from old to new
+type reflogEntry struct {
+	old string
+	new string
+	msg string
+}
+
+// readReflog returns the reflog of a ref oldest first, a ref without a reflog has none
+func readReflog(runEnv, name string) ([]reflogEntry, error) {
+	raw, err := os.ReadFile(reflogPath(runEnv, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflog for %s: %w", name, err)
+	}
+	var entries []reflogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		head, msg, _ := strings.Cut(line, "\t")
+		if len(head) < 82 || head[40] != ' ' || !isHex(head[:40]) || !isHex(head[41:81]) {
+			return nil, fmt.Errorf("reflog for %s is corrupt: %q", name, line)
+		}
+		entries = append(entries, reflogEntry{old: head[:40], new: head[41:81], msg: msg})
+	}
+	return entries, nil
+}
+
+// updateRefCmd is `update-ref [-m <reason>] <ref> <new> [<old>]` and `update-ref -d <ref> [<old>]`
+func updateRefCmd(runEnv string, args []string) error {
+	msg, del := "", false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-m":
+			if i+1 >= len(args) {
+				return errors.New("update-ref err: -m needs a reason")
+			}
+			i++
+			msg = args[i]
+		case "-d":
+			del = true
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	store := newObjectStore(runEnv)
+	if del {
+		if len(rest) < 1 || len(rest) > 2 {
+			return errors.New("update-ref err: usage update-ref -d <ref> [<old>]")
+		}
+		rest = append(rest, "")
+		if err := deleteRef(runEnv, rest[0], rest[1]); err != nil {
+			return fmt.Errorf("update-ref err: %w", err)
+		}
+		return nil
+	}
+	if len(rest) < 2 || len(rest) > 3 {
+		return errors.New("update-ref err: usage update-ref <ref> <new> [<old>]")
+	}
+	rest = append(rest, "")
+	hash, err := resolveRevision(runEnv, rest[1])
+	if err != nil || !store.Has(hash) {
+		return fmt.Errorf("update-ref err: %s: not a valid SHA1", rest[1])
+	}
+	rest[1] = hash
+	if rest[2] != "" && rest[2] != zeroHash {
+		if rest[2], err = resolveRevision(runEnv, rest[2]); err != nil {
+			return fmt.Errorf("update-ref err: %w", err)
+		}
+	}
+	if err := updateRef(runEnv, rest[0], rest[1], rest[2], msg); err != nil {
+		return fmt.Errorf("update-ref err: %w", err)
+	}
+	return nil
+}
+
+// symbolicRef is `symbolic-ref [--short] <name>` to read and `symbolic-ref [-m <reason>] <name> <ref>` to set
+func symbolicRef(runEnv string, args []string) (string, error) {
+	msg, short := "", false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-m":
+			if i+1 >= len(args) {
+				return "", errors.New("symbolic-ref err: -m needs a reason")
+			}
+			i++
+			msg = args[i]
+		case "--short":
+			short = true
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	switch len(rest) {
+	case 1:
+		value, err := readRawRef(runEnv, rest[0])
+		if err != nil {
+			return "", fmt.Errorf("symbolic-ref err: %s: %w", rest[0], err)
+		}
+		if !strings.HasPrefix(value, symrefPrefix) {
+			return "", fmt.Errorf("symbolic-ref err: ref %s is not a symbolic ref", rest[0])
+		}
+		target := strings.TrimPrefix(value, symrefPrefix)
+		if short {
+			target = strings.TrimPrefix(target, "refs/heads/")
+		}
+		return target + "\n", nil
+	case 2:
+		if err := setSymbolicRef(runEnv, rest[0], rest[1], msg); err != nil {
+			return "", fmt.Errorf("symbolic-ref err: %w", err)
+		}
+		return "", nil
+	}
+	return "", errors.New("symbolic-ref err: usage symbolic-ref <name> [<ref>]")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRefs(t *testing.T) {
+	setupWorktree(t, nil)
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	t.Setenv("GIT_COMMITTER_DATE", "1661410769 +0400")
+	store := newObjectStore("test")
+	first, _ := store.Put("commit", []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nfirst\n"))
+	second, _ := store.Put("commit", []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nsecond\n"))
+
+	t.Run("should resolve HEAD to an unborn branch", func(t *testing.T) {
+		ref, hash, err := resolveRef("test", "HEAD")
+		if err != nil || ref != "refs/heads/main" || hash != "" {
+			t.Fatalf("got %s %q %v", ref, hash, err)
+		}
+	})
+
+	t.Run("should move the branch HEAD is on and log both", func(t *testing.T) {
+		if err := updateRef("test", "HEAD", first, zeroHash, "commit (initial): first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateRef("test", "HEAD", second, zeroHash, "again"); err == nil {
+			t.Fatal("expected the ref to already exist")
+		}
+		if err := updateRef("test", "HEAD", second, second, "stale"); err == nil {
+			t.Fatal("expected a stale old value to be refused")
+		}
+		if err := updateRef("test", "HEAD", second, first, "commit: second"); err != nil {
+			t.Fatal(err)
+		}
+		branch, hash, err := headCommit("test")
+		if err != nil || branch != "main" || hash != second {
+			t.Fatalf("got %s %s %v", branch, hash, err)
+		}
+		for _, log := range []string{"tmp/.git/logs/HEAD", "tmp/.git/logs/refs/heads/main"} {
+			raw, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := zeroHash + " " + first + " C O Mitter <committer@example.com> 1661410769 +0400\tcommit (initial): first\n" +
+				first + " " + second + " C O Mitter <committer@example.com> 1661410769 +0400\tcommit: second\n"
+			if string(raw) != want {
+				t.Fatalf("%s is %q, want %q", log, raw, want)
+			}
+		}
+	})
+
+	t.Run("should read packed refs and let loose ones win", func(t *testing.T) {
+		if err := os.WriteFile("tmp/.git/packed-refs", []byte("# pack-refs with: peeled fully-peeled sorted \n"+
+			first+" refs/heads/main\n"+first+" refs/tags/v1\n^"+second+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		refs, err := listRefs("test", "refs/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs) != 2 || refs[0] != (refEntry{"refs/heads/main", second}) || refs[1] != (refEntry{"refs/tags/v1", first}) {
+			t.Fatalf("unexpected refs %v", refs)
+		}
+	})
+
+	t.Run("should delete from both loose and packed refs", func(t *testing.T) {
+		if err := deleteRef("test", "refs/heads/main", first); err == nil {
+			t.Fatal("expected the old value check to fail")
+		}
+		if err := deleteRef("test", "refs/heads/main", ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, hash, _ := resolveRef("test", "refs/heads/main"); hash != "" {
+			t.Fatalf("packed copy survived: %s", hash)
+		}
+		raw, _ := os.ReadFile("tmp/.git/packed-refs")
+		if strings.Contains(string(raw), "refs/heads/main") || !strings.Contains(string(raw), "refs/tags/v1") {
+			t.Fatalf("unexpected packed-refs %q", raw)
+		}
+	})
+
+	t.Run("should keep the peeled lines of the refs left packed", func(t *testing.T) {
+		tag, _ := store.Put("tag", []byte("object "+first+"\ntype commit\ntag v2\ntagger C O Mitter <committer@example.com> 1661410769 +0400\n\nv2\n"))
+		packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+			first + " refs/heads/gone\n" + tag + " refs/tags/v2\n^" + first + "\n"
+		if err := os.WriteFile("tmp/.git/packed-refs", []byte(packed), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := deleteRef("test", "refs/heads/gone", ""); err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := os.ReadFile("tmp/.git/packed-refs")
+		if want := "# pack-refs with: peeled fully-peeled sorted \n" + tag + " refs/tags/v2\n^" + first + "\n"; string(raw) != want {
+			t.Fatalf("packed-refs is %q, want %q", raw, want)
+		}
+	})
+
+	t.Run("should switch HEAD to another branch", func(t *testing.T) {
+		if err := updateRef("test", "refs/heads/feature/x", first, "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := setSymbolicRef("test", "HEAD", "refs/heads/feature/x", "checkout"); err != nil {
+			t.Fatal(err)
+		}
+		out, err := symbolicRef("test", []string{"--short", "HEAD"})
+		if err != nil || out != "feature/x\n" {
+			t.Fatalf("got %q %v", out, err)
+		}
+		if err := checkRefName("refs/heads/bad..name"); err == nil {
+			t.Fatal("expected invalid ref name")
+		}
+	})
+}
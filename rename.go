@@ -0,0 +1,381 @@
+package main
+
+import (
+	"path"
+	"sort"
+)
+
+// similarity scores are fixed point like git's, maxScore is 100%
+const (
+	maxScore           = 60000
+	defaultRenameScore = maxScore / 2
+	candidatesPerDst   = 4
+	spanHashBase       = 107927
+)
+
+// renameOptions is what -M and -C ask for. copies also looks for sources among changed
+// paths, harder among every path of the old tree. score is the least similarity that counts.
+type renameOptions struct {
+	detect, copies, harder bool
+	score                  int
+}
+
+// parseRenameScore reads the number after -M or -C like git does: the digits are a
+// fraction, so 5 and 50% are both half and 05 is 5%. ok is false for anything else.
+func parseRenameScore(arg string) (int, bool) {
+	num, scale, dot := 0, 1, false
+	i := 0
+	for ; i < len(arg); i++ {
+		c := arg[i]
+		if !dot && c == '.' {
+			scale, dot = 1, true
+		} else if c == '%' {
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+			i++
+			break
+		} else if c >= '0' && c <= '9' {
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		} else {
+			break
+		}
+	}
+	if i != len(arg) {
+		return 0, false
+	}
+	if num >= scale {
+		return maxScore, true
+	}
+	return maxScore * num / scale, true
+}
+
+// spanHashes cuts data into spans that end at a newline or after 64 bytes and counts the
+// bytes of each span by its hash. the CR of a CRLF is not counted in text and like in git
+// an unterminated last line is not counted at all.
+func spanHashes(data []byte) map[uint32]int {
+	counts := map[uint32]int{}
+	text := !isBinary(data)
+	var accum1, accum2 uint32
+	n := 0
+	for i, b := range data {
+		if text && b == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+		c, old := uint32(b), accum1
+		accum1 = accum1<<7 ^ accum2>>25
+		accum2 = accum2<<7 ^ old>>25
+		accum1 += c
+		if n++; n < 64 && c != '\n' {
+			continue
+		}
+		counts[(accum1+accum2*0x61)%spanHashBase] += n
+		n, accum1, accum2 = 0, 0, 0
+	}
+	return counts
+}
+
+// renameSource is a path of the old tree something may have been renamed or copied from.
+// used counts the pairs taking it, a source that stays counts itself.
+type renameSource struct {
+	path    string
+	file    *diffFile
+	deleted bool
+	used    int
+}
+
+// renameCandidate is a possible pairing of the dst-th creation with the src-th source
+type renameCandidate struct {
+	dst, src         int
+	score, nameScore int
+}
+
+// better orders candidates the way they are tried, most similar first and among equals
+// the ones that kept their file name
+func (c renameCandidate) better(o renameCandidate) bool {
+	if c.score != o.score {
+		return c.score > o.score
+	}
+	return c.nameScore > o.nameScore
+}
+
+// renamer finds where created files came from, blobs and their span counts are cached
+// since every creation is compared with every source
+type renamer struct {
+	store *ObjectStore
+	blobs map[string][]byte
+	spans map[string]map[uint32]int
+}
+
+func (r *renamer) blob(hash string) ([]byte, error) {
+	if data, ok := r.blobs[hash]; ok {
+		return data, nil
+	}
+	_, data, err := r.store.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	r.blobs[hash] = data
+	return data, nil
+}
+
+// similarity is how much of the larger file is copied from src out of maxScore, files whose
+// sizes differ too much to reach minScore are not compared at all. only regular files
+// have a similarity, everything else is only ever an exact rename.
+func (r *renamer) similarity(src, dst *diffFile, minScore int) (int, error) {
+	if !isRegular(src.mode) || !isRegular(dst.mode) {
+		return 0, nil
+	}
+	a, err := r.blob(src.hash)
+	if err != nil {
+		return 0, err
+	}
+	b, err := r.blob(dst.hash)
+	if err != nil {
+		return 0, err
+	}
+	maxSize, baseSize := max(len(a), len(b)), min(len(a), len(b))
+	if maxSize*(maxScore-minScore) < (maxSize-baseSize)*maxScore || len(b) == 0 {
+		return 0, nil
+	}
+	spans := func(hash string, data []byte) map[uint32]int {
+		if counts, ok := r.spans[hash]; ok {
+			return counts
+		}
+		r.spans[hash] = spanHashes(data)
+		return r.spans[hash]
+	}
+	dstSpans, copied := spans(dst.hash, b), 0
+	for h, n := range spans(src.hash, a) {
+		copied += min(n, dstSpans[h])
+	}
+	return copied * maxScore / maxSize, nil
+}
+
+func isRegular(mode uint32) bool {
+	return mode == modeFile || mode == modeExecutable
+}
+
+// sameBasename is 1 when both paths end in the same file name, it breaks ties between sources
+func sameBasename(a, b string) int {
+	if path.Base(a) == path.Base(b) {
+		return 1
+	}
+	return 0
+}
+
+// detectRenames turns creations into renames and copies the way git's diffcore-rename
+// does. identical content is paired first, then for plain renames files that kept their
+// unique name need a similarity halfway between the minimum and 100%, then the rest go
+// to their most similar source. a deleted source paired once is a rename and its deletion
+// goes away, any other pair is a copy. old is the old tree, the sources for harder.
+func detectRenames(store *ObjectStore, changes []*treeChange, old map[string]*diffFile, opts renameOptions) ([]*treeChange, error) {
+	var sources []*renameSource
+	var dsts []*treeChange
+	deleted := map[string]bool{}
+	for _, c := range changes {
+		if c.status == 'D' {
+			deleted[c.path] = true
+		}
+	}
+	if opts.harder {
+		paths := make([]string, 0, len(old))
+		for p := range old {
+			paths = append(paths, p)
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			return treeOrderKey(paths[i], old[paths[i]].mode) < treeOrderKey(paths[j], old[paths[j]].mode)
+		})
+		for _, p := range paths {
+			sources = append(sources, &renameSource{path: p, file: old[p], deleted: deleted[p]})
+		}
+	}
+	for _, c := range changes {
+		switch {
+		case c.status == 'A':
+			dsts = append(dsts, c)
+		case opts.harder:
+		case c.status == 'D' || opts.copies:
+			sources = append(sources, &renameSource{path: c.path, file: c.a, deleted: c.status == 'D'})
+		}
+	}
+	if len(dsts) == 0 || len(sources) == 0 {
+		return changes, nil
+	}
+	for _, s := range sources {
+		if !s.deleted {
+			s.used = 1
+		}
+	}
+
+	paired := make([]*renameSource, len(dsts))
+	scores := make([]int, len(dsts))
+	pair := func(dst int, src *renameSource, score int) {
+		paired[dst], scores[dst] = src, score
+		src.used++
+	}
+	available := func(s *renameSource) bool {
+		return opts.copies || s.used == 0
+	}
+
+	// identical content, sources nobody took yet and with the same file name win
+	for i, d := range dsts {
+		var best *renameSource
+		bestScore := -1
+		for _, s := range sources {
+			if s.file.hash != d.b.hash || !available(s) {
+				continue
+			}
+			if (!isRegular(s.file.mode) || !isRegular(d.b.mode)) && s.file.mode != d.b.mode {
+				continue
+			}
+			score := sameBasename(s.path, d.path)
+			if s.used == 0 {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = s, score
+			}
+		}
+		if best != nil {
+			pair(i, best, maxScore)
+		}
+	}
+
+	r := &renamer{store: store, blobs: map[string][]byte{}, spans: map[string]map[uint32]int{}}
+	if !opts.copies {
+		// a file name found once on both sides only has to be fairly similar
+		unique := func(names []string) map[string]int {
+			at := map[string]int{}
+			for i, n := range names {
+				if _, ok := at[n]; ok {
+					at[n] = -1
+				} else {
+					at[n] = i
+				}
+			}
+			return at
+		}
+		var srcNames, dstNames []string
+		for _, s := range sources {
+			name := path.Base(s.path)
+			if s.used > 0 {
+				name = ""
+			}
+			srcNames = append(srcNames, name)
+		}
+		for i, d := range dsts {
+			name := path.Base(d.path)
+			if paired[i] != nil {
+				name = "\x00"
+			}
+			dstNames = append(dstNames, name)
+		}
+		srcAt, dstAt := unique(srcNames), unique(dstNames)
+		minScore := opts.score + (maxScore-opts.score)/2
+		for i, s := range sources {
+			if srcNames[i] == "" || srcAt[srcNames[i]] != i {
+				continue
+			}
+			j, ok := dstAt[srcNames[i]]
+			if !ok || j < 0 || paired[j] != nil {
+				continue
+			}
+			score, err := r.similarity(s.file, dsts[j].b, minScore)
+			if err != nil {
+				return nil, err
+			}
+			if score >= minScore {
+				pair(j, s, score)
+			}
+		}
+	}
+
+	// everything else keeps its best few sources, the most similar pairs are taken first
+	var candidates []renameCandidate
+	for i, d := range dsts {
+		if paired[i] != nil {
+			continue
+		}
+		var best []renameCandidate
+		for j, s := range sources {
+			if !available(s) {
+				continue
+			}
+			score, err := r.similarity(s.file, d.b, opts.score)
+			if err != nil {
+				return nil, err
+			}
+			if score < opts.score {
+				continue
+			}
+			c := renameCandidate{dst: i, src: j, score: score, nameScore: sameBasename(s.path, d.path)}
+			if len(best) < candidatesPerDst {
+				best = append(best, c)
+				continue
+			}
+			worst := 0
+			for k := range best {
+				if best[worst].better(best[k]) {
+					worst = k
+				}
+			}
+			if c.better(best[worst]) {
+				best[worst] = c
+			}
+		}
+		candidates = append(candidates, best...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].better(candidates[j]) })
+	take := func(copies bool) {
+		for _, c := range candidates {
+			if paired[c.dst] != nil || (!copies && sources[c.src].used > 0) {
+				continue
+			}
+			pair(c.dst, sources[c.src], c.score)
+		}
+	}
+	take(false)
+	if opts.copies {
+		take(true)
+	}
+
+	bySource := map[string]*renameSource{}
+	for _, s := range sources {
+		bySource[s.path] = s
+	}
+	at := map[*treeChange]int{}
+	for i, d := range dsts {
+		at[d] = i
+	}
+	var out []*treeChange
+	for _, c := range changes {
+		if i, ok := at[c]; ok && paired[i] != nil {
+			s := paired[i]
+			out = append(out, &treeChange{path: c.path, from: s.path, score: scores[i], a: s.file, b: c.b})
+			continue
+		}
+		if s := bySource[c.path]; c.status == 'D' && s != nil && s.deleted && s.used > 0 {
+			continue
+		}
+		out = append(out, c)
+	}
+	// the last pair to take a deleted source is its rename, all others are copies
+	for _, c := range out {
+		if c.from == "" {
+			continue
+		}
+		s := bySource[c.from]
+		s.used--
+		c.status = 'R'
+		if s.used > 0 {
+			c.status = 'C'
+		}
+	}
+	return out, nil
+}
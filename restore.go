@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// restore is `restore [--source=<rev>] [--staged] [--worktree] [--] <pathspec>...`. it copies
+// the selected files from the source into the work tree (the default) and/or the index.
+// the source is the index when only the work tree is restored and HEAD otherwise. tracked
+// paths the source does not have are deleted from the restored places, so restoring a
+// directory makes it look exactly like it does in the source. HEAD does not move.
+func restore(runEnv string, args []string) error {
+	source, staged, worktree := "", false, false
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-s" || arg == "--source":
+			if i+1 >= len(args) {
+				return fmt.Errorf("restore err: %s needs a revision", arg)
+			}
+			i++
+			source = args[i]
+		case strings.HasPrefix(arg, "--source="):
+			source = strings.TrimPrefix(arg, "--source=")
+		case arg == "-S" || arg == "--staged":
+			staged = true
+		case arg == "-W" || arg == "--worktree":
+			worktree = true
+		case arg == "-q" || arg == "--quiet":
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("restore err: unknown option %s", arg)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		return errors.New("restore err: you must specify path(s) to restore")
+	}
+	if !staged {
+		worktree = true
+	}
+	fromIndex := source == "" && !staged
+	if source == "" {
+		source = "HEAD"
+	}
+
+	specs, err := parsePathspecs(runEnv, paths)
+	if err != nil {
+		return fmt.Errorf("restore err: %w", err)
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return fmt.Errorf("restore err: %w", err)
+	}
+	store := newObjectStore(runEnv)
+	matched := make([]bool, len(specs))
+	mark := func(p string) bool {
+		hit := false
+		for i, s := range specs {
+			if s.matches(p) {
+				matched[i] = true
+				hit = true
+			}
+		}
+		return hit
+	}
+
+	files := map[string]treeEntry{}
+	if fromIndex {
+		for _, e := range idx.Entries {
+			if !mark(e.Path) {
+				continue
+			}
+			if e.stage() != 0 {
+				return fmt.Errorf("restore err: path '%s' is unmerged", e.Path)
+			}
+			files[e.Path] = treeEntry{mode: e.Mode, name: e.Path, hash: e.Hash}
+		}
+	} else {
+		hash, err := resolveRevision(runEnv, source)
+		if err != nil {
+			return fmt.Errorf("restore err: could not resolve %s", source)
+		}
+		tree, err := peelObject(store, hash, "tree")
+		if err != nil {
+			return fmt.Errorf("restore err: could not resolve %s", source)
+		}
+		err = walkTree(store, tree, "", func(e treeEntry, p string) (bool, error) {
+			if !e.isTree() && mark(p) {
+				files[p] = treeEntry{mode: e.mode, name: p, hash: e.hash}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return fmt.Errorf("restore err: %w", err)
+		}
+	}
+
+	// no overlay: tracked paths the source lacks go away
+	var removals []string
+	if !fromIndex {
+		for _, e := range idx.Entries {
+			if _, ok := files[e.Path]; !ok && mark(e.Path) && (len(removals) == 0 || removals[len(removals)-1] != e.Path) {
+				removals = append(removals, e.Path)
+			}
+		}
+	}
+	for i, s := range specs {
+		if !matched[i] {
+			return fmt.Errorf("restore err: pathspec '%s' did not match any file(s) known to git", s.arg)
+		}
+	}
+
+	for i := len(removals) - 1; i >= 0; i-- {
+		p := removals[i]
+		if worktree {
+			if err := os.Remove(worktreePath(runEnv, p)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("restore err: failed to remove %s: %w", p, err)
+			}
+			removeEmptyParents(runEnv, p)
+		}
+		if staged {
+			idx.remove(p)
+		}
+	}
+	sorted := make([]string, 0, len(files))
+	for p := range files {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	for _, p := range sorted {
+		e := files[p]
+		if !worktree {
+			// the work tree keeps its file, the size alone makes status hash it again
+			size := 0
+			if e.mode != modeGitlink {
+				if _, size, err = store.Stat(e.hash); err != nil {
+					return fmt.Errorf("restore err: %s: %w", p, err)
+				}
+			}
+			idx.set(&IndexEntry{Path: p, Hash: e.hash, Mode: e.mode, Size: uint32(size)})
+			continue
+		}
+		info, err := checkoutEntry(runEnv, store, e)
+		if err != nil {
+			return fmt.Errorf("restore err: failed to restore %s: %w", p, err)
+		}
+		// the index gets fresh stat data whenever it holds what was just written
+		if ie := idx.find(p); staged || (ie != nil && ie.Hash == e.hash && ie.Mode == e.mode) {
+			idx.set(newIndexEntry(p, info, e.hash))
+		}
+	}
+	if err := idx.write(runEnv); err != nil {
+		return fmt.Errorf("restore err: %w", err)
+	}
+	return nil
+}
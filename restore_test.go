@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	setupWorktree(t, map[string]string{"a.txt": "a1\n"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	commitAll := func(msg string) {
+		t.Helper()
+		if err := add("test", []string{"-A"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := commit("test", []string{"-m", msg}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commitAll("first")
+	writeWorktree(t, "a.txt", "a2\n")
+	writeWorktree(t, "d/f.txt", "f\n")
+	commitAll("second")
+	_, head, _ := headCommit("test")
+	read := func(name string) string {
+		t.Helper()
+		raw, err := os.ReadFile("tmp/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	short := func() string {
+		t.Helper()
+		st, err := collectStatus("test", "no")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sb strings.Builder
+		for _, e := range st.entries {
+			sb.WriteString(string([]byte{e.staged, e.unstaged}) + " " + e.path + "\n")
+		}
+		return sb.String()
+	}
+
+	t.Run("should restore the work tree from the index", func(t *testing.T) {
+		writeWorktree(t, "a.txt", "staged\n")
+		if err := add("test", []string{"tmp/a.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		writeWorktree(t, "a.txt", "scratch\n")
+		if err := restore("test", []string{"tmp/a.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := read("a.txt"); got != "staged\n" {
+			t.Fatalf("got %q", got)
+		}
+		if got := short(); got != "M  a.txt\n" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should unstage with --staged", func(t *testing.T) {
+		if err := restore("test", []string{"--staged", "tmp/a.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := short(); got != " M a.txt\n" {
+			t.Fatalf("got %q", got)
+		}
+		if got := read("a.txt"); got != "staged\n" {
+			t.Fatalf("the work tree should be left alone, got %q", got)
+		}
+	})
+
+	t.Run("should bring back an older version without moving HEAD", func(t *testing.T) {
+		if err := restore("test", []string{"--source=HEAD~1", "--staged", "--worktree", "tmp"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := read("a.txt"); got != "a1\n" {
+			t.Fatalf("got %q", got)
+		}
+		if _, err := os.Stat("tmp/d"); !os.IsNotExist(err) {
+			t.Fatalf("d/f.txt is not in HEAD~1 and should be gone with its directory, got %v", err)
+		}
+		if got := short(); got != "M  a.txt\nD  d/f.txt\n" {
+			t.Fatalf("got %q", got)
+		}
+		if _, now, _ := headCommit("test"); now != head {
+			t.Fatalf("HEAD moved to %s", now)
+		}
+		if err := restore("test", []string{"-s", "HEAD", "-S", "-W", "tmp"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := short(); got != "" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("should reject pathspecs that match nothing", func(t *testing.T) {
+		err := restore("test", []string{"tmp/a.txt", "tmp/nope"})
+		if err == nil || !strings.Contains(err.Error(), "pathspec 'tmp/nope' did not match") {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := restore("test", []string{"--staged"}); err == nil {
+			t.Fatal("expected missing pathspec error")
+		}
+	})
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// minAbbrev is the shortest prefix accepted as an abbreviated object name
+const minAbbrev = 4
+
+// errAmbiguousObject is returned for an abbreviated hash that matches several objects
+var errAmbiguousObject = errors.New("short object ID is ambiguous")
+
+// dwimRefRules are the places a short name like "main" or "v1" is looked up, in git's order
+var dwimRefRules = []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s", "refs/remotes/%s", "refs/remotes/%s/HEAD"}
+
+func unknownRevision(rev string) error {
+	return fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", rev)
+}
+
+// isRootRef reports whether name is a ref outside refs/ like HEAD or ORIG_HEAD
+func isRootRef(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// dwimRef expands a short name to the first existing ref it can mean and that ref's
+// commit, the ref is empty when nothing matches
+func dwimRef(runEnv, name string) (string, string, error) {
+	for _, rule := range dwimRefRules {
+		ref := fmt.Sprintf(rule, name)
+		if !isRootRef(ref) && checkRefName(ref) != nil {
+			continue
+		}
+		_, hash, err := resolveRef(runEnv, ref)
+		if err != nil {
+			return "", "", err
+		}
+		if hash != "" {
+			return ref, hash, nil
+		}
+	}
+	return "", "", nil
+}
+
+// resolveRevision turns a revision into an object hash. it understands full and unique
+// abbreviated hashes, ref names, `@` for HEAD, `<ref>@{n}` reflog entries, `@{-n}` for
+// the branch checked out n switches ago, `~n` and `^n` ancestry, `^{type}` peeling,
+// `<rev>:<path>` for an object in a tree and `:[<stage>:]<path>` for one in the index
+func resolveRevision(runEnv, rev string) (string, error) {
+	store := newObjectStore(runEnv)
+	if strings.HasPrefix(rev, ":") {
+		return resolveIndexPath(runEnv, rev[1:])
+	}
+	if treeish, path, ok := strings.Cut(rev, ":"); ok && treeish != "" {
+		hash, err := resolveRevision(runEnv, treeish)
+		if err != nil {
+			return "", err
+		}
+		tree, err := peelObject(store, hash, "tree")
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+			if path, err = toRepoPath(runEnv, path); err != nil {
+				return "", err
+			}
+		}
+		return lookupTreePath(store, tree, treeish, path)
+	}
+
+	base, ops := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		base, ops = rev[:i], rev[i:]
+	}
+	hash, err := resolveName(runEnv, store, base, ops != "")
+	if err != nil {
+		return "", err
+	}
+	for ops != "" {
+		op := ops[0]
+		ops = ops[1:]
+		if op == '^' && strings.HasPrefix(ops, "{") {
+			end := strings.IndexByte(ops, '}')
+			if end < 0 {
+				return "", unknownRevision(rev)
+			}
+			if hash, err = peelObject(store, hash, ops[1:end]); err != nil {
+				return "", err
+			}
+			ops = ops[end+1:]
+			continue
+		}
+		digits := len(ops) - len(strings.TrimLeft(ops, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(ops[:digits]); err != nil {
+				return "", unknownRevision(rev)
+			}
+			ops = ops[digits:]
+		}
+		if hash, err = peelObject(store, hash, "commit"); err != nil {
+			return "", err
+		}
+		if op == '^' && n == 0 {
+			continue
+		}
+		steps, parent := n, 0
+		if op == '^' {
+			steps, parent = 1, n-1
+		}
+		for range steps {
+			c, err := readCommit(store, hash)
+			if err != nil {
+				return "", err
+			}
+			if parent >= len(c.Parents) {
+				return "", unknownRevision(rev)
+			}
+			hash = c.Parents[parent]
+		}
+	}
+	return hash, nil
+}
+
+// resolveCommit resolves a revision and peels it to a commit
+func resolveCommit(runEnv, rev string) (string, error) {
+	hash, err := resolveRevision(runEnv, rev)
+	if err != nil {
+		return "", err
+	}
+	return peelObject(newObjectStore(runEnv), hash, "commit")
+}
+
+// resolveName resolves the part of a revision before any ~ or ^. commitish says a
+// commit is needed next, which settles abbreviations that also match other objects.
+func resolveName(runEnv string, store *ObjectStore, name string, commitish bool) (string, error) {
+	if i := strings.Index(name, "@{"); i >= 0 && strings.HasSuffix(name, "}") {
+		return resolveReflogSelector(runEnv, store, name[:i], name[i+2:len(name)-1])
+	}
+	if name == "@" {
+		name = "HEAD"
+	}
+	if len(name) == 40 && isHex(name) {
+		return strings.ToLower(name), nil
+	}
+	if name != "" {
+		ref, hash, err := dwimRef(runEnv, name)
+		if err != nil {
+			return "", err
+		}
+		if ref != "" {
+			return hash, nil
+		}
+	}
+	if len(name) < minAbbrev || !isHex(name) {
+		return "", unknownRevision(name)
+	}
+	candidates, err := store.Expand(strings.ToLower(name))
+	if err != nil {
+		return "", err
+	}
+	if commitish && len(candidates) > 1 {
+		var commits []string
+		for _, hash := range candidates {
+			if _, err := peelObject(store, hash, "commit"); err == nil {
+				commits = append(commits, hash)
+			}
+		}
+		if len(commits) > 0 {
+			candidates = commits
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", unknownRevision(name)
+	case 1:
+		return candidates[0], nil
+	}
+	var sb strings.Builder
+	for _, hash := range candidates {
+		kind, _, _ := store.Stat(hash)
+		fmt.Fprintf(&sb, "\n  %s %s", hash, kind)
+	}
+	return "", fmt.Errorf("%w: %s, the candidates are:%s", errAmbiguousObject, name, sb.String())
+}
+
+// resolveReflogSelector handles `<ref>@{n}`, the value ref had n updates ago, and
+// `@{-n}`, the branch that was checked out before the nth last switch
+func resolveReflogSelector(runEnv string, store *ObjectStore, name, selector string) (string, error) {
+	n, err := strconv.Atoi(selector)
+	if err != nil {
+		return "", fmt.Errorf("reflog selector @{%s} is not supported", selector)
+	}
+	if n < 0 {
+		if name != "" {
+			return "", unknownRevision(name + "@{" + selector + "}")
+		}
+		prev, err := previousBranch(runEnv, -n)
+		if err != nil {
+			return "", err
+		}
+		return resolveName(runEnv, store, prev, false)
+	}
+
+	ref := ""
+	switch name {
+	case "":
+		// a bare @{n} is the current branch, or HEAD itself when detached
+		if ref, _, err = resolveRef(runEnv, "HEAD"); err != nil {
+			return "", err
+		}
+	case "@":
+		ref = "HEAD"
+	default:
+		if ref, _, err = dwimRef(runEnv, name); err != nil {
+			return "", err
+		}
+		if ref == "" {
+			return "", unknownRevision(name)
+		}
+	}
+	entries, err := readReflog(runEnv, ref)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 && len(entries) == 0 {
+		_, hash, err := resolveRef(runEnv, ref)
+		if err != nil || hash == "" {
+			return "", unknownRevision(ref)
+		}
+		return hash, nil
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("log for '%s' only has %d entries", strings.TrimPrefix(ref, "refs/heads/"), len(entries))
+	}
+	return entries[len(entries)-1-n].new, nil
+}
+
+// previousBranch returns what was checked out before the nth last `checkout: moving
+// from <a> to <b>` entry in HEAD's reflog
+func previousBranch(runEnv string, n int) (string, error) {
+	entries, err := readReflog(runEnv, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		from, ok := strings.CutPrefix(entries[i].msg, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		if n--; n == 0 {
+			from, _, _ = strings.Cut(from, " to ")
+			return from, nil
+		}
+	}
+	return "", errors.New("no previous branch in the reflog")
+}
+
+// peelObject dereferences tags, and commits to their tree, until it reaches an object
+// of the wanted type. "" only peels tags and "object" checks that the object exists.
+func peelObject(store *ObjectStore, hash, want string) (string, error) {
+	switch want {
+	case "", "object", "commit", "tree", "blob", "tag":
+	default:
+		return "", fmt.Errorf("%s^{%s}: unknown object type", hash, want)
+	}
+	for {
+		kind, payload, err := store.Get(hash)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", hash, err)
+		}
+		switch {
+		case kind == want || want == "object":
+			return hash, nil
+		case kind == "tag":
+			target, _, _ := strings.Cut(string(payload), "\n")
+			target, ok := strings.CutPrefix(target, "object ")
+			if !ok || len(target) != 40 || !isHex(target) {
+				return "", fmt.Errorf("tag %s is corrupt", hash)
+			}
+			hash = target
+		case want == "":
+			return hash, nil
+		case kind == "commit" && want == "tree":
+			c, err := parseCommit(payload)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", hash, err)
+			}
+			hash = c.Tree
+		default:
+			return "", fmt.Errorf("%s^{%s}: expected %s type, but the object dereferences to %s type", hash, want, want, kind)
+		}
+	}
+}
+
+// lookupTreePath walks a slash separated path down from a tree, "" is the tree itself
+func lookupTreePath(store *ObjectStore, tree, treeish, path string) (string, error) {
+	hash := tree
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		entries, err := readTree(store, hash)
+		if err != nil {
+			return "", fmt.Errorf("path '%s' does not exist in '%s'", path, treeish)
+		}
+		found := ""
+		for _, e := range entries {
+			if e.name == name {
+				found = e.hash
+				break
+			}
+		}
+		if found == "" {
+			return "", fmt.Errorf("path '%s' does not exist in '%s'", path, treeish)
+		}
+		hash = found
+	}
+	return hash, nil
+}
+
+// resolveIndexPath finds the blob staged for `[<stage>:]<path>`, stage 0 by default
+func resolveIndexPath(runEnv, spec string) (string, error) {
+	stage, path := 0, spec
+	if len(spec) > 2 && spec[1] == ':' && spec[0] >= '0' && spec[0] <= '3' {
+		stage, path = int(spec[0]-'0'), spec[2:]
+	}
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		var err error
+		if path, err = toRepoPath(runEnv, path); err != nil {
+			return "", err
+		}
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return "", err
+	}
+	inIndex := false
+	for _, e := range idx.Entries {
+		if e.Path != path {
+			continue
+		}
+		if e.stage() == stage {
+			return e.Hash, nil
+		}
+		inIndex = true
+	}
+	if inIndex {
+		return "", fmt.Errorf("path '%s' is in the index, but not at stage %d", path, stage)
+	}
+	return "", fmt.Errorf("path '%s' does not exist in the index", path)
+}
+
+// abbrev returns the shortest prefix of hash, at least min long, that names no other object
+func abbrev(store *ObjectStore, hash string, min int) string {
+	for n := max(min, minAbbrev); n < len(hash); n++ {
+		if matches, err := store.Expand(hash[:n]); err == nil && len(matches) <= 1 {
+			return hash[:n]
+		}
+	}
+	return hash
+}
+
+// shortRefName drops the prefix git leaves out when it prints a ref, refs/heads/main is main
+func shortRefName(ref string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if short, ok := strings.CutPrefix(ref, prefix); ok {
+			return short
+		}
+	}
+	return strings.TrimPrefix(ref, "refs/")
+}
+
+// revParse is `rev-parse [--verify] [--short[=<n>]] [--abbrev-ref] [--symbolic-full-name] <rev>...`.
+// every revision is printed as a full hash, `A..B` as B and ^A, and --verify insists on
+// exactly one revision naming an existing object. like git the name options only apply to
+// the revisions after them.
+func revParse(runEnv string, args []string) (string, error) {
+	verify, short, names := false, 0, ""
+	type revArg struct{ rev, names string }
+	var revs []revArg
+	for _, arg := range args {
+		switch {
+		case arg == "--verify":
+			verify = true
+		case arg == "--short":
+			verify, short = true, 7
+		case strings.HasPrefix(arg, "--short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--short="))
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("rev-parse err: invalid length in %s", arg)
+			}
+			verify, short = true, max(n, minAbbrev)
+		case arg == "--abbrev-ref" || arg == "--symbolic-full-name":
+			names = arg
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return "", fmt.Errorf("rev-parse err: unknown option %s", arg)
+		default:
+			revs = append(revs, revArg{arg, names})
+		}
+	}
+	if verify && len(revs) != 1 {
+		return "", errors.New("rev-parse err: needed a single revision")
+	}
+
+	store := newObjectStore(runEnv)
+	format := func(rev, names string) (string, error) {
+		if names != "" {
+			name := rev
+			if name == "@" {
+				name = "HEAD"
+			}
+			if ref, _, err := dwimRef(runEnv, name); err == nil && ref != "" {
+				if ref, _, err = resolveRef(runEnv, ref); err != nil {
+					return "", err
+				}
+				if names == "--abbrev-ref" {
+					ref = shortRefName(ref)
+				}
+				return ref, nil
+			}
+		}
+		hash, err := resolveRevision(runEnv, rev)
+		if err != nil {
+			return "", err
+		}
+		if verify && !store.Has(hash) {
+			return "", errors.New("needed a single revision")
+		}
+		if short > 0 {
+			hash = abbrev(store, hash, short)
+		}
+		return hash, nil
+	}
+
+	var sb strings.Builder
+	for _, arg := range revs {
+		rev := arg.rev
+		var lines []string
+		switch {
+		case !verify && strings.Contains(rev, "..."):
+			return "", fmt.Errorf("rev-parse err: symmetric difference %s is not supported", rev)
+		case !verify && strings.Contains(rev, ".."):
+			from, to, _ := strings.Cut(rev, "..")
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			a, err := format(from, arg.names)
+			if err != nil {
+				return "", fmt.Errorf("rev-parse err: %w", err)
+			}
+			b, err := format(to, arg.names)
+			if err != nil {
+				return "", fmt.Errorf("rev-parse err: %w", err)
+			}
+			lines = []string{b, "^" + a}
+		case !verify && strings.HasPrefix(rev, "^") && len(rev) > 1:
+			hash, err := format(rev[1:], arg.names)
+			if err != nil {
+				return "", fmt.Errorf("rev-parse err: %w", err)
+			}
+			lines = []string{"^" + hash}
+		default:
+			hash, err := format(rev, arg.names)
+			if err != nil {
+				return "", fmt.Errorf("rev-parse err: %w", err)
+			}
+			lines = []string{hash}
+		}
+		for _, line := range lines {
+			sb.WriteString(line + "\n")
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResolveRevision(t *testing.T) {
+	setupWorktree(t, map[string]string{"d/f.txt": "f\n", "g.txt": "g\n"})
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	store := newObjectStore("test")
+	if err := add("test", []string{"-A"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit("test", []string{"-m", "root"}, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	_, root, _ := headCommit("test")
+	if _, err := commit("test", []string{"--allow-empty", "-m", "second"}, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	_, second, _ := headCommit("test")
+	tree, _ := writeTree("test", "")
+	side, err := commitTree("test", []string{tree, "-p", root, "-m", "side"}, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge, err := commitTree("test", []string{"HEAD^{tree}", "-p", "HEAD", "-p", side[:10], "-m", "merge"}, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateRef("test", "HEAD", merge, second, "merge"); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := flattenTree(store, tree)
+	resolve := func(rev string) string {
+		t.Helper()
+		hash, err := resolveRevision("test", rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	t.Run("should follow ancestry operators", func(t *testing.T) {
+		for rev, want := range map[string]string{
+			"HEAD": merge, "@": merge, "main~1": second, "HEAD~2": root, "HEAD^2": side,
+			"HEAD^2^": root, "HEAD^^": root, "HEAD^0": merge, merge[:7] + "~1": second,
+		} {
+			if got := resolve(rev); got != want {
+				t.Fatalf("%s resolved to %s, want %s", rev, got, want)
+			}
+		}
+		for _, bad := range []string{"HEAD~3", "HEAD^3", "nope", "abc", ""} {
+			if _, err := resolveRevision("test", bad); err == nil {
+				t.Fatalf("expected %q to fail", bad)
+			}
+		}
+	})
+
+	t.Run("should peel and look up paths", func(t *testing.T) {
+		if got := resolve("HEAD^{tree}"); got != tree {
+			t.Fatalf("got %s, want %s", got, tree)
+		}
+		if got := resolve("HEAD:d/f.txt"); got != dir["d/f.txt"].hash {
+			t.Fatalf("got %s", got)
+		}
+		if got := resolve(":g.txt"); got != dir["g.txt"].hash {
+			t.Fatalf("got %s", got)
+		}
+		if got := resolve("HEAD:"); got != tree {
+			t.Fatalf("got %s", got)
+		}
+		if _, err := resolveRevision("test", "HEAD^{tree}^{commit}"); err == nil {
+			t.Fatal("a tree should not peel to a commit")
+		}
+		if _, err := resolveRevision("test", "HEAD:missing"); err == nil {
+			t.Fatal("expected missing path error")
+		}
+	})
+
+	t.Run("should read reflog entries", func(t *testing.T) {
+		if got := resolve("@{1}"); got != second {
+			t.Fatalf("got %s, want %s", got, second)
+		}
+		if got := resolve("main@{0}"); got != merge {
+			t.Fatalf("got %s, want %s", got, merge)
+		}
+		if _, err := resolveRevision("test", "main@{9}"); err == nil || !strings.Contains(err.Error(), "only has 3 entries") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("should report ambiguous abbreviations", func(t *testing.T) {
+		seen := map[string]string{}
+		prefix := ""
+		for i := 0; prefix == ""; i++ {
+			hash, err := store.Put("blob", []byte(strconv.Itoa(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := seen[hash[:4]]; ok {
+				prefix = hash[:4]
+			}
+			seen[hash[:4]] = hash
+		}
+		_, err := resolveRevision("test", prefix)
+		if err == nil || !errors.Is(err, errAmbiguousObject) || !strings.Contains(err.Error(), prefix) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got := abbrev(store, seen[prefix], 4); len(got) <= 4 || !strings.HasPrefix(seen[prefix], got) {
+			t.Fatalf("abbreviation %s is not unique", got)
+		}
+	})
+
+	t.Run("should print ranges and short names", func(t *testing.T) {
+		out, err := revParse("test", []string{"main~1..HEAD", "--abbrev-ref", "HEAD"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := merge + "\n^" + second + "\nmain\n"; out != want {
+			t.Fatalf("got %q, want %q", out, want)
+		}
+		if _, err := revParse("test", []string{"--verify", "HEAD", "HEAD~1"}); err == nil {
+			t.Fatal("expected single revision error")
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// add stages the files selected by the pathspecs: new and modified files are hashed
+// into the object store and tracked files that disappeared from disk are unstaged.
+// ignored files are only added with -f, -A stages the whole work tree.
+func add(runEnv string, args []string) error {
+	force, all := false, false
+	var paths []string
+	for _, arg := range args {
+		switch arg {
+		case "-f", "--force":
+			force = true
+		case "-A", "--all":
+			all = true
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return fmt.Errorf("add err: unknown option %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 && !all {
+		return errors.New("add err: nothing specified, nothing added")
+	}
+
+	specs, err := parsePathspecs(runEnv, paths)
+	if err != nil {
+		return fmt.Errorf("add err: %w", err)
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return fmt.Errorf("add err: %w", err)
+	}
+	store := newObjectStore(runEnv)
+	ignore := newIgnoreMatcher(runEnv)
+
+	files, err := walkWorktree(runEnv, ignore)
+	if err != nil {
+		return fmt.Errorf("add err: %w", err)
+	}
+	matched := make([]bool, len(specs))
+	mark := func(p string) bool {
+		hit := len(specs) == 0
+		for i, s := range specs {
+			if s.matches(p) {
+				matched[i] = true
+				hit = true
+			}
+		}
+		return hit
+	}
+
+	// explicitly named ignored files are an error unless forced, git does the same
+	var ignoredPaths []string
+	for _, s := range specs {
+		if s.path == "" || s.glob != nil {
+			continue
+		}
+		info, err := os.Lstat(worktreePath(runEnv, s.path))
+		if err != nil || info.IsDir() || !ignore.ignored(s.path, false) || idx.find(s.path) != nil {
+			continue
+		}
+		if !force {
+			ignoredPaths = append(ignoredPaths, s.path)
+			continue
+		}
+		files = append(files, worktreeFile{path: s.path, info: info})
+	}
+	if len(ignoredPaths) > 0 {
+		return fmt.Errorf("add err: the following paths are ignored by one of your .gitignore files:\n%s\nuse -f if you really want to add them", strings.Join(ignoredPaths, "\n"))
+	}
+
+	stage := func(p string, info os.FileInfo) error {
+		if e := idx.find(p); e != nil && e.statMatches(info) {
+			return nil
+		}
+		data, err := readWorktreeFile(worktreePath(runEnv, p), info)
+		if err != nil {
+			return fmt.Errorf("add err: failed to read %s: %w", p, err)
+		}
+		hash, err := store.Put("blob", data)
+		if err != nil {
+			return fmt.Errorf("add err: failed to store %s: %w", p, err)
+		}
+		idx.set(newIndexEntry(p, info, hash))
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, f := range files {
+		if !mark(f.path) {
+			continue
+		}
+		seen[f.path] = true
+		if err := stage(f.path, f.info); err != nil {
+			return err
+		}
+	}
+
+	// ignore rules never apply to tracked files, and tracked files gone from disk get unstaged
+	for _, e := range append([]*IndexEntry{}, idx.Entries...) {
+		if seen[e.Path] || !mark(e.Path) {
+			continue
+		}
+		info, err := os.Lstat(worktreePath(runEnv, e.Path))
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			idx.remove(e.Path)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("add err: %w", err)
+		}
+		// a file replaced by a directory is gone too, its contents were staged above
+		if info.IsDir() && e.Mode != modeGitlink {
+			idx.remove(e.Path)
+			continue
+		}
+		if info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+			if err := stage(e.Path, info); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, s := range specs {
+		if !matched[i] {
+			return fmt.Errorf("add err: pathspec '%s' did not match any files", s.arg)
+		}
+	}
+	if err := idx.write(runEnv); err != nil {
+		return fmt.Errorf("add err: %w", err)
+	}
+	return nil
+}
+
+// rm unstages the selected paths and deletes them from the work tree unless --cached.
+// directories need -r and files with unstaged modifications need -f.
+func rm(runEnv string, args []string) (string, error) {
+	cached, recursive, force, quiet := false, false, false, false
+	var paths []string
+	for _, arg := range args {
+		switch arg {
+		case "--cached":
+			cached = true
+		case "-r":
+			recursive = true
+		case "-f", "--force":
+			force = true
+		case "-q", "--quiet":
+			quiet = true
+		case "-rf", "-fr":
+			recursive, force = true, true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return "", fmt.Errorf("rm err: unknown option %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		return "", errors.New("rm err: no pathspec given, which files should I remove?")
+	}
+
+	specs, err := parsePathspecs(runEnv, paths)
+	if err != nil {
+		return "", fmt.Errorf("rm err: %w", err)
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return "", fmt.Errorf("rm err: %w", err)
+	}
+
+	var targets []*IndexEntry
+	for _, s := range specs {
+		found := false
+		for _, e := range idx.Entries {
+			if !s.matches(e.Path) {
+				continue
+			}
+			if e.Path != s.path && s.glob == nil && !recursive {
+				return "", fmt.Errorf("rm err: not removing '%s' recursively without -r", s.arg)
+			}
+			found = true
+			targets = append(targets, e)
+		}
+		if !found {
+			return "", fmt.Errorf("rm err: pathspec '%s' did not match any files", s.arg)
+		}
+	}
+
+	if !force && !cached {
+		var modified []string
+		for _, e := range targets {
+			if _, err := os.Lstat(worktreePath(runEnv, e.Path)); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			changed, err := worktreeChanged(runEnv, e)
+			if err != nil {
+				return "", fmt.Errorf("rm err: %w", err)
+			}
+			if changed {
+				modified = append(modified, e.Path)
+			}
+		}
+		if len(modified) > 0 {
+			return "", fmt.Errorf("rm err: the following files have local modifications:\n    %s\n(use --cached to keep the file, or -f to force removal)", strings.Join(modified, "\n    "))
+		}
+	}
+
+	var sb strings.Builder
+	for _, e := range targets {
+		if !idx.remove(e.Path) {
+			continue
+		}
+		if !quiet {
+			fmt.Fprintf(&sb, "rm '%s'\n", e.Path)
+		}
+		if cached {
+			continue
+		}
+		if err := os.Remove(worktreePath(runEnv, e.Path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("rm err: failed to remove %s: %w", e.Path, err)
+		}
+		removeEmptyParents(runEnv, e.Path)
+	}
+	if err := idx.write(runEnv); err != nil {
+		return "", fmt.Errorf("rm err: %w", err)
+	}
+	return sb.String(), nil
+}
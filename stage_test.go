@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setupWorktree creates a test repository under tmp/ with the given files
+func setupWorktree(t *testing.T, files map[string]string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := initialize("test"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		writeWorktree(t, name, content)
+	}
+}
+
+func writeWorktree(t *testing.T, name, content string) {
+	t.Helper()
+	full := filepath.Join("tmp", filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stagedPaths(t *testing.T) []string {
+	t.Helper()
+	idx, err := readIndex("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range idx.Entries {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestAdd(t *testing.T) {
+	setupWorktree(t, map[string]string{
+		".gitignore":        "build/\n*.log\n!keep.log\n/root-only.txt\n",
+		"main.go":           "package main\n",
+		"src/lib.go":        "package src\n",
+		"src/root-only.txt": "nested so not ignored\n",
+		"root-only.txt":     "ignored\n",
+		"build/out":         "binary\n",
+		"debug.log":         "noise\n",
+		"keep.log":          "kept\n",
+	})
+
+	if err := add("test", []string{"tmp"}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	want := []string{".gitignore", "keep.log", "main.go", "src/lib.go", "src/root-only.txt"}
+	if got := stagedPaths(t); !slices.Equal(got, want) {
+		t.Fatalf("staged %v, want %v", got, want)
+	}
+	if e := mustFind(t, "main.go"); e.Hash != hashFor("blob", []byte("package main\n")) || !newObjectStore("test").Has(e.Hash) {
+		t.Fatalf("blob for main.go not stored: %+v", e)
+	}
+
+	t.Run("should refuse ignored files without -f", func(t *testing.T) {
+		if err := add("test", []string{"tmp/debug.log"}); err == nil || !strings.Contains(err.Error(), "ignored") {
+			t.Fatalf("expected ignored error, got %v", err)
+		}
+		if err := add("test", []string{"-f", "tmp/debug.log"}); err != nil {
+			t.Fatal(err)
+		}
+		if mustFind(t, "debug.log") == nil {
+			t.Fatal("forced add did not stage debug.log")
+		}
+	})
+
+	t.Run("should restage modified files and unstage deleted ones", func(t *testing.T) {
+		writeWorktree(t, "main.go", "package main\n\nfunc main() {}\n")
+		if err := os.Remove("tmp/src/lib.go"); err != nil {
+			t.Fatal(err)
+		}
+		if err := add("test", []string{"-A"}); err != nil {
+			t.Fatal(err)
+		}
+		if e := mustFind(t, "main.go"); e.Hash != hashFor("blob", []byte("package main\n\nfunc main() {}\n")) {
+			t.Fatal("modified file was not restaged")
+		}
+		if slices.Contains(stagedPaths(t), "src/lib.go") {
+			t.Fatal("deleted file is still staged")
+		}
+	})
+
+	t.Run("should unstage files replaced by directories and the other way round", func(t *testing.T) {
+		if err := os.Remove("tmp/main.go"); err != nil {
+			t.Fatal(err)
+		}
+		writeWorktree(t, "main.go/inner.go", "package inner\n")
+		if err := os.RemoveAll("tmp/src"); err != nil {
+			t.Fatal(err)
+		}
+		writeWorktree(t, "src", "now a file\n")
+		if err := add("test", []string{"-A"}); err != nil {
+			t.Fatal(err)
+		}
+		staged := stagedPaths(t)
+		if slices.Contains(staged, "main.go") || slices.Contains(staged, "src/root-only.txt") {
+			t.Fatalf("replaced paths are still staged: %v", staged)
+		}
+		if !slices.Contains(staged, "main.go/inner.go") || !slices.Contains(staged, "src") {
+			t.Fatalf("new paths were not staged: %v", staged)
+		}
+	})
+
+	t.Run("should fail on pathspecs that match nothing", func(t *testing.T) {
+		if err := add("test", []string{"tmp/missing.go"}); err == nil {
+			t.Fatal("expected pathspec error")
+		}
+	})
+
+	t.Run("should treat a reversed range as matching nothing", func(t *testing.T) {
+		if err := add("test", []string{"tmp/[z-a]"}); err == nil || !strings.Contains(err.Error(), "did not match") {
+			t.Fatalf("expected pathspec error, got %v", err)
+		}
+	})
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	cases := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"[ab].txt", "b.txt", true},
+		{"[!ab].txt", "b.txt", false},
+		{"[^ab].txt", "c.txt", true},
+		{"[]]", "]", true},
+		{"[!]]", "]", false},
+		{"[a-c]", "b", true},
+		{"[z-a]", "b", false},
+		{"[!z-a]", "b", true},
+		{"[a-]", "-", true},
+		{"[[:digit:]]x", "7x", true},
+		{"[]", "[]", true},
+		{"[ab", "[ab", true},
+		{`[\]]`, "]", true},
+		{"[!a]", "/", false},
+	}
+	for _, c := range cases {
+		if got := compileGlob("", c.glob, false).MatchString(c.path); got != c.want {
+			t.Errorf("%q matching %q = %v, want %v", c.glob, c.path, got, c.want)
+		}
+	}
+}
+
+func mustFind(t *testing.T, path string) *IndexEntry {
+	t.Helper()
+	idx, err := readIndex("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx.find(path)
+}
+
+func TestRm(t *testing.T) {
+	setupWorktree(t, map[string]string{
+		"a.txt":       "a\n",
+		"dir/b.txt":   "b\n",
+		"dir/c/d.txt": "d\n",
+	})
+	if err := add("test", []string{"-A"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should need -r for directories", func(t *testing.T) {
+		if _, err := rm("test", []string{"tmp/dir"}); err == nil {
+			t.Fatal("expected recursive error")
+		}
+		out, err := rm("test", []string{"-r", "tmp/dir"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "rm 'dir/b.txt'\nrm 'dir/c/d.txt'\n" {
+			t.Fatalf("unexpected output %q", out)
+		}
+		if _, err := os.Stat("tmp/dir"); !os.IsNotExist(err) {
+			t.Fatal("emptied directory was not removed")
+		}
+	})
+
+	t.Run("should protect local modifications", func(t *testing.T) {
+		writeWorktree(t, "a.txt", "changed\n")
+		if _, err := rm("test", []string{"tmp/a.txt"}); err == nil {
+			t.Fatal("expected local modification error")
+		}
+		if _, err := rm("test", []string{"--cached", "tmp/a.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		if len(stagedPaths(t)) != 0 {
+			t.Fatalf("index not empty: %v", stagedPaths(t))
+		}
+		if _, err := os.Stat("tmp/a.txt"); err != nil {
+			t.Fatal("--cached removed the file from disk")
+		}
+	})
+}
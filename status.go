@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// commitTreeHash returns the tree a commit points at
+func commitTreeHash(store *ObjectStore, hash string) (string, error) {
+	c, err := readCommit(store, hash)
+	if err != nil {
+		return "", err
+	}
+	return c.Tree, nil
+}
+
+// statusEntry is one tracked path that differs somewhere between HEAD, index and work tree.
+// staged and unstaged hold git's XY letters, ' ' meaning unchanged.
+type statusEntry struct {
+	path      string
+	staged    byte
+	unstaged  byte
+	headMode  uint32
+	indexMode uint32
+	wtMode    uint32
+	headHash  string
+	indexHash string
+	// the modes and hashes of stages 1 to 3 (base, ours and theirs) of a conflict
+	stageModes  [3]uint32
+	stageHashes [3]string
+}
+
+// repoStatus is everything status prints
+type repoStatus struct {
+	branch    string
+	head      string
+	entries   []statusEntry
+	untracked []string
+}
+
+// typeChanged is git's T status, the path switched between file, symlink and submodule
+func typeChanged(a, b uint32) bool {
+	kind := func(m uint32) uint32 {
+		if m == modeExecutable {
+			return modeFile
+		}
+		return m
+	}
+	return kind(a) != kind(b)
+}
+
+// collectStatus compares HEAD with the index and the index with the work tree.
+// untracked is "no", "normal" (untracked directories are collapsed) or "all".
+func collectStatus(runEnv, untracked string) (*repoStatus, error) {
+	store := newObjectStore(runEnv)
+	branch, head, err := headCommit(runEnv)
+	if err != nil {
+		return nil, err
+	}
+	headFiles := map[string]treeEntry{}
+	if head != "" {
+		tree, err := commitTreeHash(store, head)
+		if err != nil {
+			return nil, err
+		}
+		if headFiles, err = flattenTree(store, tree); err != nil {
+			return nil, err
+		}
+	}
+	idx, err := readIndex(runEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &repoStatus{branch: branch, head: head}
+	byPath := map[string]*statusEntry{}
+	entry := func(p string) *statusEntry {
+		if e, ok := byPath[p]; ok {
+			return e
+		}
+		e := &statusEntry{path: p, staged: ' ', unstaged: ' '}
+		if h, ok := headFiles[p]; ok {
+			e.headMode, e.headHash = h.mode, h.hash
+		}
+		byPath[p] = e
+		return e
+	}
+
+	refreshed := false
+	indexed := map[string]bool{}
+	for _, ie := range idx.Entries {
+		indexed[ie.Path] = true
+		if n := ie.stage(); n != 0 {
+			e := entry(ie.Path)
+			e.staged, e.unstaged = 'U', 'U'
+			e.stageModes[n-1], e.stageHashes[n-1] = ie.Mode, ie.Hash
+			if info, err := os.Lstat(worktreePath(runEnv, ie.Path)); err == nil {
+				e.wtMode = fileMode(info)
+			}
+			continue
+		}
+
+		h, inHead := headFiles[ie.Path]
+		switch {
+		case !inHead:
+			entry(ie.Path).staged = 'A'
+		case typeChanged(h.mode, ie.Mode):
+			entry(ie.Path).staged = 'T'
+		case h.hash != ie.Hash || h.mode != ie.Mode:
+			entry(ie.Path).staged = 'M'
+		}
+
+		full := worktreePath(runEnv, ie.Path)
+		info, err := os.Lstat(full)
+		if errors.Is(err, os.ErrNotExist) {
+			entry(ie.Path).unstaged = 'D'
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ie.statMatches(info) {
+			continue
+		}
+		if typeChanged(ie.Mode, fileMode(info)) {
+			e := entry(ie.Path)
+			e.unstaged, e.wtMode = 'T', fileMode(info)
+			continue
+		}
+		changed, err := worktreeChanged(runEnv, ie)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			e := entry(ie.Path)
+			e.unstaged, e.wtMode = 'M', fileMode(info)
+			continue
+		}
+		// same content with new stat data, remember it so the next run skips hashing
+		*ie = *newIndexEntry(ie.Path, info, ie.Hash)
+		refreshed = true
+	}
+	for p := range headFiles {
+		if !indexed[p] {
+			entry(p).staged = 'D'
+		}
+	}
+
+	for p, e := range byPath {
+		if e.staged == ' ' && e.unstaged == ' ' {
+			delete(byPath, p)
+			continue
+		}
+		if ie := idx.find(p); ie != nil {
+			e.indexMode, e.indexHash = ie.Mode, ie.Hash
+			if e.wtMode == 0 && e.unstaged != 'D' {
+				e.wtMode = ie.Mode
+			}
+		}
+		st.entries = append(st.entries, *e)
+	}
+	sort.Slice(st.entries, func(i, j int) bool { return st.entries[i].path < st.entries[j].path })
+
+	if untracked != "no" {
+		files, err := walkWorktree(runEnv, newIgnoreMatcher(runEnv))
+		if err != nil {
+			return nil, err
+		}
+		st.untracked = untrackedPaths(files, idx, untracked == "all")
+	}
+
+	if refreshed {
+		// best effort like git, another process holding the lock is not an error here
+		idx.write(runEnv)
+	}
+	return st, nil
+}
+
+// untrackedPaths lists files missing from the index, a directory without any tracked
+// file is shown once as "dir/" unless all is set
+func untrackedPaths(files []worktreeFile, idx *Index, all bool) []string {
+	trackedDirs := map[string]bool{}
+	tracked := map[string]bool{}
+	for _, e := range idx.Entries {
+		tracked[e.Path] = true
+		for i := 0; i < len(e.Path); i++ {
+			if e.Path[i] == '/' {
+				trackedDirs[e.Path[:i]] = true
+			}
+		}
+	}
+	var paths []string
+	seen := map[string]bool{}
+	for _, f := range files {
+		if tracked[f.path] {
+			continue
+		}
+		p := f.path
+		if !all {
+			for i := 0; i < len(f.path); i++ {
+				if f.path[i] == '/' && !trackedDirs[f.path[:i]] {
+					p = f.path[:i+1]
+					break
+				}
+			}
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+var statusLabels = map[byte]string{
+	'A': "new file:",
+	'M': "modified:",
+	'D': "deleted:",
+	'T': "typechange:",
+	'U': "both modified:",
+}
+
+// status prints the differences between HEAD, the index and the work tree.
+// --porcelain[=v1|v2] and -s/--short give the machine readable formats, -b/--branch adds
+// the branch header to them and -u/--untracked-files=no|normal|all controls untracked files.
+func status(runEnv string, args []string) (string, error) {
+	format, branchHeader, untracked := "long", false, "normal"
+	for _, arg := range args {
+		switch {
+		case arg == "-s" || arg == "--short" || arg == "--porcelain" || arg == "--porcelain=v1":
+			format = "v1"
+		case arg == "--porcelain=v2":
+			format = "v2"
+		case arg == "-b" || arg == "--branch":
+			branchHeader = true
+		case arg == "-u" || arg == "-uall" || arg == "--untracked-files" || arg == "--untracked-files=all":
+			untracked = "all"
+		case arg == "-uno" || arg == "--untracked-files=no":
+			untracked = "no"
+		case arg == "-unormal" || arg == "--untracked-files=normal":
+			untracked = "normal"
+		default:
+			return "", fmt.Errorf("status err: unknown option %s", arg)
+		}
+	}
+
+	st, err := collectStatus(runEnv, untracked)
+	if err != nil {
+		return "", fmt.Errorf("status err: %w", err)
+	}
+	switch format {
+	case "v1":
+		return st.porcelainV1(branchHeader), nil
+	case "v2":
+		return st.porcelainV2(branchHeader), nil
+	}
+	return st.long(), nil
+}
+
+func (st *repoStatus) porcelainV1(branchHeader bool) string {
+	var sb strings.Builder
+	if branchHeader {
+		switch {
+		case st.branch == "":
+			sb.WriteString("## HEAD (no branch)\n")
+		case st.head == "":
+			fmt.Fprintf(&sb, "## No commits yet on %s\n", st.branch)
+		default:
+			fmt.Fprintf(&sb, "## %s\n", st.branch)
+		}
+	}
+	for _, e := range st.entries {
+		fmt.Fprintf(&sb, "%c%c %s\n", e.staged, e.unstaged, e.path)
+	}
+	for _, p := range st.untracked {
+		fmt.Fprintf(&sb, "?? %s\n", p)
+	}
+	return sb.String()
+}
+
+func (st *repoStatus) porcelainV2(branchHeader bool) string {
+	var sb strings.Builder
+	if branchHeader {
+		oid := st.head
+		if oid == "" {
+			oid = "(initial)"
+		}
+		name := st.branch
+		if name == "" {
+			name = "(detached)"
+		}
+		fmt.Fprintf(&sb, "# branch.oid %s\n# branch.head %s\n", oid, name)
+	}
+	dot := func(c byte) byte {
+		if c == ' ' {
+			return '.'
+		}
+		return c
+	}
+	orZero := func(h string) string {
+		if h == "" {
+			return zeroHash
+		}
+		return h
+	}
+	for _, e := range st.entries {
+		if e.staged == 'U' {
+			fmt.Fprintf(&sb, "u UU N... %06o %06o %06o %06o %s %s %s %s\n",
+				e.stageModes[0], e.stageModes[1], e.stageModes[2], e.wtMode,
+				orZero(e.stageHashes[0]), orZero(e.stageHashes[1]), orZero(e.stageHashes[2]), e.path)
+			continue
+		}
+		fmt.Fprintf(&sb, "1 %c%c N... %06o %06o %06o %s %s %s\n",
+			dot(e.staged), dot(e.unstaged), e.headMode, e.indexMode, e.wtMode, orZero(e.headHash), orZero(e.indexHash), e.path)
+	}
+	for _, p := range st.untracked {
+		fmt.Fprintf(&sb, "? %s\n", p)
+	}
+	return sb.String()
+}
+
+func (st *repoStatus) long() string {
+	var sb strings.Builder
+	switch {
+	case st.branch == "":
+		fmt.Fprintf(&sb, "HEAD detached at %s\n", st.head[:7])
+	default:
+		fmt.Fprintf(&sb, "On branch %s\n", st.branch)
+	}
+	if st.head == "" {
+		sb.WriteString("\nNo commits yet\n\n")
+	}
+
+	section := func(title string, pick func(statusEntry) byte) bool {
+		var lines strings.Builder
+		for _, e := range st.entries {
+			if c := pick(e); c != ' ' {
+				fmt.Fprintf(&lines, "\t%-12s%s\n", statusLabels[c], e.path)
+			}
+		}
+		if lines.Len() == 0 {
+			return false
+		}
+		fmt.Fprintf(&sb, "%s:\n%s\n", title, lines.String())
+		return true
+	}
+	section("Unmerged paths", func(e statusEntry) byte {
+		if e.staged == 'U' {
+			return 'U'
+		}
+		return ' '
+	})
+	notMerged := func(c byte) byte {
+		if c == 'U' {
+			return ' '
+		}
+		return c
+	}
+	staged := section("Changes to be committed", func(e statusEntry) byte { return notMerged(e.staged) })
+	unstaged := section("Changes not staged for commit", func(e statusEntry) byte { return notMerged(e.unstaged) })
+	if len(st.untracked) > 0 {
+		fmt.Fprintf(&sb, "Untracked files:\n\t%s\n\n", strings.Join(st.untracked, "\n\t"))
+	}
+
+	switch {
+	case staged:
+	case unstaged:
+		sb.WriteString("no changes added to commit\n")
+	case len(st.untracked) > 0:
+		sb.WriteString("nothing added to commit but untracked files present\n")
+	case st.head == "":
+		sb.WriteString("nothing to commit\n")
+	default:
+		sb.WriteString("nothing to commit, working tree clean\n")
+	}
+	return sb.String()
+}
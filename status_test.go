@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	setupWorktree(t, map[string]string{
+		"a.txt":      "a\n",
+		"dir/b.txt":  "b\n",
+		"gone.txt":   "gone\n",
+		"notes/x.md": "x\n",
+	})
+	if err := add("test", []string{"tmp/a.txt", "tmp/dir", "tmp/gone.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should list everything as new before the first commit", func(t *testing.T) {
+		out, err := status("test", []string{"--porcelain", "-b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "## No commits yet on main\nA  a.txt\nA  dir/b.txt\nA  gone.txt\n?? notes/\n"
+		if out != want {
+			t.Fatalf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("should survive malformed bracket patterns in .gitignore", func(t *testing.T) {
+		writeWorktree(t, ".gitignore", "[]\n[z-a]\n[\n")
+		defer os.Remove("tmp/.gitignore")
+		out, err := status("test", []string{"--porcelain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "A  a.txt\nA  dir/b.txt\nA  gone.txt\n?? .gitignore\n?? notes/\n"; out != want {
+			t.Fatalf("got %q, want %q", out, want)
+		}
+	})
+
+	// commit the index by hand so HEAD has a tree to compare against
+	tree, err := writeTree("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newObjectStore("test")
+	commit, err := store.Put("commit", fmt.Appendf(nil, "tree %s\nauthor a <a> 0 +0000\ncommitter a <a> 0 +0000\n\ninit\n", tree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("tmp/.git/refs/heads", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("tmp/.git/refs/heads/main", []byte(commit+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should separate staged and unstaged changes", func(t *testing.T) {
+		writeWorktree(t, "a.txt", "staged\n")
+		if err := add("test", []string{"tmp/a.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		writeWorktree(t, "a.txt", "staged and changed again\n")
+		writeWorktree(t, "new.txt", "new\n")
+		if err := add("test", []string{"tmp/new.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove("tmp/gone.txt"); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := status("test", []string{"-s", "-uall"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "MM a.txt\n D gone.txt\nA  new.txt\n?? notes/x.md\n"
+		if out != want {
+			t.Fatalf("got %q, want %q", out, want)
+		}
+
+		out, err = status("test", []string{"-uno"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = "On branch main\nChanges to be committed:\n\tmodified:   a.txt\n\tnew file:   new.txt\n\n" +
+			"Changes not staged for commit:\n\tmodified:   a.txt\n\tdeleted:    gone.txt\n\n"
+		if out != want {
+			t.Fatalf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("should print modes and hashes in porcelain v2", func(t *testing.T) {
+		out, err := status("test", []string{"--porcelain=v2", "--branch", "--untracked-files=no"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		staged := hashFor("blob", []byte("staged\n"))
+		want := fmt.Sprintf("# branch.oid %s\n# branch.head main\n", commit) +
+			fmt.Sprintf("1 MM N... 100644 100644 100644 %s %s a.txt\n", hashFor("blob", []byte("a\n")), staged) +
+			fmt.Sprintf("1 .D N... 100644 100644 000000 %s %s gone.txt\n", hashFor("blob", []byte("gone\n")), hashFor("blob", []byte("gone\n"))) +
+			fmt.Sprintf("1 A. N... 000000 100644 100644 %s %s new.txt\n", zeroHash, hashFor("blob", []byte("new\n")))
+		if out != want {
+			t.Fatalf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("should print the conflict stages of unmerged entries", func(t *testing.T) {
+		idx, err := readIndex("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx.remove("a.txt")
+		base, ours, theirs := hashFor("blob", []byte("a\n")), hashFor("blob", []byte("ours\n")), hashFor("blob", []byte("theirs\n"))
+		for stage, hash := range []string{base, ours, theirs} {
+			idx.Entries = append(idx.Entries, &IndexEntry{Path: "a.txt", Mode: modeFile, Hash: hash, Flags: uint16(stage+1) << indexStageShift})
+		}
+		idx.sort()
+		if err := idx.write("test"); err != nil {
+			t.Fatal(err)
+		}
+		out, err := status("test", []string{"--porcelain=v2", "--untracked-files=no"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("u UU N... 100644 100644 100644 100644 %s %s %s a.txt\n", base, ours, theirs); !strings.HasPrefix(out, want) {
+			t.Fatalf("got %q, want it to start with %q", out, want)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// treeEntry is one `mode name\0sha` record of a tree object
+type treeEntry struct {
+	mode uint32
+	name string
+	hash string
+}
+
+func (e treeEntry) isTree() bool {
+	return e.mode == modeTree
+}
+
+// objectType is the type of the object the entry points at
+func (e treeEntry) objectType() string {
+	switch e.mode {
+	case modeTree:
+		return "tree"
+	case modeGitlink:
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
+func parseTree(payload []byte) ([]treeEntry, error) {
+	var entries []treeEntry
+	i := 0
+	for i < len(payload) {
+		j := bytes.IndexByte(payload[i:], ' ')
+		if j < 0 {
+			return nil, errors.New("invalid tree: missing space for mode")
+		}
+		mode, err := strconv.ParseUint(string(payload[i:i+j]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tree: bad mode %q", payload[i:i+j])
+		}
+		i += j + 1
+
+		k := bytes.IndexByte(payload[i:], 0x00)
+		if k < 0 {
+			return nil, errors.New("invalid tree: missing null after filename")
+		}
+		name := string(payload[i : i+k])
+		i += k + 1
+
+		if i+20 > len(payload) {
+			return nil, errors.New("invalid tree: truncated sha1")
+		}
+		entries = append(entries, treeEntry{mode: uint32(mode), name: name, hash: hex.EncodeToString(payload[i : i+20])})
+		i += 20
+	}
+	return entries, nil
+}
+
+// treeNameLess is git's tree order, directories sort as if their name ended in '/'
+func treeNameLess(a, b treeEntry) bool {
+	an, bn := a.name, b.name
+	if a.isTree() {
+		an += "/"
+	}
+	if b.isTree() {
+		bn += "/"
+	}
+	return an < bn
+}
+
+func serializeTree(entries []treeEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool { return treeNameLess(entries[i], entries[j]) })
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%o %s\x00", e.mode, e.name)
+		raw, _ := hex.DecodeString(e.hash)
+		buf.Write(raw)
+	}
+	return buf.Bytes()
+}
+
+// writeIndexTree writes the trees for the sorted entries that all live below dir
+// (a slash terminated repo path, "" for the top level) and returns the tree hash
+func writeIndexTree(store *ObjectStore, entries []*IndexEntry, dir string) (string, error) {
+	var tree []treeEntry
+	for i := 0; i < len(entries); {
+		rest := strings.TrimPrefix(entries[i].Path, dir)
+		slash := strings.IndexByte(rest, '/')
+		if slash < 0 {
+			e := entries[i]
+			if e.Mode != modeGitlink && !store.Has(e.Hash) {
+				return "", fmt.Errorf("invalid object %06o %s for '%s'", e.Mode, e.Hash, e.Path)
+			}
+			tree = append(tree, treeEntry{mode: e.Mode, name: rest, hash: e.Hash})
+			i++
+			continue
+		}
+		// everything sharing this first component goes into one subtree
+		sub := dir + rest[:slash+1]
+		j := i
+		for j < len(entries) && strings.HasPrefix(entries[j].Path, sub) {
+			j++
+		}
+		hash, err := writeIndexTree(store, entries[i:j], sub)
+		if err != nil {
+			return "", err
+		}
+		tree = append(tree, treeEntry{mode: modeTree, name: rest[:slash], hash: hash})
+		i = j
+	}
+	return store.Put("tree", serializeTree(tree))
+}
+
+// readTree loads and parses a tree object
+func readTree(store *ObjectStore, hash string) ([]treeEntry, error) {
+	kind, payload, err := store.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "tree" {
+		return nil, fmt.Errorf("%s is a %s, not a tree", hash, kind)
+	}
+	return parseTree(payload)
+}
+
+// walkTree calls visit for every entry below the tree with its repo path, a tree is only
+// descended into when visit returns true for it
+func walkTree(store *ObjectStore, hash, dir string, visit func(e treeEntry, p string) (bool, error)) error {
+	entries, err := readTree(store, hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := dir + e.name
+		descend, err := visit(e, p)
+		if err != nil {
+			return err
+		}
+		if descend && e.isTree() {
+			if err := walkTree(store, e.hash, p+"/", visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flattenTree maps every non tree path below the tree to its entry, names become repo paths
+func flattenTree(store *ObjectStore, hash string) (map[string]treeEntry, error) {
+	files := map[string]treeEntry{}
+	if hash == "" {
+		return files, nil
+	}
+	return files, walkTree(store, hash, "", func(e treeEntry, p string) (bool, error) {
+		if !e.isTree() {
+			files[p] = treeEntry{mode: e.mode, name: p, hash: e.hash}
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// workTree is the directory holding the checked out files, the parent of the git dir
+func workTree(runEnv string) string {
+	return filepath.Dir(gitDir(runEnv))
+}
+
+// worktreePath turns a repo relative slash path into a path on disk
+func worktreePath(runEnv, p string) string {
+	return filepath.Join(workTree(runEnv), filepath.FromSlash(p))
+}
+
+// toRepoPath turns a command line path relative to the current dir into a repo relative
+// slash separated path, "" stands for the whole work tree
+func toRepoPath(runEnv, arg string) (string, error) {
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(workTree(runEnv))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is outside repository", arg)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// pathspec is a parsed command line path that may be a file, a directory or a glob
+type pathspec struct {
+	arg  string
+	path string
+	glob *regexp.Regexp
+}
+
+func parsePathspecs(runEnv string, args []string) ([]pathspec, error) {
+	specs := make([]pathspec, 0, len(args))
+	for _, arg := range args {
+		p, err := toRepoPath(runEnv, arg)
+		if err != nil {
+			return nil, err
+		}
+		spec := pathspec{arg: arg, path: p}
+		if strings.ContainsAny(p, "*?[") {
+			spec.glob = compileGlob("", p, true)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func (s pathspec) matches(p string) bool {
+	if s.glob != nil && s.glob.MatchString(p) {
+		return true
+	}
+	return s.path == "" || p == s.path || strings.HasPrefix(p, s.path+"/")
+}
+
+// matchAny reports whether p is selected by any spec, no specs select everything
+func matchAny(specs []pathspec, p string) bool {
+	if len(specs) == 0 {
+		return true
+	}
+	for _, s := range specs {
+		if s.matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// worktreeFile is a file found while walking the work tree
+type worktreeFile struct {
+	path string
+	info os.FileInfo
+}
+
+// walkWorktree lists every file below the work tree that is not ignored, sorted by path.
+// directories holding their own .git are nested repositories and are skipped.
+func walkWorktree(runEnv string, ignore *ignoreMatcher) ([]worktreeFile, error) {
+	root := workTree(runEnv)
+	var files []worktreeFile
+	err := filepath.WalkDir(root, func(full string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if full == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, full)
+		if err != nil {
+			return err
+		}
+		p := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" || ignore.ignored(p, true) {
+				return filepath.SkipDir
+			}
+			if _, err := os.Lstat(filepath.Join(full, ".git")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignore.ignored(p, false) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		files = append(files, worktreeFile{path: p, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk work tree: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// readWorktreeFile returns what git stores for a file, the target for symlinks
+func readWorktreeFile(full string, info os.FileInfo) ([]byte, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(full)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(target), nil
+	}
+	return os.ReadFile(full)
+}
+
+// worktreeChanged reports whether the file on disk differs from the staged entry,
+// the stat data short circuits hashing for files nobody touched
+func worktreeChanged(runEnv string, e *IndexEntry) (bool, error) {
+	full := worktreePath(runEnv, e.Path)
+	info, err := os.Lstat(full)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	if e.statMatches(info) {
+		return false, nil
+	}
+	if fileMode(info) != e.Mode || int64(e.Size) != info.Size()&0xffffffff {
+		return true, nil
+	}
+	data, err := readWorktreeFile(full, info)
+	if err != nil {
+		return false, err
+	}
+	return hashFor("blob", data) != e.Hash, nil
+}
+
+// removeEmptyParents deletes the directories left empty after removing p from the work tree
+func removeEmptyParents(runEnv, p string) {
+	for dir := filepath.Dir(filepath.FromSlash(p)); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if err := os.Remove(filepath.Join(workTree(runEnv), dir)); err != nil {
+			return
+		}
+	}
+}